@@ -0,0 +1,116 @@
+package apierr
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"server/reqctx"
+)
+
+const (
+    CodeBadRequest       = "bad_request"
+    CodeValidation       = "validation_failed"
+    CodeUnauthorized     = "unauthorized"
+    CodeForbidden        = "forbidden"
+    CodeNotFound         = "not_found"
+    CodeMethodNotAllowed = "method_not_allowed"
+    CodeConflict         = "conflict"
+    CodeInternal         = "internal_error"
+    CodeUpstream         = "upstream_unavailable"
+)
+
+type FieldError struct {
+    Field   string `json:"field"`
+    Message string `json:"message"`
+}
+
+// Error is an API error with a stable machine-readable code. Err keeps the
+// underlying cause for logs and is never sent to the client.
+type Error struct {
+    Status int
+    Code   string
+    Detail string
+    Fields []FieldError
+    Err    error
+}
+
+func (e *Error) Error() string {
+    if e.Err != nil {
+        return fmt.Sprintf("%s: %v", e.Code, e.Err)
+    }
+    return e.Code + ": " + e.Detail
+}
+
+func (e *Error) Unwrap() error {
+    return e.Err
+}
+
+func New(status int, code, detail string) *Error {
+    return &Error{Status: status, Code: code, Detail: detail}
+}
+
+func BadRequest(code, detail string) *Error {
+    return New(http.StatusBadRequest, code, detail)
+}
+
+func Unauthorized(code, detail string) *Error {
+    return New(http.StatusUnauthorized, code, detail)
+}
+
+func NotFound(code, detail string) *Error {
+    return New(http.StatusNotFound, code, detail)
+}
+
+func Conflict(code, detail string) *Error {
+    return New(http.StatusConflict, code, detail)
+}
+
+func Validation(fields ...FieldError) *Error {
+    e := New(http.StatusUnprocessableEntity, CodeValidation, "The request contains invalid fields")
+    e.Fields = fields
+    return e
+}
+
+func Internal(err error) *Error {
+    return &Error{
+        Status: http.StatusInternalServerError,
+        Code:   CodeInternal,
+        Detail: "An unexpected error occurred",
+        Err:    err,
+    }
+}
+
+func Upstream(detail string, err error) *Error {
+    return &Error{Status: http.StatusBadGateway, Code: CodeUpstream, Detail: detail, Err: err}
+}
+
+// Problem is the RFC 7807 response body
+type Problem struct {
+    Type      string       `json:"type"`
+    Title     string       `json:"title"`
+    Status    int          `json:"status"`
+    Detail    string       `json:"detail,omitempty"`
+    Instance  string       `json:"instance,omitempty"`
+    Code      string       `json:"code"`
+    RequestID string       `json:"requestId,omitempty"`
+    Errors    []FieldError `json:"errors,omitempty"`
+}
+
+func Write(w http.ResponseWriter, r *http.Request, e *Error) {
+    problem := Problem{
+        Type:      "/problems/" + e.Code,
+        Title:     http.StatusText(e.Status),
+        Status:    e.Status,
+        Detail:    e.Detail,
+        Instance:  r.URL.Path,
+        Code:      e.Code,
+        RequestID: reqctx.RequestID(r.Context()),
+        Errors:    e.Fields,
+    }
+
+    w.Header().Set("Content-Type", "application/problem+json")
+    w.Header().Set("X-Content-Type-Options", "nosniff")
+    w.WriteHeader(e.Status)
+    json.NewEncoder(w).Encode(problem)
+}
\ No newline at end of file
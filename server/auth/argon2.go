@@ -1,9 +1,11 @@
 package auth
 
 import (
+	"context"
 	"crypto/rand"
 	"crypto/subtle"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"strings"
 
@@ -26,6 +28,32 @@ var DefaultParams = &Argon2Params{
     KeyLength:   32,
 }
 
+var (
+    ErrHashBusy           = errors.New("too many password hashes in progress")
+    ErrUnsupportedVersion = errors.New("unsupported argon2 version")
+    ErrUnknownPepper      = errors.New("hash was made with a different pepper")
+)
+
+var hashSlots = make(chan struct{}, 4)
+
+// LimitConcurrentHashes caps how many Argon2 hashes run at once. Call it at
+// startup, before any request is served.
+func LimitConcurrentHashes(n int) {
+    hashSlots = make(chan struct{}, n)
+}
+
+// AcquireHashSlot waits for a free hashing slot until ctx is done. Callers
+// must hold a slot around GenerateHash and ComparePasswordAndHash.
+func AcquireHashSlot(ctx context.Context) (release func(), err error) {
+    slots := hashSlots
+    select {
+    case slots <- struct{}{}:
+        return func() { <-slots }, nil
+    case <-ctx.Done():
+        return nil, ErrHashBusy
+    }
+}
+
 func GenerateHash(password string, params *Argon2Params) (string, error) {
     salt := make([]byte, params.SaltLength)
     if _, err := rand.Read(salt); err != nil {
@@ -33,7 +61,7 @@ func GenerateHash(password string, params *Argon2Params) (string, error) {
     }
 
     hash := argon2.IDKey(
-        []byte(password),
+        pepperPassword(password),
         salt,
         params.Iterations,
         params.Memory,
@@ -41,12 +69,15 @@ func GenerateHash(password string, params *Argon2Params) (string, error) {
         params.KeyLength,
     )
 
+    paramString := fmt.Sprintf("m=%d,t=%d,p=%d", params.Memory, params.Iterations, params.Parallelism)
+    if pepperID != "" {
+        paramString += ",keyid=" + pepperID
+    }
+
     encoded := fmt.Sprintf(
-        "$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+        "$argon2id$v=%d$%s$%s$%s",
         argon2.Version,
-        params.Memory,
-        params.Iterations,
-        params.Parallelism,
+        paramString,
         base64.RawStdEncoding.EncodeToString(salt),
         base64.RawStdEncoding.EncodeToString(hash),
     )
@@ -55,54 +86,107 @@ func GenerateHash(password string, params *Argon2Params) (string, error) {
 }
 
 func ComparePasswordAndHash(password, encodedHash string) (bool, error) {
-    params, salt, hash, err := decodeHash(encodedHash)
+    h, err := decodeHash(encodedHash)
     if err != nil {
         return false, err
     }
 
+    // x/crypto only implements version 0x13. It differs from 0x10 in how
+    // later passes update memory, so single-pass 0x10 hashes still verify.
+    if h.version != argon2.Version && !(h.version == 0x10 && h.params.Iterations == 1) {
+        return false, ErrUnsupportedVersion
+    }
+
+    input := []byte(password)
+    if h.keyID != "" {
+        if h.keyID != pepperID {
+            return false, ErrUnknownPepper
+        }
+        input = pepperPassword(password)
+    }
+
     otherHash := argon2.IDKey(
-        []byte(password),
-        salt,
-        params.Iterations,
-        params.Memory,
-        params.Parallelism,
-        params.KeyLength,
+        input,
+        h.salt,
+        h.params.Iterations,
+        h.params.Memory,
+        h.params.Parallelism,
+        h.params.KeyLength,
     )
 
-    return subtle.ConstantTimeCompare(hash, otherHash) == 1, nil
+    return subtle.ConstantTimeCompare(h.hash, otherHash) == 1, nil
 }
 
-func decodeHash(encodedHash string) (*Argon2Params, []byte, []byte, error) {
+// NeedsRehash reports whether encodedHash was made with weaker parameters
+// than params, another Argon2 version, or without the current pepper.
+// Callers rehash after a successful comparison, while they have the password.
+func NeedsRehash(encodedHash string, params *Argon2Params) bool {
+    h, err := decodeHash(encodedHash)
+    if err != nil {
+        return true
+    }
+    return h.version != argon2.Version ||
+        h.keyID != pepperID ||
+        h.params.Memory < params.Memory ||
+        h.params.Iterations < params.Iterations ||
+        h.params.SaltLength < params.SaltLength ||
+        h.params.KeyLength < params.KeyLength
+}
+
+type decodedHash struct {
+    version int
+    params  *Argon2Params
+    keyID   string // Pepper fingerprint; empty if the hash is unpeppered
+    salt    []byte
+    hash    []byte
+}
+
+func decodeHash(encodedHash string) (*decodedHash, error) {
     parts := strings.Split(encodedHash, "$")
-    if len(parts) != 6 {
-        return nil, nil, nil, fmt.Errorf("invalid hash format")
+    if len(parts) != 6 || parts[1] != "argon2id" {
+        return nil, fmt.Errorf("invalid hash format")
     }
 
-    var version int
-    if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
-        return nil, nil, nil, err
+    h := &decodedHash{params: &Argon2Params{}}
+    if _, err := fmt.Sscanf(parts[2], "v=%d", &h.version); err != nil {
+        return nil, err
     }
 
-    params := &Argon2Params{}
-    if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d",
-        &params.Memory,
-        &params.Iterations,
-        &params.Parallelism,
-    ); err != nil {
-        return nil, nil, nil, err
+    for _, kv := range strings.Split(parts[3], ",") {
+        name, value, _ := strings.Cut(kv, "=")
+        var err error
+        switch name {
+        case "m":
+            _, err = fmt.Sscanf(value, "%d", &h.params.Memory)
+        case "t":
+            _, err = fmt.Sscanf(value, "%d", &h.params.Iterations)
+        case "p":
+            _, err = fmt.Sscanf(value, "%d", &h.params.Parallelism)
+        case "keyid":
+            h.keyID = value
+        default:
+            err = fmt.Errorf("unknown hash parameter %q", name)
+        }
+        if err != nil {
+            return nil, err
+        }
+    }
+    if h.params.Memory == 0 || h.params.Iterations == 0 || h.params.Parallelism == 0 {
+        return nil, fmt.Errorf("invalid hash parameters")
     }
 
-    salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+    var err error
+    h.salt, err = base64.RawStdEncoding.DecodeString(parts[4])
     if err != nil {
-        return nil, nil, nil, err
+        return nil, err
     }
-    params.SaltLength = uint32(len(salt))
+    h.params.SaltLength = uint32(len(h.salt))
 
-    hash, err := base64.RawStdEncoding.DecodeString(parts[5])
+    h.hash, err = base64.RawStdEncoding.DecodeString(parts[5])
     if err != nil {
-        return nil, nil, nil, err
+        return nil, err
     }
-    params.KeyLength = uint32(len(hash))
+    h.params.KeyLength = uint32(len(h.hash))
 
-    return params, salt, hash, nil
+    return h, nil
 }
\ No newline at end of file
@@ -1,26 +1,45 @@
 package auth
 
 import (
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 )
 
+const SigningKeySize = 32
+
+// Tokens carry their purpose so a 2FA challenge cannot be used as a session
 const (
-    TokenExpiry    = 24 * time.Hour
-    SigningKeySize = 32
+    purposeSession   = "session"
+    purposeChallenge = "2fa"
 )
 
 var (
+    TokenExpiry     = 24 * time.Hour
+    ChallengeExpiry = 5 * time.Minute
     ErrInvalidToken = errors.New("invalid token")
     ErrExpiredToken = errors.New("token has expired")
     signingKey      []byte
 )
 
-func InitJWT() error {
-    key := make([]byte, SigningKeySize)
+// InitJWT sets the key tokens are signed with. Without one a random key is
+// generated, and tokens stop being valid when the server restarts.
+func InitJWT(key []byte) error {
+    if len(key) > 0 {
+        if len(key) < SigningKeySize {
+            return fmt.Errorf("signing key must be at least %d bytes", SigningKeySize)
+        }
+        signingKey = key
+        return nil
+    }
+
+    key = make([]byte, SigningKeySize)
     if _, err := rand.Read(key); err != nil {
         return fmt.Errorf("failed to generate signing key: %w", err)
     }
@@ -30,13 +49,36 @@ func InitJWT() error {
 
 type Claims struct {
     UserID    int       `json:"uid"`
+    IssuedAt  time.Time `json:"iat"`
     ExpiresAt time.Time `json:"exp"`
+    Purpose   string    `json:"pur"`
 }
 
 func GenerateToken(userID int) (string, error) {
+    return generate(userID, purposeSession, TokenExpiry)
+}
+
+// GenerateChallengeToken proves the password was checked, and is exchanged
+// for a session token once the second factor is verified.
+func GenerateChallengeToken(userID int) (string, error) {
+    return generate(userID, purposeChallenge, ChallengeExpiry)
+}
+
+func ValidateToken(token string) (*Claims, error) {
+    return validate(token, purposeSession)
+}
+
+func ValidateChallengeToken(token string) (*Claims, error) {
+    return validate(token, purposeChallenge)
+}
+
+func generate(userID int, purpose string, expiry time.Duration) (string, error) {
+    now := time.Now()
     claims := Claims{
         UserID:    userID,
-        ExpiresAt: time.Now().Add(TokenExpiry),
+        IssuedAt:  now,
+        ExpiresAt: now.Add(expiry),
+        Purpose:   purpose,
     }
 
     token, err := encodeToken(claims)
@@ -47,11 +89,14 @@ func GenerateToken(userID int) (string, error) {
     return token, nil
 }
 
-func ValidateToken(token string) (*Claims, error) {
+func validate(token, purpose string) (*Claims, error) {
     claims, err := decodeToken(token)
     if err != nil {
         return nil, err
     }
+    if claims.Purpose != purpose {
+        return nil, ErrInvalidToken
+    }
 
     if time.Now().After(claims.ExpiresAt) {
         return nil, ErrExpiredToken
@@ -60,28 +105,59 @@ func ValidateToken(token string) (*Claims, error) {
     return claims, nil
 }
 
+func sign(payload []byte) []byte {
+    mac := hmac.New(sha256.New, signingKey)
+    mac.Write(payload)
+    return mac.Sum(nil)
+}
+
 func encodeToken(claims Claims) (string, error) {
-    timestamp := claims.ExpiresAt.Unix()
-    data := fmt.Sprintf("%d:%d", claims.UserID, timestamp)
-    return base64.URLEncoding.EncodeToString([]byte(data)), nil
+    if len(signingKey) == 0 {
+        return "", errors.New("signing key not initialized")
+    }
+    // Issue time in milliseconds, so revoking sessions right after a login
+    // does not also revoke the new one
+    data := []byte(fmt.Sprintf("%s:%d:%d:%d", claims.Purpose, claims.UserID,
+        claims.IssuedAt.UnixMilli(), claims.ExpiresAt.Unix()))
+    return base64.RawURLEncoding.EncodeToString(data) + "." +
+        base64.RawURLEncoding.EncodeToString(sign(data)), nil
 }
 
 func decodeToken(token string) (*Claims, error) {
-    data, err := base64.URLEncoding.DecodeString(token)
+    encoded, encodedSig, ok := strings.Cut(token, ".")
+    if !ok || len(signingKey) == 0 {
+        return nil, ErrInvalidToken
+    }
+    data, err := base64.RawURLEncoding.DecodeString(encoded)
     if err != nil {
         return nil, ErrInvalidToken
     }
+    sig, err := base64.RawURLEncoding.DecodeString(encodedSig)
+    if err != nil || !hmac.Equal(sig, sign(data)) {
+        return nil, ErrInvalidToken
+    }
 
-    var userID int64
-    var timestamp int64
-    
-    _, err = fmt.Sscanf(string(data), "%d:%d", &userID, &timestamp)
+    parts := strings.Split(string(data), ":")
+    if len(parts) != 4 {
+        return nil, ErrInvalidToken
+    }
+    userID, err := strconv.Atoi(parts[1])
+    if err != nil {
+        return nil, ErrInvalidToken
+    }
+    issuedAt, err := strconv.ParseInt(parts[2], 10, 64)
+    if err != nil {
+        return nil, ErrInvalidToken
+    }
+    expiresAt, err := strconv.ParseInt(parts[3], 10, 64)
     if err != nil {
         return nil, ErrInvalidToken
     }
 
     return &Claims{
-        UserID:    int(userID),
-        ExpiresAt: time.Unix(timestamp, 0),
+        UserID:    userID,
+        IssuedAt:  time.UnixMilli(issuedAt),
+        ExpiresAt: time.Unix(expiresAt, 0),
+        Purpose:   parts[0],
     }, nil
 }
\ No newline at end of file
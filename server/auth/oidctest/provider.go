@@ -0,0 +1,223 @@
+package oidctest
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const keyID = "mock"
+
+// A login between /authorize and /token
+type grant struct {
+    clientID    string
+    redirectURI string
+    nonce       string
+    challenge   string
+    email       string
+    expires     time.Time
+}
+
+// Provider is a minimal OpenID Connect provider. It signs in everyone who
+// reaches /authorize as its configured user, or as the login_hint, without
+// asking. Codes require PKCE with S256, and the ID token carries back the
+// nonce given to /authorize.
+type Provider struct {
+    issuer        string
+    email         string
+    emailVerified bool
+    key           *rsa.PrivateKey
+    mux           *http.ServeMux
+
+    mu     sync.Mutex
+    grants map[string]grant
+}
+
+// NewProvider creates a provider for issuer, the URL it will be reached at
+func NewProvider(issuer, email string, emailVerified bool) (*Provider, error) {
+    key, err := rsa.GenerateKey(rand.Reader, 2048)
+    if err != nil {
+        return nil, err
+    }
+    p := &Provider{
+        issuer:        strings.TrimRight(issuer, "/"),
+        email:         email,
+        emailVerified: emailVerified,
+        key:           key,
+        grants:        make(map[string]grant),
+    }
+
+    p.mux = http.NewServeMux()
+    p.mux.HandleFunc("GET /.well-known/openid-configuration", p.discovery)
+    p.mux.HandleFunc("GET /jwks", p.jwks)
+    p.mux.HandleFunc("GET /authorize", p.authorize)
+    p.mux.HandleFunc("POST /token", p.token)
+    return p, nil
+}
+
+func (p *Provider) Issuer() string {
+    return p.issuer
+}
+
+func (p *Provider) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    p.mux.ServeHTTP(w, r)
+}
+
+func (p *Provider) discovery(w http.ResponseWriter, r *http.Request) {
+    writeJSON(w, http.StatusOK, map[string]interface{}{
+        "issuer":                                p.issuer,
+        "authorization_endpoint":                p.issuer + "/authorize",
+        "token_endpoint":                        p.issuer + "/token",
+        "jwks_uri":                              p.issuer + "/jwks",
+        "response_types_supported":              []string{"code"},
+        "subject_types_supported":               []string{"public"},
+        "id_token_signing_alg_values_supported": []string{"RS256"},
+        "code_challenge_methods_supported":      []string{"S256"},
+        "scopes_supported":                      []string{"openid", "email", "profile"},
+    })
+}
+
+func (p *Provider) jwks(w http.ResponseWriter, r *http.Request) {
+    pub := p.key.PublicKey
+    writeJSON(w, http.StatusOK, map[string]interface{}{
+        "keys": []map[string]string{{
+            "kty": "RSA",
+            "use": "sig",
+            "alg": "RS256",
+            "kid": keyID,
+            "n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+            "e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+        }},
+    })
+}
+
+func (p *Provider) authorize(w http.ResponseWriter, r *http.Request) {
+    q := r.URL.Query()
+    redirect, err := url.Parse(q.Get("redirect_uri"))
+    if err != nil || redirect.Scheme == "" || q.Get("response_type") != "code" || q.Get("client_id") == "" {
+        http.Error(w, "redirect_uri, client_id and response_type=code are required", http.StatusBadRequest)
+        return
+    }
+    if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+        http.Error(w, "PKCE with S256 is required", http.StatusBadRequest)
+        return
+    }
+
+    email := p.email
+    if hint := q.Get("login_hint"); hint != "" {
+        email = hint
+    }
+    code := randomString()
+    p.mu.Lock()
+    p.grants[code] = grant{
+        clientID:    q.Get("client_id"),
+        redirectURI: redirect.String(),
+        nonce:       q.Get("nonce"),
+        challenge:   q.Get("code_challenge"),
+        email:       email,
+        expires:     time.Now().Add(time.Minute),
+    }
+    p.mu.Unlock()
+
+    params := redirect.Query()
+    params.Set("code", code)
+    params.Set("state", q.Get("state"))
+    redirect.RawQuery = params.Encode()
+    http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (p *Provider) token(w http.ResponseWriter, r *http.Request) {
+    if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "authorization_code" {
+        tokenError(w, "unsupported_grant_type")
+        return
+    }
+    clientID, _, ok := r.BasicAuth()
+    if !ok {
+        clientID = r.PostForm.Get("client_id")
+    }
+
+    code := r.PostForm.Get("code")
+    p.mu.Lock()
+    g, found := p.grants[code]
+    delete(p.grants, code)
+    p.mu.Unlock()
+
+    sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+    challenge := base64.RawURLEncoding.EncodeToString(sum[:])
+    switch {
+    case !found || time.Now().After(g.expires):
+        tokenError(w, "invalid_grant")
+        return
+    case clientID != g.clientID || r.PostForm.Get("redirect_uri") != g.redirectURI:
+        tokenError(w, "invalid_grant")
+        return
+    case subtle.ConstantTimeCompare([]byte(challenge), []byte(g.challenge)) != 1:
+        tokenError(w, "invalid_grant")
+        return
+    }
+
+    now := time.Now()
+    idToken, err := p.sign(map[string]interface{}{
+        "iss":            p.issuer,
+        "sub":            "mock|" + g.email,
+        "aud":            g.clientID,
+        "iat":            now.Unix(),
+        "exp":            now.Add(5 * time.Minute).Unix(),
+        "nonce":          g.nonce,
+        "email":          g.email,
+        "email_verified": p.emailVerified,
+    })
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+    writeJSON(w, http.StatusOK, map[string]interface{}{
+        "access_token": randomString(),
+        "token_type":   "Bearer",
+        "expires_in":   300,
+        "id_token":     idToken,
+    })
+}
+
+// Signs claims as a compact RS256 JWS
+func (p *Provider) sign(claims map[string]interface{}) (string, error) {
+    header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": keyID})
+    payload, err := json.Marshal(claims)
+    if err != nil {
+        return "", err
+    }
+    signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+    digest := sha256.Sum256([]byte(signingInput))
+    sig, err := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, digest[:])
+    if err != nil {
+        return "", err
+    }
+    return signingInput + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+func tokenError(w http.ResponseWriter, code string) {
+    writeJSON(w, http.StatusBadRequest, map[string]string{"error": code})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+    w.Header().Set("Content-Type", "application/json")
+    w.Header().Set("Cache-Control", "no-store")
+    w.WriteHeader(status)
+    json.NewEncoder(w).Encode(v)
+}
+
+func randomString() string {
+    b := make([]byte, 24)
+    rand.Read(b)
+    return base64.RawURLEncoding.EncodeToString(b)
+}
\ No newline at end of file
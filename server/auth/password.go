@@ -0,0 +1,62 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type PasswordPolicy struct {
+    MinLength     int
+    MaxLength     int
+    RequireUpper  bool
+    RequireLower  bool
+    RequireDigit  bool
+    RequireSymbol bool
+}
+
+// Check returns every rule password breaks, or nil if it is acceptable.
+// Length counts characters rather than bytes.
+func (p PasswordPolicy) Check(password, email string) []string {
+    var problems []string
+
+    length := utf8.RuneCountInString(password)
+    if length < p.MinLength {
+        problems = append(problems, fmt.Sprintf("must be at least %d characters", p.MinLength))
+    }
+    if length > p.MaxLength {
+        problems = append(problems, fmt.Sprintf("must be at most %d characters", p.MaxLength))
+    }
+
+    var upper, lower, digit, symbol bool
+    for _, c := range password {
+        switch {
+        case unicode.IsUpper(c):
+            upper = true
+        case unicode.IsLower(c):
+            lower = true
+        case unicode.IsDigit(c):
+            digit = true
+        case unicode.IsPunct(c) || unicode.IsSymbol(c) || unicode.IsSpace(c):
+            symbol = true
+        }
+    }
+    if p.RequireUpper && !upper {
+        problems = append(problems, "must contain an uppercase letter")
+    }
+    if p.RequireLower && !lower {
+        problems = append(problems, "must contain a lowercase letter")
+    }
+    if p.RequireDigit && !digit {
+        problems = append(problems, "must contain a digit")
+    }
+    if p.RequireSymbol && !symbol {
+        problems = append(problems, "must contain a symbol")
+    }
+
+    if email != "" && strings.EqualFold(strings.TrimSpace(password), strings.TrimSpace(email)) {
+        problems = append(problems, "must not be the same as the email address")
+    }
+    return problems
+}
\ No newline at end of file
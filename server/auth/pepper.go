@@ -0,0 +1,37 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+)
+
+var (
+    pepper   []byte
+    pepperID string
+)
+
+// SetPepper mixes a server-side secret into every new hash, so a leaked
+// users table cannot be cracked without the server config as well. Call it
+// at startup. Hashes record a fingerprint of the pepper in their keyid
+// parameter; existing unpeppered hashes keep working and are upgraded on the
+// next login.
+func SetPepper(p []byte) {
+    pepper = p
+    pepperID = ""
+    if len(p) > 0 {
+        sum := sha256.Sum256(p)
+        pepperID = base64.RawStdEncoding.EncodeToString(sum[:6])
+    }
+}
+
+// x/crypto does not expose Argon2's secret input, so the pepper is applied
+// as an HMAC of the password instead
+func pepperPassword(password string) []byte {
+    if len(pepper) == 0 {
+        return []byte(password)
+    }
+    mac := hmac.New(sha256.New, pepper)
+    mac.Write([]byte(password))
+    return mac.Sum(nil)
+}
\ No newline at end of file
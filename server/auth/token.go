@@ -0,0 +1,24 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+)
+
+// NewToken returns a random URL-safe token for single-use links, and the
+// hash to store in its place so a database leak does not expose live tokens.
+func NewToken() (token string, hash []byte, err error) {
+    b := make([]byte, 32)
+    if _, err := rand.Read(b); err != nil {
+        return "", nil, err
+    }
+    token = base64.RawURLEncoding.EncodeToString(b)
+    return token, HashToken(token), nil
+}
+
+// Tokens carry 256 bits of entropy, so a fast unsalted hash is enough
+func HashToken(token string) []byte {
+    sum := sha256.Sum256([]byte(token))
+    return sum[:]
+}
\ No newline at end of file
@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base32"
+	"strings"
+	"time"
+
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+)
+
+// RFC 6238 defaults, which is what authenticator apps assume
+var totpOpts = totp.ValidateOpts{
+    Period:    30,
+    Digits:    otp.DigitsSix,
+    Algorithm: otp.AlgorithmSHA1,
+}
+
+// NewTOTPKey returns a fresh secret and the otpauth:// URI to show as a QR code
+func NewTOTPKey(issuer, account string) (secret, uri string, err error) {
+    key, err := totp.Generate(totp.GenerateOpts{
+        Issuer:      issuer,
+        AccountName: account,
+        Period:      totpOpts.Period,
+        Digits:      totpOpts.Digits,
+        Algorithm:   totpOpts.Algorithm,
+    })
+    if err != nil {
+        return "", "", err
+    }
+    return key.Secret(), key.URL(), nil
+}
+
+// VerifyTOTP checks code against secret, allowing one period of clock drift
+// either way. It returns the time step that matched, so callers can refuse a
+// code that was already used.
+func VerifyTOTP(secret, code string, now time.Time) (step int64, ok bool) {
+    code = strings.TrimSpace(code)
+    if len(code) != totpOpts.Digits.Length() {
+        return 0, false
+    }
+
+    current := now.Unix() / int64(totpOpts.Period)
+    for _, skew := range []int64{0, -1, 1} {
+        step := current + skew
+        expected, err := totp.GenerateCodeCustom(secret, time.Unix(step*int64(totpOpts.Period), 0), totpOpts)
+        if err != nil {
+            return 0, false
+        }
+        if subtle.ConstantTimeCompare([]byte(code), []byte(expected)) == 1 {
+            return step, true
+        }
+    }
+    return 0, false
+}
+
+const (
+    RecoveryCodeCount = 10
+    recoveryIDLength  = 5
+)
+
+var recoveryEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewRecoveryCodes returns single-use codes formatted as "xxxxx-xxxxx".
+// The first group is stored in clear to find the code's hash, so a login
+// compares one Argon2 hash rather than all of them.
+func NewRecoveryCodes() ([]string, error) {
+    codes := make([]string, 0, RecoveryCodeCount)
+    seen := make(map[string]bool)
+    for len(codes) < RecoveryCodeCount {
+        b := make([]byte, 7)
+        if _, err := rand.Read(b); err != nil {
+            return nil, err
+        }
+        code := strings.ToLower(recoveryEncoding.EncodeToString(b))[:2*recoveryIDLength]
+        if seen[code[:recoveryIDLength]] {
+            continue
+        }
+        seen[code[:recoveryIDLength]] = true
+        codes = append(codes, code[:recoveryIDLength]+"-"+code[recoveryIDLength:])
+    }
+    return codes, nil
+}
+
+// ParseRecoveryCode normalises what the user typed and splits off the lookup
+// ID. The whole normalised code is what gets hashed.
+func ParseRecoveryCode(input string) (id, code string, ok bool) {
+    code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(input))
+    if len(code) != 2*recoveryIDLength {
+        return "", "", false
+    }
+    return code[:recoveryIDLength], code, true
+}
\ No newline at end of file
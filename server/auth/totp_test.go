@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pquerna/otp/totp"
+)
+
+const testSecret = "JBSWY3DPEHPK3PXP"
+
+// codeAt is the code an authenticator app shows at t
+func codeAt(t *testing.T, at time.Time) string {
+    t.Helper()
+    code, err := totp.GenerateCodeCustom(testSecret, at, totpOpts)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return code
+}
+
+func TestVerifyTOTP(t *testing.T) {
+    period := time.Duration(totpOpts.Period) * time.Second
+    now := time.Date(2024, 3, 1, 12, 0, 10, 0, time.UTC)
+    current := now.Unix() / int64(totpOpts.Period)
+
+    tests := []struct {
+        name     string
+        code     string
+        wantStep int64
+        wantOK   bool
+    }{
+        {"current step", codeAt(t, now), current, true},
+        {"previous step", codeAt(t, now.Add(-period)), current - 1, true},
+        {"next step", codeAt(t, now.Add(period)), current + 1, true},
+        {"surrounding spaces", " " + codeAt(t, now) + " ", current, true},
+        {"two steps back", codeAt(t, now.Add(-2*period)), 0, false},
+        {"two steps ahead", codeAt(t, now.Add(2*period)), 0, false},
+        {"too short", codeAt(t, now)[:5], 0, false},
+        {"too long", codeAt(t, now) + "0", 0, false},
+        {"empty", "", 0, false},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            step, ok := VerifyTOTP(testSecret, tt.code, now)
+            if ok != tt.wantOK || step != tt.wantStep {
+                t.Errorf("VerifyTOTP(%q) = %d, %v, want %d, %v", tt.code, step, ok, tt.wantStep, tt.wantOK)
+            }
+        })
+    }
+}
+
+func TestRecoveryCodes(t *testing.T) {
+    codes, err := NewRecoveryCodes()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(codes) != RecoveryCodeCount {
+        t.Fatalf("got %d codes, want %d", len(codes), RecoveryCodeCount)
+    }
+
+    ids := make(map[string]bool)
+    for _, c := range codes {
+        id, code, ok := ParseRecoveryCode(c)
+        if !ok {
+            t.Fatalf("ParseRecoveryCode(%q) failed", c)
+        }
+        if ids[id] {
+            t.Errorf("lookup ID %q used twice", id)
+        }
+        ids[id] = true
+
+        typed := []string{
+            strings.ToUpper(c),
+            strings.ReplaceAll(c, "-", ""),
+            strings.ReplaceAll(c, "-", " "),
+            " " + strings.ToUpper(strings.ReplaceAll(c, "-", " - ")) + " ",
+        }
+        for _, input := range typed {
+            gotID, gotCode, ok := ParseRecoveryCode(input)
+            if !ok || gotID != id || gotCode != code {
+                t.Errorf("ParseRecoveryCode(%q) = %q, %q, %v, want %q, %q", input, gotID, gotCode, ok, id, code)
+            }
+        }
+    }
+}
+
+func TestParseRecoveryCodeLength(t *testing.T) {
+    for _, input := range []string{"", "abcde", "abcde-abcd", "abcde-abcdef"} {
+        if _, _, ok := ParseRecoveryCode(input); ok {
+            t.Errorf("ParseRecoveryCode(%q) accepted", input)
+        }
+    }
+}
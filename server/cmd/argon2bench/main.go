@@ -0,0 +1,126 @@
+// Command argon2bench measures Argon2id on the current machine and suggests
+// the strongest parameters that hash within a target latency:
+//
+//	go run ./cmd/argon2bench -target 250ms
+//
+// Run it on the hardware the server is deployed to. Memory is preferred over
+// iterations, as it is what makes GPU cracking expensive.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"server/auth"
+)
+
+const minMemory = 8 * 1024 // KiB; the lowest value the config accepts
+
+type result struct {
+    memory     uint32
+    iterations uint32
+    elapsed    time.Duration
+}
+
+func main() {
+    target := flag.Duration("target", 250*time.Millisecond, "acceptable time for one hash")
+    maxMemory := flag.Uint("max-memory", 256*1024, "largest memory cost to try, in KiB")
+    parallelism := flag.Uint("parallelism", 2, "threads per hash")
+    minIterations := flag.Uint("min-iterations", 2, "fewest passes worth accepting")
+    concurrency := flag.Int("concurrency", 4, "hashes the server runs at once (auth.max_concurrent_hashes)")
+    samples := flag.Int("samples", 3, "runs per measurement; the median is used")
+    flag.Parse()
+
+    if *maxMemory < minMemory || *parallelism < 1 || *parallelism > 255 || *minIterations < 1 || *samples < 1 {
+        flag.Usage()
+        os.Exit(2)
+    }
+
+    var results []result
+    for memory := uint32(*maxMemory); memory >= minMemory; memory /= 2 {
+        r, ok := fit(memory, uint8(*parallelism), uint32(*minIterations), *target, *samples)
+        fmt.Fprintf(os.Stderr, "m=%d KiB: ", memory)
+        if !ok {
+            fmt.Fprintf(os.Stderr, "%d passes take %s, over target\n", *minIterations, r.elapsed.Round(time.Millisecond))
+            continue
+        }
+        fmt.Fprintf(os.Stderr, "t=%d in %s\n", r.iterations, r.elapsed.Round(time.Millisecond))
+        results = append(results, r)
+    }
+
+    if len(results) == 0 {
+        fmt.Fprintf(os.Stderr, "No parameters hash within %s; raise -target or lower -min-iterations\n", *target)
+        os.Exit(1)
+    }
+
+    // Largest memory first, then most iterations
+    sort.Slice(results, func(i, j int) bool {
+        if results[i].memory != results[j].memory {
+            return results[i].memory > results[j].memory
+        }
+        return results[i].iterations > results[j].iterations
+    })
+    best := results[0]
+
+    fmt.Println()
+    tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+    fmt.Fprintln(tw, "MEMORY (KiB)\tITERATIONS\tTIME\tPEAK RAM")
+    for _, r := range results {
+        fmt.Fprintf(tw, "%d\t%d\t%s\t%d MiB\n", r.memory, r.iterations,
+            r.elapsed.Round(time.Millisecond), int(r.memory)**concurrency/1024)
+    }
+    tw.Flush()
+
+    fmt.Printf("\nRecommended for a %s target:\n", *target)
+    fmt.Printf("ARGON2_MEMORY_KIB=%d\n", best.memory)
+    fmt.Printf("ARGON2_ITERATIONS=%d\n", best.iterations)
+    fmt.Printf("ARGON2_PARALLELISM=%d\n", *parallelism)
+}
+
+// Finds the most iterations at memory that stay within target. The time of a
+// single pass gives a first estimate, which is then lowered until it fits.
+func fit(memory uint32, parallelism uint8, minIterations uint32, target time.Duration, samples int) (result, bool) {
+    onePass := measure(memory, 1, parallelism, samples)
+    iterations := uint32(target / onePass)
+    if iterations < minIterations {
+        iterations = minIterations
+    }
+
+    for {
+        elapsed := measure(memory, iterations, parallelism, samples)
+        r := result{memory: memory, iterations: iterations, elapsed: elapsed}
+        if elapsed <= target {
+            return r, true
+        }
+        if iterations == minIterations {
+            return r, false
+        }
+        iterations--
+    }
+}
+
+func measure(memory, iterations uint32, parallelism uint8, samples int) time.Duration {
+    params := &auth.Argon2Params{
+        Memory:      memory,
+        Iterations:  iterations,
+        Parallelism: parallelism,
+        SaltLength:  16,
+        KeyLength:   32,
+    }
+
+    times := make([]time.Duration, samples)
+    for i := range times {
+        start := time.Now()
+        if _, err := auth.GenerateHash("benchmark password", params); err != nil {
+            fmt.Fprintln(os.Stderr, err)
+            os.Exit(1)
+        }
+        times[i] = time.Since(start)
+    }
+    sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+    return times[len(times)/2]
+}
\ No newline at end of file
@@ -0,0 +1,35 @@
+// Command mockoidc is a minimal OpenID Connect provider for trying out SSO
+// locally. It signs in everyone who reaches /authorize as the configured
+// user, without asking:
+//
+//	go run ./cmd/mockoidc -email dev@example.com
+//
+// Then start the server with OIDC_ISSUER_URL=http://localhost:9000,
+// OIDC_CLIENT_ID=stock-tracker and OIDC_REDIRECT_URL pointing at the
+// frontend callback page. Pass login_hint to /authorize to sign in as
+// someone else. Never expose it beyond localhost.
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+
+	"server/auth/oidctest"
+)
+
+func main() {
+    addr := flag.String("addr", "localhost:9000", "listen address")
+    issuer := flag.String("issuer", "http://localhost:9000", "issuer URL, as the server is configured with")
+    email := flag.String("email", "dev@example.com", "email of the user signed in when no login_hint is given")
+    emailVerified := flag.Bool("email-verified", true, "value of the email_verified claim")
+    flag.Parse()
+
+    p, err := oidctest.NewProvider(*issuer, *email, *emailVerified)
+    if err != nil {
+        log.Fatal(err)
+    }
+
+    log.Printf("Mock OIDC provider for %s listening on %s", p.Issuer(), *addr)
+    log.Fatal(http.ListenAndServe(*addr, p))
+}
\ No newline at end of file
@@ -0,0 +1,375 @@
+package config
+
+import (
+	"fmt"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	"server/ratelimit"
+)
+
+// Config holds every setting the server reads at startup. Fields are set from
+// defaults, then an optional YAML/TOML file, then .env and the environment.
+type Config struct {
+    Database  DatabaseConfig  `yaml:"database" toml:"database"`
+    HTTP      HTTPConfig      `yaml:"http" toml:"http"`
+    Auth      AuthConfig      `yaml:"auth" toml:"auth"`
+    CORS      CORSConfig      `yaml:"cors" toml:"cors"`
+    Market    MarketConfig    `yaml:"market" toml:"market"`
+    Import    ImportConfig    `yaml:"import" toml:"import"`
+    Log       LogConfig       `yaml:"log" toml:"log"`
+    Health    HealthConfig    `yaml:"health" toml:"health"`
+    RateLimit RateLimitConfig `yaml:"rate_limit" toml:"rate_limit"`
+    Password  PasswordConfig  `yaml:"password" toml:"password"`
+    Mail      MailConfig      `yaml:"mail" toml:"mail"`
+    OIDC      OIDCConfig      `yaml:"oidc" toml:"oidc"`
+}
+
+type DatabaseConfig struct {
+    URL string `yaml:"url" toml:"url" env:"DATABASE_URL" secret:"true"`
+}
+
+type HTTPConfig struct {
+    Addr              string        `yaml:"addr" toml:"addr" env:"HTTP_ADDR"`
+    ReadTimeout       time.Duration `yaml:"read_timeout" toml:"read_timeout" env:"HTTP_READ_TIMEOUT"`
+    ReadHeaderTimeout time.Duration `yaml:"read_header_timeout" toml:"read_header_timeout" env:"HTTP_READ_HEADER_TIMEOUT"`
+    WriteTimeout      time.Duration `yaml:"write_timeout" toml:"write_timeout" env:"HTTP_WRITE_TIMEOUT"`
+    IdleTimeout       time.Duration `yaml:"idle_timeout" toml:"idle_timeout" env:"HTTP_IDLE_TIMEOUT"`
+    ShutdownTimeout   time.Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout" env:"HTTP_SHUTDOWN_TIMEOUT"`
+    MaxHeaderBytes    int           `yaml:"max_header_bytes" toml:"max_header_bytes" env:"HTTP_MAX_HEADER_BYTES"`
+    TLSCertFile       string        `yaml:"tls_cert_file" toml:"tls_cert_file" env:"TLS_CERT_FILE"`
+    TLSKeyFile        string        `yaml:"tls_key_file" toml:"tls_key_file" env:"TLS_KEY_FILE"`
+}
+
+type AuthConfig struct {
+    TokenExpiry       time.Duration `yaml:"token_expiry" toml:"token_expiry" env:"AUTH_TOKEN_EXPIRY"`
+    Argon2Memory      uint32        `yaml:"argon2_memory_kib" toml:"argon2_memory_kib" env:"ARGON2_MEMORY_KIB"`
+    Argon2Iterations  uint32        `yaml:"argon2_iterations" toml:"argon2_iterations" env:"ARGON2_ITERATIONS"`
+    Argon2Parallelism uint8         `yaml:"argon2_parallelism" toml:"argon2_parallelism" env:"ARGON2_PARALLELISM"`
+    Argon2SaltLength  uint32        `yaml:"argon2_salt_length" toml:"argon2_salt_length" env:"ARGON2_SALT_LENGTH"`
+    Argon2KeyLength   uint32        `yaml:"argon2_key_length" toml:"argon2_key_length" env:"ARGON2_KEY_LENGTH"`
+
+    // Optional secret mixed into every hash, kept apart from the database.
+    // Hashes made with a pepper stop verifying if it changes.
+    Pepper string `yaml:"pepper" toml:"pepper" env:"AUTH_PEPPER" secret:"true"`
+
+    // Each hash allocates Argon2Memory, so this bounds memory used by logins
+    MaxConcurrentHashes int           `yaml:"max_concurrent_hashes" toml:"max_concurrent_hashes" env:"AUTH_MAX_CONCURRENT_HASHES"`
+    HashWaitTimeout     time.Duration `yaml:"hash_wait_timeout" toml:"hash_wait_timeout" env:"AUTH_HASH_WAIT_TIMEOUT"`
+
+    // What accounts may do before their email is verified: "allow" anything,
+    // "read_only" requests, or "deny" logging in at all
+    UnverifiedAccess     string        `yaml:"unverified_access" toml:"unverified_access" env:"AUTH_UNVERIFIED_ACCESS"`
+    VerificationTokenTTL time.Duration `yaml:"verification_token_ttl" toml:"verification_token_ttl" env:"AUTH_VERIFICATION_TOKEN_TTL"`
+
+    // Sessions survive restarts and work across instances only with a fixed key
+    TokenSigningKey string `yaml:"token_signing_key" toml:"token_signing_key" env:"AUTH_TOKEN_SIGNING_KEY" secret:"true"`
+    // Shown next to the account in authenticator apps
+    TOTPIssuer string `yaml:"totp_issuer" toml:"totp_issuer" env:"AUTH_TOTP_ISSUER"`
+    // Accounts promoted to admin at startup, so a fresh install has one
+    AdminEmails []string `yaml:"admin_emails" toml:"admin_emails" env:"AUTH_ADMIN_EMAILS"`
+}
+
+type CORSConfig struct {
+    AllowedOrigins   []string `yaml:"allowed_origins" toml:"allowed_origins" env:"CORS_ALLOWED_ORIGINS"`
+    AllowCredentials bool     `yaml:"allow_credentials" toml:"allow_credentials" env:"CORS_ALLOW_CREDENTIALS"`
+    MaxAge           int      `yaml:"max_age" toml:"max_age" env:"CORS_MAX_AGE"`
+}
+
+type MarketConfig struct {
+    StooqURL string `yaml:"stooq_url" toml:"stooq_url" env:"STOOQ_URL"`
+    NBPURL   string `yaml:"nbp_url" toml:"nbp_url" env:"NBP_URL"`
+    // Limit on each request to the quote provider
+    Timeout time.Duration `yaml:"timeout" toml:"timeout" env:"MARKET_TIMEOUT"`
+}
+
+type ImportConfig struct {
+    MaxUploadBytes int64         `yaml:"max_upload_bytes" toml:"max_upload_bytes" env:"IMPORT_MAX_UPLOAD_BYTES"`
+    Workers        int           `yaml:"workers" toml:"workers" env:"IMPORT_WORKERS"`
+    ChunkSize      int           `yaml:"chunk_size" toml:"chunk_size" env:"IMPORT_CHUNK_SIZE"`
+    JobTimeout     time.Duration `yaml:"job_timeout" toml:"job_timeout" env:"IMPORT_JOB_TIMEOUT"` // After which a silent job is retried
+    PreviewTTL     time.Duration `yaml:"preview_ttl" toml:"preview_ttl" env:"IMPORT_PREVIEW_TTL"` // How long a dry run can be confirmed
+}
+
+type LogConfig struct {
+    Level  string `yaml:"level" toml:"level" env:"LOG_LEVEL"`
+    Format string `yaml:"format" toml:"format" env:"LOG_FORMAT"`
+}
+
+type HealthConfig struct {
+    Timeout         time.Duration `yaml:"timeout" toml:"timeout" env:"READY_CHECK_TIMEOUT"`
+    CheckMarketData bool          `yaml:"check_market_data" toml:"check_market_data" env:"READY_CHECK_MARKET_DATA"`
+}
+
+// Limits are written as "count/duration", e.g. "10/1m"
+type RateLimitConfig struct {
+    Store            string        `yaml:"store" toml:"store" env:"RATE_LIMIT_STORE"`
+    TrustProxy       bool          `yaml:"trust_proxy" toml:"trust_proxy" env:"RATE_LIMIT_TRUST_PROXY"`
+    LoginPerIP       string        `yaml:"login_per_ip" toml:"login_per_ip" env:"RATE_LIMIT_LOGIN_PER_IP"`
+    LoginPerAccount  string        `yaml:"login_per_account" toml:"login_per_account" env:"RATE_LIMIT_LOGIN_PER_ACCOUNT"`
+    RegisterPerIP    string        `yaml:"register_per_ip" toml:"register_per_ip" env:"RATE_LIMIT_REGISTER_PER_IP"`
+    ResetPerIP       string        `yaml:"reset_per_ip" toml:"reset_per_ip" env:"RATE_LIMIT_RESET_PER_IP"`
+    ResetPerAccount  string        `yaml:"reset_per_account" toml:"reset_per_account" env:"RATE_LIMIT_RESET_PER_ACCOUNT"`
+    VerifyPerAccount string        `yaml:"verify_per_account" toml:"verify_per_account" env:"RATE_LIMIT_VERIFY_PER_ACCOUNT"`
+    SharePerIP       string        `yaml:"share_per_ip" toml:"share_per_ip" env:"RATE_LIMIT_SHARE_PER_IP"`
+    LockoutThreshold int           `yaml:"lockout_threshold" toml:"lockout_threshold" env:"LOCKOUT_THRESHOLD"`
+    LockoutBase      time.Duration `yaml:"lockout_base" toml:"lockout_base" env:"LOCKOUT_BASE"`
+    LockoutMax       time.Duration `yaml:"lockout_max" toml:"lockout_max" env:"LOCKOUT_MAX"`
+    LockoutWindow    time.Duration `yaml:"lockout_window" toml:"lockout_window" env:"LOCKOUT_WINDOW"`
+}
+
+// Strength rules applied whenever a password is set
+type PasswordConfig struct {
+    MinLength     int           `yaml:"min_length" toml:"min_length" env:"PASSWORD_MIN_LENGTH"`
+    MaxLength     int           `yaml:"max_length" toml:"max_length" env:"PASSWORD_MAX_LENGTH"`
+    RequireUpper  bool          `yaml:"require_upper" toml:"require_upper" env:"PASSWORD_REQUIRE_UPPER"`
+    RequireLower  bool          `yaml:"require_lower" toml:"require_lower" env:"PASSWORD_REQUIRE_LOWER"`
+    RequireDigit  bool          `yaml:"require_digit" toml:"require_digit" env:"PASSWORD_REQUIRE_DIGIT"`
+    RequireSymbol bool          `yaml:"require_symbol" toml:"require_symbol" env:"PASSWORD_REQUIRE_SYMBOL"`
+    ResetTokenTTL time.Duration `yaml:"reset_token_ttl" toml:"reset_token_ttl" env:"PASSWORD_RESET_TOKEN_TTL"`
+}
+
+// Outgoing mail is logged instead of sent while smtp_host is empty
+type MailConfig struct {
+    SMTPHost     string `yaml:"smtp_host" toml:"smtp_host" env:"SMTP_HOST"`
+    SMTPPort     int    `yaml:"smtp_port" toml:"smtp_port" env:"SMTP_PORT"`
+    SMTPUsername string `yaml:"smtp_username" toml:"smtp_username" env:"SMTP_USERNAME"`
+    SMTPPassword string `yaml:"smtp_password" toml:"smtp_password" env:"SMTP_PASSWORD" secret:"true"`
+    From         string `yaml:"from" toml:"from" env:"MAIL_FROM"`
+    // Frontend base URL that links in emails point to
+    AppURL string `yaml:"app_url" toml:"app_url" env:"APP_URL"`
+}
+
+// Single sign-on is off while issuer_url is empty
+type OIDCConfig struct {
+    IssuerURL    string `yaml:"issuer_url" toml:"issuer_url" env:"OIDC_ISSUER_URL"`
+    ClientID     string `yaml:"client_id" toml:"client_id" env:"OIDC_CLIENT_ID"`
+    ClientSecret string `yaml:"client_secret" toml:"client_secret" env:"OIDC_CLIENT_SECRET" secret:"true"`
+    // Frontend page the issuer returns to; it posts the code and state back
+    RedirectURL string   `yaml:"redirect_url" toml:"redirect_url" env:"OIDC_REDIRECT_URL"`
+    Scopes      []string `yaml:"scopes" toml:"scopes" env:"OIDC_SCOPES"`
+    // Create accounts for identities that match no user
+    AutoRegister bool `yaml:"auto_register" toml:"auto_register" env:"OIDC_AUTO_REGISTER"`
+    // Link identities to the existing account with the same email, if both
+    // the issuer and the account have the email verified and the account has
+    // no second factor
+    LinkByEmail  bool          `yaml:"link_by_email" toml:"link_by_email" env:"OIDC_LINK_BY_EMAIL"`
+    LoginTimeout time.Duration `yaml:"login_timeout" toml:"login_timeout" env:"OIDC_LOGIN_TIMEOUT"`
+}
+
+func Default() *Config {
+    return &Config{
+        HTTP: HTTPConfig{
+            Addr:              ":8080",
+            ReadTimeout:       15 * time.Second,
+            ReadHeaderTimeout: 5 * time.Second,
+            // Exports and PIT-38 reports fetch quotes and rates before writing
+            WriteTimeout:    60 * time.Second,
+            IdleTimeout:     120 * time.Second,
+            ShutdownTimeout: 30 * time.Second,
+            MaxHeaderBytes:  1 << 20,
+        },
+        Auth: AuthConfig{
+            TokenExpiry:       24 * time.Hour,
+            Argon2Memory:      64 * 1024,
+            Argon2Iterations:  3,
+            Argon2Parallelism: 2,
+            Argon2SaltLength:  16,
+            Argon2KeyLength:   32,
+
+            MaxConcurrentHashes: 4,
+            HashWaitTimeout:     5 * time.Second,
+
+            UnverifiedAccess:     "read_only",
+            VerificationTokenTTL: 48 * time.Hour,
+            TOTPIssuer:           "Stock Tracker",
+        },
+        CORS: CORSConfig{
+            AllowedOrigins:   []string{"http://localhost:3000"},
+            AllowCredentials: true,
+            MaxAge:           600,
+        },
+        Market: MarketConfig{
+            StooqURL: "https://stooq.pl",
+            NBPURL:   "https://api.nbp.pl",
+            Timeout:  10 * time.Second,
+        },
+        Import: ImportConfig{
+            MaxUploadBytes: 10 << 20,
+            Workers:        2,
+            ChunkSize:      500,
+            JobTimeout:     10 * time.Minute,
+            PreviewTTL:     24 * time.Hour,
+        },
+        Log: LogConfig{
+            Level:  "info",
+            Format: "text",
+        },
+        Health: HealthConfig{
+            Timeout: 2 * time.Second,
+        },
+        RateLimit: RateLimitConfig{
+            Store:            "memory",
+            LoginPerIP:       "20/1m",
+            LoginPerAccount:  "10/1m",
+            RegisterPerIP:    "5/1h",
+            ResetPerIP:       "10/1h",
+            ResetPerAccount:  "3/1h",
+            VerifyPerAccount: "3/1h",
+            SharePerIP:       "60/1m",
+            LockoutThreshold: 5,
+            LockoutBase:      time.Minute,
+            LockoutMax:       time.Hour,
+            LockoutWindow:    15 * time.Minute,
+        },
+        Password: PasswordConfig{
+            MinLength:     10,
+            MaxLength:     128,
+            ResetTokenTTL: time.Hour,
+        },
+        Mail: MailConfig{
+            SMTPPort: 587,
+            From:     "no-reply@localhost",
+            AppURL:   "http://localhost:3000",
+        },
+        OIDC: OIDCConfig{
+            Scopes:       []string{"openid", "email", "profile"},
+            LoginTimeout: 10 * time.Minute,
+        },
+    }
+}
+
+// ValidationError lists every invalid setting so they can be fixed in one go
+type ValidationError []string
+
+func (e ValidationError) Error() string {
+    return "invalid configuration:\n  - " + strings.Join(e, "\n  - ")
+}
+
+func (c *Config) Validate() error {
+    var problems ValidationError
+    check := func(ok bool, format string, args ...interface{}) {
+        if !ok {
+            problems = append(problems, fmt.Sprintf(format, args...))
+        }
+    }
+
+    check(c.Database.URL != "", "database.url (DATABASE_URL) is required")
+
+    check(c.HTTP.Addr != "", "http.addr must not be empty")
+    for _, d := range []struct {
+        name  string
+        value time.Duration
+    }{
+        {"http.read_timeout", c.HTTP.ReadTimeout},
+        {"http.read_header_timeout", c.HTTP.ReadHeaderTimeout},
+        {"http.write_timeout", c.HTTP.WriteTimeout},
+        {"http.idle_timeout", c.HTTP.IdleTimeout},
+        {"http.shutdown_timeout", c.HTTP.ShutdownTimeout},
+    } {
+        check(d.value > 0, "%s must be positive, got %s", d.name, d.value)
+    }
+    check(c.HTTP.MaxHeaderBytes >= 4096, "http.max_header_bytes must be at least 4096")
+    check((c.HTTP.TLSCertFile == "") == (c.HTTP.TLSKeyFile == ""),
+        "http.tls_cert_file and http.tls_key_file must be set together")
+
+    check(c.Auth.TokenExpiry >= time.Minute, "auth.token_expiry must be at least 1m")
+    check(c.Auth.Argon2Memory >= 8*1024, "auth.argon2_memory_kib must be at least 8192")
+    check(c.Auth.Argon2Iterations >= 1, "auth.argon2_iterations must be at least 1")
+    check(c.Auth.Argon2Parallelism >= 1, "auth.argon2_parallelism must be at least 1")
+    check(c.Auth.Argon2SaltLength >= 16, "auth.argon2_salt_length must be at least 16")
+    check(c.Auth.Argon2KeyLength >= 16, "auth.argon2_key_length must be at least 16")
+    check(c.Auth.Pepper == "" || len(c.Auth.Pepper) >= 32, "auth.pepper must be at least 32 characters when set")
+    check(c.Auth.MaxConcurrentHashes >= 1, "auth.max_concurrent_hashes must be at least 1")
+    check(c.Auth.HashWaitTimeout > 0, "auth.hash_wait_timeout must be positive")
+    check(c.Auth.UnverifiedAccess == "allow" || c.Auth.UnverifiedAccess == "read_only" || c.Auth.UnverifiedAccess == "deny",
+        "auth.unverified_access must be allow, read_only or deny, got %q", c.Auth.UnverifiedAccess)
+    check(c.Auth.VerificationTokenTTL >= time.Minute, "auth.verification_token_ttl must be at least 1m")
+    check(c.Auth.TokenSigningKey == "" || len(c.Auth.TokenSigningKey) >= 32,
+        "auth.token_signing_key must be at least 32 characters when set")
+    check(c.Auth.TOTPIssuer != "" && !strings.Contains(c.Auth.TOTPIssuer, ":"),
+        "auth.totp_issuer must be set and must not contain a colon")
+    for _, e := range c.Auth.AdminEmails {
+        check(strings.Contains(e, "@"), "auth.admin_emails: %q is not an email address", e)
+    }
+
+    check(len(c.CORS.AllowedOrigins) > 0, "cors.allowed_origins must not be empty")
+    for _, o := range c.CORS.AllowedOrigins {
+        // Any site could then make credentialed requests on a user's behalf
+        check(o != "*" || !c.CORS.AllowCredentials,
+            "cors.allowed_origins cannot contain \"*\" while cors.allow_credentials is true")
+    }
+    check(c.CORS.MaxAge >= 0, "cors.max_age must not be negative")
+
+    check(isHTTPURL(c.Market.StooqURL), "market.stooq_url must be an absolute http(s) URL")
+    check(isHTTPURL(c.Market.NBPURL), "market.nbp_url must be an absolute http(s) URL")
+    check(c.Market.Timeout > 0, "market.timeout must be positive")
+
+    check(c.Import.MaxUploadBytes > 0, "import.max_upload_bytes must be positive")
+    check(c.Import.Workers > 0, "import.workers must be positive")
+    check(c.Import.ChunkSize > 0, "import.chunk_size must be positive")
+    check(c.Import.JobTimeout >= time.Minute, "import.job_timeout must be at least 1m")
+    check(c.Import.PreviewTTL > 0, "import.preview_ttl must be positive")
+
+    switch strings.ToLower(c.Log.Level) {
+    case "debug", "info", "warn", "warning", "error":
+    default:
+        problems = append(problems, fmt.Sprintf("log.level must be debug, info, warn or error, got %q", c.Log.Level))
+    }
+    check(c.Log.Format == "text" || c.Log.Format == "json", "log.format must be text or json, got %q", c.Log.Format)
+
+    check(c.Health.Timeout > 0, "health.timeout must be positive")
+
+    check(c.RateLimit.Store == "memory" || c.RateLimit.Store == "postgres",
+        "rate_limit.store must be memory or postgres, got %q", c.RateLimit.Store)
+    for _, l := range []struct{ name, value string }{
+        {"rate_limit.login_per_ip", c.RateLimit.LoginPerIP},
+        {"rate_limit.login_per_account", c.RateLimit.LoginPerAccount},
+        {"rate_limit.register_per_ip", c.RateLimit.RegisterPerIP},
+        {"rate_limit.reset_per_ip", c.RateLimit.ResetPerIP},
+        {"rate_limit.reset_per_account", c.RateLimit.ResetPerAccount},
+        {"rate_limit.verify_per_account", c.RateLimit.VerifyPerAccount},
+        {"rate_limit.share_per_ip", c.RateLimit.SharePerIP},
+    } {
+        _, err := ratelimit.ParseLimit(l.value)
+        check(err == nil, "%s: %v", l.name, err)
+    }
+    check(c.RateLimit.LockoutThreshold >= 1, "rate_limit.lockout_threshold must be at least 1")
+    check(c.RateLimit.LockoutBase > 0 && c.RateLimit.LockoutMax >= c.RateLimit.LockoutBase,
+        "rate_limit.lockout_base must be positive and not above rate_limit.lockout_max")
+    check(c.RateLimit.LockoutWindow > 0, "rate_limit.lockout_window must be positive")
+
+    check(c.Password.MinLength >= 8, "password.min_length must be at least 8")
+    check(c.Password.MaxLength >= c.Password.MinLength && c.Password.MaxLength <= 1024,
+        "password.max_length must be between password.min_length and 1024")
+    check(c.Password.ResetTokenTTL >= time.Minute, "password.reset_token_ttl must be at least 1m")
+
+    check(c.Mail.SMTPPort > 0 && c.Mail.SMTPPort < 65536, "mail.smtp_port must be a valid port")
+    _, err := mail.ParseAddress(c.Mail.From)
+    check(err == nil, "mail.from must be an email address, got %q", c.Mail.From)
+    check(isHTTPURL(c.Mail.AppURL), "mail.app_url must be an absolute http(s) URL")
+
+    if c.OIDC.IssuerURL != "" {
+        check(isHTTPURL(c.OIDC.IssuerURL), "oidc.issuer_url must be an absolute http(s) URL")
+        check(c.OIDC.ClientID != "", "oidc.client_id is required when oidc.issuer_url is set")
+        check(isHTTPURL(c.OIDC.RedirectURL), "oidc.redirect_url must be an absolute http(s) URL")
+        openid := false
+        for _, s := range c.OIDC.Scopes {
+            openid = openid || s == "openid"
+        }
+        check(openid, "oidc.scopes must include openid")
+        check(c.OIDC.LoginTimeout >= time.Minute, "oidc.login_timeout must be at least 1m")
+    }
+
+    if len(problems) > 0 {
+        return problems
+    }
+    return nil
+}
+
+func isHTTPURL(s string) bool {
+    u, err := url.Parse(s)
+    return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
\ No newline at end of file
@@ -0,0 +1,156 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+// Load builds the configuration from defaults, the YAML or TOML file at path
+// (optional), .env and the environment, in increasing order of precedence.
+func Load(path string) (*Config, error) {
+    cfg := Default()
+
+    if path != "" {
+        if err := loadFile(path, cfg); err != nil {
+            return nil, err
+        }
+    }
+
+    // .env never overrides variables that are already set
+    if err := godotenv.Load(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+        return nil, fmt.Errorf("failed to read .env: %w", err)
+    }
+
+    var problems ValidationError
+    applyEnv(reflect.ValueOf(cfg).Elem(), &problems)
+
+    // PORT is what most hosting platforms set
+    if port := os.Getenv("PORT"); port != "" && os.Getenv("HTTP_ADDR") == "" {
+        cfg.HTTP.Addr = ":" + port
+    }
+    for i, o := range cfg.CORS.AllowedOrigins {
+        cfg.CORS.AllowedOrigins[i] = strings.TrimRight(o, "/")
+    }
+    cfg.Mail.AppURL = strings.TrimRight(cfg.Mail.AppURL, "/")
+    for i, e := range cfg.Auth.AdminEmails {
+        cfg.Auth.AdminEmails[i] = strings.ToLower(strings.TrimSpace(e))
+    }
+
+    if err := cfg.Validate(); err != nil {
+        problems = append(problems, err.(ValidationError)...)
+    }
+    if len(problems) > 0 {
+        return nil, problems
+    }
+    return cfg, nil
+}
+
+func loadFile(path string, cfg *Config) error {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return fmt.Errorf("failed to read config file: %w", err)
+    }
+
+    switch strings.ToLower(filepath.Ext(path)) {
+    case ".yaml", ".yml":
+        dec := yaml.NewDecoder(bytes.NewReader(data))
+        dec.KnownFields(true)
+        if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+            return fmt.Errorf("failed to parse %s: %w", path, err)
+        }
+    case ".toml":
+        md, err := toml.Decode(string(data), cfg)
+        if err != nil {
+            return fmt.Errorf("failed to parse %s: %w", path, err)
+        }
+        if undecoded := md.Undecoded(); len(undecoded) > 0 {
+            return fmt.Errorf("unknown keys in %s: %v", path, undecoded)
+        }
+    default:
+        return fmt.Errorf("config file %s must be .yaml, .yml or .toml", path)
+    }
+    return nil
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// Walks cfg and overrides every field tagged `env:"NAME"` whose variable is set
+func applyEnv(v reflect.Value, problems *ValidationError) {
+    t := v.Type()
+    for i := 0; i < t.NumField(); i++ {
+        field, value := t.Field(i), v.Field(i)
+        if field.Type.Kind() == reflect.Struct {
+            applyEnv(value, problems)
+            continue
+        }
+
+        name := field.Tag.Get("env")
+        raw, ok := os.LookupEnv(name)
+        if name == "" || !ok || raw == "" {
+            continue
+        }
+        if err := setValue(value, raw); err != nil {
+            *problems = append(*problems, fmt.Sprintf("%s: %v", name, err))
+        }
+    }
+}
+
+func setValue(v reflect.Value, raw string) error {
+    raw = strings.TrimSpace(raw)
+
+    if v.Type() == durationType {
+        d, err := time.ParseDuration(raw)
+        if err != nil {
+            return fmt.Errorf("invalid duration %q", raw)
+        }
+        v.SetInt(int64(d))
+        return nil
+    }
+
+    switch v.Kind() {
+    case reflect.String:
+        v.SetString(raw)
+    case reflect.Bool:
+        b, err := strconv.ParseBool(raw)
+        if err != nil {
+            return fmt.Errorf("invalid boolean %q", raw)
+        }
+        v.SetBool(b)
+    case reflect.Int, reflect.Int64:
+        n, err := strconv.ParseInt(raw, 10, v.Type().Bits())
+        if err != nil {
+            return fmt.Errorf("invalid integer %q", raw)
+        }
+        v.SetInt(n)
+    case reflect.Uint8, reflect.Uint32:
+        n, err := strconv.ParseUint(raw, 10, v.Type().Bits())
+        if err != nil {
+            return fmt.Errorf("invalid unsigned integer %q", raw)
+        }
+        v.SetUint(n)
+    case reflect.Slice:
+        var items []string
+        for _, item := range strings.Split(raw, ",") {
+            if item = strings.TrimSpace(item); item != "" {
+                items = append(items, item)
+            }
+        }
+        v.Set(reflect.ValueOf(items))
+    default:
+        return fmt.Errorf("unsupported field type %s", v.Type())
+    }
+    return nil
+}
\ No newline at end of file
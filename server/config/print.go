@@ -0,0 +1,50 @@
+package config
+
+import (
+	"io"
+	"net/url"
+	"reflect"
+
+	"gopkg.in/yaml.v3"
+)
+
+const redacted = "REDACTED"
+
+// Print writes the effective configuration as YAML with secrets redacted
+func (c *Config) Print(w io.Writer) error {
+    out := *c
+    out.CORS.AllowedOrigins = append([]string(nil), c.CORS.AllowedOrigins...)
+    redact(reflect.ValueOf(&out).Elem())
+
+    enc := yaml.NewEncoder(w)
+    enc.SetIndent(2)
+    defer enc.Close()
+    return enc.Encode(&out)
+}
+
+func redact(v reflect.Value) {
+    t := v.Type()
+    for i := 0; i < t.NumField(); i++ {
+        field, value := t.Field(i), v.Field(i)
+        if field.Type.Kind() == reflect.Struct {
+            redact(value)
+            continue
+        }
+        if field.Tag.Get("secret") == "true" && value.String() != "" {
+            value.SetString(redactString(value.String()))
+        }
+    }
+}
+
+// Keeps the host of connection URLs visible, which is usually what one is
+// checking for, and hides only the password.
+func redactString(s string) string {
+    u, err := url.Parse(s)
+    if err != nil || u.Scheme == "" || u.User == nil {
+        return redacted
+    }
+    if _, ok := u.User.Password(); ok {
+        u.User = url.UserPassword(u.User.Username(), redacted)
+    }
+    return u.String()
+}
\ No newline at end of file
@@ -0,0 +1,88 @@
+package db
+
+import (
+	"context"
+	"errors"
+
+	"server/models"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var ErrAPIKeyNotFound = errors.New("API key not found")
+
+const apiKeyColumns = `id, user_id, name, prefix, scopes, expires_at, last_used_at, created_at`
+
+func scanAPIKey(row pgx.Row) (*models.APIKey, error) {
+    k := &models.APIKey{}
+    err := row.Scan(&k.ID, &k.UserID, &k.Name, &k.Prefix, &k.Scopes, &k.ExpiresAt, &k.LastUsedAt, &k.CreatedAt)
+    if err == pgx.ErrNoRows {
+        return nil, ErrAPIKeyNotFound
+    }
+    return k, err
+}
+
+func CreateAPIKey(ctx context.Context, k *models.APIKey, keyHash []byte) error {
+    return Pool.QueryRow(ctx, `
+        INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes, expires_at)
+        VALUES ($1, $2, $3, $4, $5, $6)
+        RETURNING id, created_at`,
+        k.UserID, k.Name, k.Prefix, keyHash, k.Scopes, k.ExpiresAt,
+    ).Scan(&k.ID, &k.CreatedAt)
+}
+
+func GetAPIKeys(ctx context.Context, userID int) ([]models.APIKey, error) {
+    rows, err := Pool.Query(ctx, `
+        SELECT `+apiKeyColumns+`
+        FROM api_keys
+        WHERE user_id = $1
+        ORDER BY id`, userID)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    keys := make([]models.APIKey, 0)
+    for rows.Next() {
+        k, err := scanAPIKey(rows)
+        if err != nil {
+            return nil, err
+        }
+        keys = append(keys, *k)
+    }
+    return keys, rows.Err()
+}
+
+func CountAPIKeys(ctx context.Context, userID int) (int, error) {
+    var n int
+    err := Pool.QueryRow(ctx, `SELECT count(*) FROM api_keys WHERE user_id = $1`, userID).Scan(&n)
+    return n, err
+}
+
+func GetAPIKeyByHash(ctx context.Context, keyHash []byte) (*models.APIKey, error) {
+    return scanAPIKey(Pool.QueryRow(ctx, `
+        SELECT `+apiKeyColumns+`
+        FROM api_keys
+        WHERE key_hash = $1`, keyHash))
+}
+
+// DeleteAPIKey revokes a key; only its owner may do so
+func DeleteAPIKey(ctx context.Context, userID, id int) error {
+    tag, err := Pool.Exec(ctx, `DELETE FROM api_keys WHERE id = $1 AND user_id = $2`, id, userID)
+    if err != nil {
+        return err
+    }
+    if tag.RowsAffected() == 0 {
+        return ErrAPIKeyNotFound
+    }
+    return nil
+}
+
+// TouchAPIKey records a use. Writes are skipped within a minute of the last
+// one, so a busy script does not update the row on every request.
+func TouchAPIKey(ctx context.Context, id int) error {
+    _, err := Pool.Exec(ctx, `
+        UPDATE api_keys SET last_used_at = NOW()
+        WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute')`, id)
+    return err
+}
\ No newline at end of file
@@ -0,0 +1,152 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"server/models"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Returns how many were new; the rest were imported before
+func saveCashOperations(ctx context.Context, tx pgx.Tx, portfolioID int, ops []models.CashOperation) (int, error) {
+    batch := &pgx.Batch{}
+    for _, op := range ops {
+        batch.Queue(`
+            INSERT INTO cash_operations
+                (portfolio_id, type, currency, amount, time, symbol, comment, source, external_id)
+            VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+            ON CONFLICT (portfolio_id, external_id) DO NOTHING`,
+            portfolioID, op.Type, op.Currency, op.Amount, op.Time, op.Symbol, op.Comment,
+            op.Source, op.ExternalID)
+    }
+
+    n, err := execBatch(ctx, tx, batch)
+    if err != nil {
+        return 0, fmt.Errorf("failed to save cash operations: %v", err)
+    }
+    return n, nil
+}
+
+func CreateCashOperation(ctx context.Context, op *models.CashOperation) error {
+    err := Pool.QueryRow(ctx, `
+        INSERT INTO cash_operations
+            (portfolio_id, type, currency, amount, time, symbol, comment, source)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+        RETURNING id`,
+        op.PortfolioID, op.Type, op.Currency, op.Amount, op.Time, op.Symbol, op.Comment, op.Source,
+    ).Scan(&op.ID)
+    if err != nil {
+        return fmt.Errorf("failed to create cash operation: %v", err)
+    }
+    return nil
+}
+
+// Replaces the cash a manual trade moved: the price paid or received and
+// the commission. Imported trades get theirs from the statement instead.
+func saveTradeCash(ctx context.Context, tx pgx.Tx, t *models.Transaction) error {
+    _, err := tx.Exec(ctx, `DELETE FROM cash_operations WHERE transaction_id = $1`, t.ID)
+    if err != nil {
+        return fmt.Errorf("failed to clear trade cash: %v", err)
+    }
+    if t.Source != models.SourceManual {
+        return nil
+    }
+
+    legs := []models.CashOperation{{Type: models.CashPurchase, Currency: t.Currency, Amount: -t.Quantity * t.Price}}
+    if t.Side == models.SideSell {
+        legs[0] = models.CashOperation{Type: models.CashSale, Currency: t.Currency, Amount: t.Quantity * t.Price}
+    }
+    if t.Commission > 0 {
+        legs = append(legs, models.CashOperation{Type: models.CashCommission, Currency: t.CommissionCurrency, Amount: -t.Commission})
+    }
+
+    for _, leg := range legs {
+        _, err := tx.Exec(ctx, `
+            INSERT INTO cash_operations
+                (portfolio_id, type, currency, amount, time, symbol, source, transaction_id)
+            VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+            t.PortfolioID, leg.Type, leg.Currency, leg.Amount, t.Time, t.Symbol, t.Source, t.ID)
+        if err != nil {
+            return fmt.Errorf("failed to record trade cash: %v", err)
+        }
+    }
+    return nil
+}
+
+// Records the cash a manual dividend paid. Imported dividends get theirs from
+// the statement instead. The action must already be saved.
+func saveDividendCash(ctx context.Context, tx pgx.Tx, action *models.CorporateAction) error {
+    if action.Source != models.SourceManual || action.Total == 0 {
+        return nil
+    }
+    _, err := tx.Exec(ctx, `
+        INSERT INTO cash_operations
+            (portfolio_id, type, currency, amount, time, symbol, source, corporate_action_id)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+        action.PortfolioID, models.CashDividend, action.Currency, action.Total, action.ExDate,
+        action.Symbol, action.Source, action.ID)
+    if err != nil {
+        return fmt.Errorf("failed to record dividend cash: %v", err)
+    }
+    return nil
+}
+
+func GetCashOperations(ctx context.Context, portfolioID int) ([]models.CashOperation, error) {
+    rows, err := Pool.Query(ctx, `
+        SELECT
+            id,
+            portfolio_id,
+            type,
+            currency,
+            amount,
+            TO_CHAR(time, 'YYYY-MM-DD HH24:MI:SS'),
+            symbol,
+            comment,
+            source,
+            COALESCE(external_id, '')
+        FROM cash_operations
+        WHERE portfolio_id = $1
+        ORDER BY time DESC, id DESC`, portfolioID)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    ops := make([]models.CashOperation, 0)
+    for rows.Next() {
+        var op models.CashOperation
+        if err := rows.Scan(&op.ID, &op.PortfolioID, &op.Type, &op.Currency, &op.Amount, &op.Time,
+            &op.Symbol, &op.Comment, &op.Source, &op.ExternalID); err != nil {
+            return nil, fmt.Errorf("scan error: %v", err)
+        }
+        ops = append(ops, op)
+    }
+
+    return ops, rows.Err()
+}
+
+func GetCashBalances(ctx context.Context, portfolioID int) ([]models.CashBalance, error) {
+    rows, err := Pool.Query(ctx, `
+        SELECT currency, SUM(amount)
+        FROM cash_operations
+        WHERE portfolio_id = $1
+        GROUP BY currency
+        ORDER BY currency`, portfolioID)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    balances := make([]models.CashBalance, 0)
+    for rows.Next() {
+        var b models.CashBalance
+        if err := rows.Scan(&b.Currency, &b.Balance); err != nil {
+            return nil, fmt.Errorf("scan error: %v", err)
+        }
+        balances = append(balances, b)
+    }
+
+    return balances, rows.Err()
+}
\ No newline at end of file
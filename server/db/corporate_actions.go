@@ -0,0 +1,254 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"server/models"
+	"server/utils"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var ErrPositionNotFound = errors.New("position not found")
+
+const corporateActionColumns = `
+    id, portfolio_id, symbol, type, TO_CHAR(ex_date, 'YYYY-MM-DD'), amount, total, ratio,
+    new_symbol, cost_fraction, currency, source, COALESCE(external_id, '')`
+
+func ApplyCorporateAction(ctx context.Context, action *models.CorporateAction) error {
+    tx, err := Pool.Begin(ctx)
+    if err != nil {
+        return fmt.Errorf("begin transaction: %v", err)
+    }
+    defer tx.Rollback(ctx)
+
+    switch action.Type {
+    case models.ActionDividend:
+        if action.Total == 0 {
+            var shares float64
+            err := tx.QueryRow(ctx,
+                `SELECT COALESCE(SUM(shares), 0) FROM stocks WHERE portfolio_id = $1 AND ticker = $2`,
+                action.PortfolioID, action.Symbol).Scan(&shares)
+            if err != nil {
+                return fmt.Errorf("failed to read shares: %v", err)
+            }
+            action.Total = action.Amount * shares
+        }
+
+    case models.ActionRename:
+        _, err = tx.Exec(ctx, `
+            UPDATE corporate_actions SET symbol = $3
+            WHERE portfolio_id = $1 AND symbol = $2`,
+            action.PortfolioID, action.Symbol, action.NewSymbol)
+        if err != nil {
+            return fmt.Errorf("failed to rename corporate actions: %v", err)
+        }
+        _, err = tx.Exec(ctx, `UPDATE transactions SET symbol = $3 WHERE portfolio_id = $1 AND symbol = $2`,
+            action.PortfolioID, action.Symbol, action.NewSymbol)
+        if err != nil {
+            return fmt.Errorf("failed to rename transactions: %v", err)
+        }
+        if err := renamePosition(ctx, tx, action); err != nil {
+            return err
+        }
+    }
+
+    if err := insertCorporateAction(ctx, tx, action); err != nil {
+        return err
+    }
+    if action.Type == models.ActionDividend {
+        if err := saveDividendCash(ctx, tx, action); err != nil {
+            return err
+        }
+    }
+
+    // Splits and spin-offs are replayed from the ledger at their ex-date, so
+    // trades after it are not adjusted again
+    switch action.Type {
+    case models.ActionSplit:
+        if err := recomputePosition(ctx, tx, action.PortfolioID, action.Symbol, false); err != nil {
+            return err
+        }
+
+    case models.ActionSpinOff:
+        if err := applySpinOff(ctx, tx, action); err != nil {
+            return err
+        }
+    }
+
+    return tx.Commit(ctx)
+}
+
+// Renames the stocks row. If the new symbol is already held, the two are
+// merged by rebuilding the new symbol from the ledger, whose trades have
+// already been renamed.
+func renamePosition(ctx context.Context, tx pgx.Tx, action *models.CorporateAction) error {
+    var held bool
+    err := tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM stocks WHERE portfolio_id = $1 AND ticker = $2)`,
+        action.PortfolioID, action.NewSymbol).Scan(&held)
+    if err != nil {
+        return fmt.Errorf("failed to read position: %v", err)
+    }
+
+    if !held {
+        _, err = tx.Exec(ctx, `UPDATE stocks SET ticker = $3 WHERE portfolio_id = $1 AND ticker = $2`,
+            action.PortfolioID, action.Symbol, action.NewSymbol)
+        if err != nil {
+            return fmt.Errorf("failed to rename position: %v", err)
+        }
+        return nil
+    }
+
+    _, err = tx.Exec(ctx, `DELETE FROM stocks WHERE portfolio_id = $1 AND ticker = $2`,
+        action.PortfolioID, action.Symbol)
+    if err != nil {
+        return fmt.Errorf("failed to merge positions: %v", err)
+    }
+    return recomputePosition(ctx, tx, action.PortfolioID, action.NewSymbol, false)
+}
+
+// Moves part of the parent's cost basis, as held on the ex-date, to the
+// spun-off shares. The action must already be saved.
+func applySpinOff(ctx context.Context, tx pgx.Tx, action *models.CorporateAction) error {
+    shares, price, _, err := replayPosition(ctx, tx, action.PortfolioID, action.Symbol, &action.ExDate, false)
+    if err != nil {
+        return err
+    }
+    if shares == 0 {
+        return ErrPositionNotFound
+    }
+
+    newShares := shares * action.Ratio
+    movedCost := shares * price * action.CostFraction
+
+    if err := recomputePosition(ctx, tx, action.PortfolioID, action.Symbol, false); err != nil {
+        return err
+    }
+
+    if newShares == 0 {
+        return nil
+    }
+
+    // The spun-off shares enter the ledger as a buy at the allocated cost
+    _, err = tx.Exec(ctx, `
+        INSERT INTO transactions
+            (portfolio_id, symbol, side, quantity, price, currency, commission_currency, time, source)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+        action.PortfolioID, action.NewSymbol, models.SideBuy, newShares, movedCost/newShares,
+        utils.CurrencyForSymbol(action.NewSymbol), action.Currency, action.ExDate, models.SourceCorporateAction)
+    if err != nil {
+        return fmt.Errorf("failed to record spin-off shares: %v", err)
+    }
+
+    return recomputePosition(ctx, tx, action.PortfolioID, action.NewSymbol, false)
+}
+
+func insertCorporateAction(ctx context.Context, tx pgx.Tx, action *models.CorporateAction) error {
+    err := tx.QueryRow(ctx, `
+        INSERT INTO corporate_actions
+            (portfolio_id, symbol, type, ex_date, amount, total, ratio, new_symbol, cost_fraction,
+             currency, source, external_id)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, NULLIF($12, ''))
+        RETURNING id`,
+        action.PortfolioID, action.Symbol, action.Type, action.ExDate, action.Amount, action.Total, action.Ratio,
+        action.NewSymbol, action.CostFraction, action.Currency, action.Source, action.ExternalID,
+    ).Scan(&action.ID)
+    if err != nil {
+        return fmt.Errorf("failed to save corporate action: %v", err)
+    }
+    return nil
+}
+
+// Returns how many were new; the rest were imported before
+func saveImportedDividends(ctx context.Context, tx pgx.Tx, portfolioID int, actions []models.CorporateAction) (int, error) {
+    batch := &pgx.Batch{}
+    for _, a := range actions {
+        batch.Queue(`
+            INSERT INTO corporate_actions
+                (portfolio_id, symbol, type, ex_date, amount, total, currency, source, external_id)
+            VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+            ON CONFLICT (portfolio_id, external_id) DO NOTHING`,
+            portfolioID, a.Symbol, a.Type, a.ExDate, a.Amount, a.Total, a.Currency, a.Source, a.ExternalID)
+    }
+
+    n, err := execBatch(ctx, tx, batch)
+    if err != nil {
+        return 0, fmt.Errorf("failed to save dividends: %v", err)
+    }
+    return n, nil
+}
+
+func GetCorporateActions(ctx context.Context, portfolioID int, symbol string) ([]models.CorporateAction, error) {
+    rows, err := Pool.Query(ctx, `
+        SELECT `+corporateActionColumns+`
+        FROM corporate_actions
+        WHERE portfolio_id = $1 AND ($2 = '' OR UPPER(symbol) = UPPER($2))
+        ORDER BY ex_date DESC, id DESC`, portfolioID, symbol)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    actions := make([]models.CorporateAction, 0)
+    for rows.Next() {
+        var a models.CorporateAction
+        if err := rows.Scan(&a.ID, &a.PortfolioID, &a.Symbol, &a.Type, &a.ExDate, &a.Amount, &a.Total, &a.Ratio,
+            &a.NewSymbol, &a.CostFraction, &a.Currency, &a.Source, &a.ExternalID); err != nil {
+            return nil, fmt.Errorf("scan error: %v", err)
+        }
+        actions = append(actions, a)
+    }
+
+    return actions, rows.Err()
+}
+
+// GetSplits returns the splits recorded in one portfolio, for adjusting
+// price history
+func GetSplits(ctx context.Context, portfolioID int, symbol string) ([]models.CorporateAction, error) {
+    rows, err := Pool.Query(ctx, `
+        SELECT DISTINCT UPPER(symbol), TO_CHAR(ex_date, 'YYYY-MM-DD'), ratio
+        FROM corporate_actions
+        WHERE portfolio_id = $1 AND type = $2 AND UPPER(symbol) = UPPER($3)`,
+        portfolioID, models.ActionSplit, symbol)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    splits := make([]models.CorporateAction, 0)
+    for rows.Next() {
+        a := models.CorporateAction{Type: models.ActionSplit}
+        if err := rows.Scan(&a.Symbol, &a.ExDate, &a.Ratio); err != nil {
+            return nil, fmt.Errorf("scan error: %v", err)
+        }
+        splits = append(splits, a)
+    }
+
+    return splits, rows.Err()
+}
+
+func GetDividendIncome(ctx context.Context, portfolioID int, year int) ([]models.DividendIncome, error) {
+    rows, err := Pool.Query(ctx, `
+        SELECT symbol, currency, SUM(total), COUNT(*)
+        FROM corporate_actions
+        WHERE portfolio_id = $1 AND type = $2 AND EXTRACT(YEAR FROM ex_date) = $3
+        GROUP BY symbol, currency
+        ORDER BY symbol`, portfolioID, models.ActionDividend, year)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    income := make([]models.DividendIncome, 0)
+    for rows.Next() {
+        var d models.DividendIncome
+        if err := rows.Scan(&d.Symbol, &d.Currency, &d.Total, &d.Payments); err != nil {
+            return nil, fmt.Errorf("scan error: %v", err)
+        }
+        income = append(income, d)
+    }
+
+    return income, rows.Err()
+}
\ No newline at end of file
@@ -4,10 +4,11 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log"
-	"server/models"
 	"strings"
 
+	"server/models"
+	"server/reqctx"
+
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
@@ -24,12 +25,121 @@ func InitDB(databaseURL string) error {
     if err := createTables(); err != nil {
         return fmt.Errorf("failed to create tables: %v", err)
     }
+    if err := assignUnownedRows(context.Background()); err != nil {
+        return fmt.Errorf("failed to assign rows to portfolios: %v", err)
+    }
+    if err := seedOpeningBalances(context.Background()); err != nil {
+        return fmt.Errorf("failed to seed opening balances: %v", err)
+    }
 
     return nil
 }
 
+// Stocks and corporate actions from before portfolios existed have no
+// portfolio. The data was shared by everyone then, so it goes to the default
+// portfolio of the first registered user, after which the column is required.
+func assignUnownedRows(ctx context.Context) error {
+    tx, err := Pool.Begin(ctx)
+    if err != nil {
+        return fmt.Errorf("begin transaction: %v", err)
+    }
+    defer tx.Rollback(ctx)
+
+    var unowned int
+    err = tx.QueryRow(ctx, `
+        SELECT (SELECT COUNT(*) FROM stocks WHERE portfolio_id IS NULL) +
+            (SELECT COUNT(*) FROM corporate_actions WHERE portfolio_id IS NULL)`).Scan(&unowned)
+    if err != nil {
+        return err
+    }
+
+    if unowned > 0 {
+        var owner *int
+        if err := tx.QueryRow(ctx, `SELECT MIN(id) FROM users`).Scan(&owner); err != nil {
+            return err
+        }
+        if owner == nil {
+            return fmt.Errorf("%d stocks and corporate actions predate portfolios but there is no user to own them; register a user first", unowned)
+        }
+
+        var portfolioID int
+        err = tx.QueryRow(ctx, `
+            INSERT INTO portfolios (user_id, name)
+            VALUES ($1, $2)
+            ON CONFLICT (user_id, name) DO UPDATE SET name = EXCLUDED.name
+            RETURNING id`, *owner, DefaultPortfolioName).Scan(&portfolioID)
+        if err != nil {
+            return err
+        }
+        if _, err := tx.Exec(ctx, `UPDATE stocks SET portfolio_id = $1 WHERE portfolio_id IS NULL`, portfolioID); err != nil {
+            return fmt.Errorf("stocks: %v", err)
+        }
+        if _, err := tx.Exec(ctx, `UPDATE corporate_actions SET portfolio_id = $1 WHERE portfolio_id IS NULL`, portfolioID); err != nil {
+            return fmt.Errorf("corporate actions: %v", err)
+        }
+    }
+
+    _, err = tx.Exec(ctx, `
+        ALTER TABLE stocks ALTER COLUMN portfolio_id SET NOT NULL;
+        ALTER TABLE corporate_actions ALTER COLUMN portfolio_id SET NOT NULL;`)
+    if err != nil {
+        return err
+    }
+    return tx.Commit(ctx)
+}
+
+// Positions are rebuilt from the ledger, so one held without any trades
+// would be replaced by its first manual transaction. Each such position gets
+// an opening buy instead, with the splits and spin-offs that recomputing will
+// apply after it undone, so recomputing gives back the same position.
+func seedOpeningBalances(ctx context.Context) error {
+    _, err := Pool.Exec(ctx, `
+        INSERT INTO transactions (portfolio_id, symbol, side, quantity, price, currency, time, source)
+        SELECT s.portfolio_id, s.ticker, $1, s.shares / a.split, s.price * a.split / a.kept,
+            COALESCE(s.currency, 'PLN'), s.date, $2
+        FROM stocks s
+        CROSS JOIN LATERAL (
+            SELECT COALESCE(EXP(SUM(LN(ratio)) FILTER (WHERE type = $3 AND ratio > 0)), 1) AS split,
+                COALESCE(EXP(SUM(LN(1 - cost_fraction)) FILTER (WHERE type = $4 AND cost_fraction < 1)), 1) AS kept
+            FROM corporate_actions ca
+            WHERE ca.portfolio_id = s.portfolio_id AND ca.symbol = s.ticker
+                AND ca.type IN ($3, $4) AND ca.ex_date::timestamptz >= s.date
+        ) a
+        WHERE s.shares > 0 AND NOT EXISTS (
+            SELECT 1 FROM transactions t WHERE t.portfolio_id = s.portfolio_id AND t.symbol = s.ticker)`,
+        models.SideBuy, models.SourceOpeningBalance, models.ActionSplit, models.ActionSpinOff)
+    return err
+}
+
 func createTables() error {
     query := `
+    -- The users migrations, applied here when missing so that databases
+    -- created before them keep working
+    CREATE TABLE IF NOT EXISTS users (
+        id SERIAL PRIMARY KEY,
+        email VARCHAR(255) UNIQUE NOT NULL,
+        password BYTEA NOT NULL,
+        created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+        updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+    );
+    DO $$
+    BEGIN
+        -- Fails if emails differ only in case; such accounts must be merged by hand
+        IF to_regclass('users_email_lower_key') IS NULL THEN
+            UPDATE users SET email = lower(trim(email));
+            CREATE UNIQUE INDEX users_email_lower_key ON users (lower(email));
+        END IF;
+        -- Accounts created before verification existed are trusted as they are
+        IF NOT EXISTS (SELECT 1 FROM information_schema.columns
+                WHERE table_name = 'users' AND column_name = 'email_verified_at') THEN
+            ALTER TABLE users ADD COLUMN email_verified_at TIMESTAMPTZ;
+            UPDATE users SET email_verified_at = created_at;
+        END IF;
+    END $$;
+    ALTER TABLE users ADD COLUMN IF NOT EXISTS role VARCHAR(16) NOT NULL DEFAULT 'user';
+    ALTER TABLE users ADD COLUMN IF NOT EXISTS disabled_at TIMESTAMPTZ;
+    ALTER TABLE users ADD COLUMN IF NOT EXISTS sessions_revoked_at TIMESTAMPTZ;
+
     CREATE TABLE IF NOT EXISTS stocks (
         id SERIAL PRIMARY KEY,
         ticker VARCHAR(10) NOT NULL UNIQUE,
@@ -37,60 +147,251 @@ func createTables() error {
         price DECIMAL(10,4) NOT NULL,
         shares DECIMAL(10,4) NOT NULL DEFAULT 0,
         currency VARCHAR(3) DEFAULT 'PLN'
-    );`
+    );
 
-    _, err := Pool.Exec(context.Background(), query)
-    return err
-}
+    CREATE TABLE IF NOT EXISTS corporate_actions (
+        id SERIAL PRIMARY KEY,
+        symbol VARCHAR(10) NOT NULL,
+        type VARCHAR(16) NOT NULL,
+        ex_date DATE NOT NULL,
+        amount DECIMAL(14,6) NOT NULL DEFAULT 0,
+        total DECIMAL(14,4) NOT NULL DEFAULT 0,
+        ratio DECIMAL(14,6) NOT NULL DEFAULT 0,
+        new_symbol VARCHAR(10) NOT NULL DEFAULT '',
+        cost_fraction DECIMAL(10,6) NOT NULL DEFAULT 0,
+        currency VARCHAR(3) NOT NULL DEFAULT 'PLN',
+        source VARCHAR(16) NOT NULL,
+        external_id VARCHAR(64) UNIQUE,
+        created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+    );
 
-func SaveStocks(ctx context.Context, stocks []models.Stock) error {
-    tx, err := Pool.Begin(ctx)
-    if err != nil {
-        return fmt.Errorf("begin transaction: %v", err)
-    }
-    defer tx.Rollback(ctx)
+    CREATE TABLE IF NOT EXISTS portfolios (
+        id SERIAL PRIMARY KEY,
+        user_id INTEGER NOT NULL,
+        name VARCHAR(100) NOT NULL,
+        currency VARCHAR(3) NOT NULL DEFAULT 'PLN',
+        created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+        UNIQUE (user_id, name)
+    );
 
-    batch := &pgx.Batch{}
-    for _, stock := range stocks {
-        parsedTime, err := models.ParseTime(stock.Time)
-        if err != nil {
-            log.Printf("Error parsing time for stock %s: %v", stock.Symbol, err)
-            continue
-        }
+    ALTER TABLE stocks ADD COLUMN IF NOT EXISTS
+        portfolio_id INTEGER REFERENCES portfolios(id) ON DELETE CASCADE;
+    ALTER TABLE stocks DROP CONSTRAINT IF EXISTS stocks_ticker_key;
+    CREATE UNIQUE INDEX IF NOT EXISTS stocks_portfolio_ticker_key ON stocks (portfolio_id, ticker);
 
-        batch.Queue(`
-            INSERT INTO stocks (ticker, date, price, shares, currency)
-            VALUES ($1, $2, $3, $4, $5)
-            ON CONFLICT (ticker) DO UPDATE 
-            SET 
-                price = (stocks.price * stocks.shares + EXCLUDED.price * EXCLUDED.shares) / 
-                       NULLIF(stocks.shares + EXCLUDED.shares, 0),
-                shares = stocks.shares + EXCLUDED.shares,
-                date = GREATEST(stocks.date, EXCLUDED.date)
-            WHERE stocks.ticker = EXCLUDED.ticker
-        `, stock.Symbol, parsedTime, stock.Price, stock.Shares, "PLN")
-    }
+    ALTER TABLE corporate_actions ADD COLUMN IF NOT EXISTS
+        portfolio_id INTEGER REFERENCES portfolios(id) ON DELETE CASCADE;
+    ALTER TABLE corporate_actions DROP CONSTRAINT IF EXISTS corporate_actions_external_id_key;
+    CREATE UNIQUE INDEX IF NOT EXISTS corporate_actions_portfolio_external_id_key
+        ON corporate_actions (portfolio_id, external_id);
 
-    br := tx.SendBatch(ctx, batch)
-    defer br.Close()
+    CREATE TABLE IF NOT EXISTS cash_operations (
+        id SERIAL PRIMARY KEY,
+        portfolio_id INTEGER NOT NULL REFERENCES portfolios(id) ON DELETE CASCADE,
+        type VARCHAR(16) NOT NULL,
+        currency VARCHAR(3) NOT NULL DEFAULT 'PLN',
+        amount DECIMAL(14,4) NOT NULL,
+        time TIMESTAMPTZ NOT NULL,
+        symbol VARCHAR(10) NOT NULL DEFAULT '',
+        comment TEXT NOT NULL DEFAULT '',
+        source VARCHAR(16) NOT NULL,
+        external_id VARCHAR(64),
+        UNIQUE (portfolio_id, external_id)
+    );
 
-    if err := br.Close(); err != nil {
-        return fmt.Errorf("failed to execute batch: %v", err)
-    }
+    CREATE TABLE IF NOT EXISTS transactions (
+        id SERIAL PRIMARY KEY,
+        portfolio_id INTEGER NOT NULL REFERENCES portfolios(id) ON DELETE CASCADE,
+        symbol VARCHAR(10) NOT NULL,
+        side VARCHAR(4) NOT NULL,
+        quantity DECIMAL(14,4) NOT NULL,
+        price DECIMAL(14,4) NOT NULL,
+        currency VARCHAR(3) NOT NULL,
+        commission DECIMAL(14,4) NOT NULL DEFAULT 0,
+        commission_currency VARCHAR(3) NOT NULL DEFAULT 'PLN',
+        time TIMESTAMPTZ NOT NULL,
+        source VARCHAR(16) NOT NULL,
+        external_id VARCHAR(64),
+        UNIQUE (portfolio_id, external_id)
+    );
 
-    return tx.Commit(ctx)
+    -- The cash side of manual trades and dividends, which go with them
+    ALTER TABLE cash_operations
+        ADD COLUMN IF NOT EXISTS transaction_id INTEGER REFERENCES transactions(id) ON DELETE CASCADE,
+        ADD COLUMN IF NOT EXISTS corporate_action_id INTEGER REFERENCES corporate_actions(id) ON DELETE CASCADE;
+
+    CREATE TABLE IF NOT EXISTS transaction_audit (
+        id SERIAL PRIMARY KEY,
+        transaction_id INTEGER NOT NULL,
+        portfolio_id INTEGER NOT NULL REFERENCES portfolios(id) ON DELETE CASCADE,
+        user_id INTEGER NOT NULL,
+        action VARCHAR(16) NOT NULL,
+        before_data JSONB,
+        after_data JSONB,
+        created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+    );
+
+    CREATE TABLE IF NOT EXISTS rate_limits (
+        key VARCHAR(320) PRIMARY KEY,
+        tokens DOUBLE PRECISION NOT NULL,
+        updated_at TIMESTAMPTZ NOT NULL
+    );
+
+    CREATE TABLE IF NOT EXISTS login_lockouts (
+        key VARCHAR(320) PRIMARY KEY,
+        failures INTEGER NOT NULL DEFAULT 0,
+        locked_until TIMESTAMPTZ NOT NULL,
+        updated_at TIMESTAMPTZ NOT NULL
+    );
+
+    CREATE TABLE IF NOT EXISTS password_resets (
+        id SERIAL PRIMARY KEY,
+        user_id INTEGER NOT NULL,
+        token_hash BYTEA NOT NULL UNIQUE,
+        expires_at TIMESTAMPTZ NOT NULL,
+        used_at TIMESTAMPTZ,
+        created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+    );
+
+    CREATE TABLE IF NOT EXISTS email_verifications (
+        id SERIAL PRIMARY KEY,
+        user_id INTEGER NOT NULL,
+        token_hash BYTEA NOT NULL UNIQUE,
+        expires_at TIMESTAMPTZ NOT NULL,
+        used_at TIMESTAMPTZ,
+        created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+    );
+
+    CREATE TABLE IF NOT EXISTS user_totp (
+        user_id INTEGER PRIMARY KEY,
+        secret VARCHAR(64) NOT NULL,
+        enabled_at TIMESTAMPTZ,
+        last_step BIGINT NOT NULL DEFAULT 0,
+        created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+    );
+
+    CREATE TABLE IF NOT EXISTS recovery_codes (
+        id SERIAL PRIMARY KEY,
+        user_id INTEGER NOT NULL,
+        code_id VARCHAR(8) NOT NULL,
+        code_hash TEXT NOT NULL,
+        used_at TIMESTAMPTZ,
+        UNIQUE (user_id, code_id)
+    );
+
+    CREATE TABLE IF NOT EXISTS api_keys (
+        id SERIAL PRIMARY KEY,
+        user_id INTEGER NOT NULL,
+        name VARCHAR(100) NOT NULL,
+        prefix VARCHAR(16) NOT NULL,
+        key_hash BYTEA NOT NULL UNIQUE,
+        scopes TEXT[] NOT NULL,
+        expires_at TIMESTAMPTZ,
+        last_used_at TIMESTAMPTZ,
+        created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+    );
+
+    CREATE TABLE IF NOT EXISTS imports (
+        id SERIAL PRIMARY KEY,
+        user_id INTEGER NOT NULL,
+        portfolio_id INTEGER NOT NULL REFERENCES portfolios(id) ON DELETE CASCADE,
+        filename VARCHAR(255) NOT NULL,
+        status VARCHAR(16) NOT NULL,
+        transactions INTEGER NOT NULL DEFAULT 0,
+        dividends INTEGER NOT NULL DEFAULT 0,
+        cash_operations INTEGER NOT NULL DEFAULT 0,
+        error TEXT NOT NULL DEFAULT '',
+        created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+    );
+
+    ALTER TABLE imports
+        ADD COLUMN IF NOT EXISTS file BYTEA,
+        ADD COLUMN IF NOT EXISTS total_rows INTEGER NOT NULL DEFAULT 0,
+        ADD COLUMN IF NOT EXISTS processed_rows INTEGER NOT NULL DEFAULT 0,
+        ADD COLUMN IF NOT EXISTS issues JSONB,
+        ADD COLUMN IF NOT EXISTS attempts INTEGER NOT NULL DEFAULT 0,
+        ADD COLUMN IF NOT EXISTS started_at TIMESTAMPTZ,
+        ADD COLUMN IF NOT EXISTS finished_at TIMESTAMPTZ,
+        ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
+    CREATE INDEX IF NOT EXISTS imports_pending_idx ON imports (id) WHERE status IN ('queued', 'processing');
+
+    CREATE TABLE IF NOT EXISTS portfolio_members (
+        portfolio_id INTEGER NOT NULL REFERENCES portfolios(id) ON DELETE CASCADE,
+        user_id INTEGER NOT NULL,
+        access VARCHAR(16) NOT NULL,
+        invited_by INTEGER NOT NULL,
+        created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+        PRIMARY KEY (portfolio_id, user_id)
+    );
+    CREATE INDEX IF NOT EXISTS portfolio_members_user_id_idx ON portfolio_members (user_id);
+
+    CREATE TABLE IF NOT EXISTS portfolio_invites (
+        id SERIAL PRIMARY KEY,
+        portfolio_id INTEGER NOT NULL REFERENCES portfolios(id) ON DELETE CASCADE,
+        email VARCHAR(255) NOT NULL,
+        access VARCHAR(16) NOT NULL,
+        invited_by INTEGER NOT NULL,
+        created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+        UNIQUE (portfolio_id, email)
+    );
+    CREATE INDEX IF NOT EXISTS portfolio_invites_email_idx ON portfolio_invites (email);
+
+    CREATE TABLE IF NOT EXISTS portfolio_activity (
+        id SERIAL PRIMARY KEY,
+        portfolio_id INTEGER NOT NULL REFERENCES portfolios(id) ON DELETE CASCADE,
+        user_id INTEGER NOT NULL,
+        action VARCHAR(32) NOT NULL,
+        details JSONB,
+        created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+    );
+
+    CREATE TABLE IF NOT EXISTS share_links (
+        id SERIAL PRIMARY KEY,
+        portfolio_id INTEGER NOT NULL REFERENCES portfolios(id) ON DELETE CASCADE,
+        created_by INTEGER NOT NULL,
+        prefix VARCHAR(16) NOT NULL,
+        token_hash BYTEA NOT NULL UNIQUE,
+        hide_amounts BOOLEAN NOT NULL,
+        hide_cost_basis BOOLEAN NOT NULL,
+        expires_at TIMESTAMPTZ,
+        last_used_at TIMESTAMPTZ,
+        created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+    );
+
+    CREATE TABLE IF NOT EXISTS oidc_logins (
+        state_hash BYTEA PRIMARY KEY,
+        nonce VARCHAR(64) NOT NULL,
+        code_verifier VARCHAR(128) NOT NULL,
+        expires_at TIMESTAMPTZ NOT NULL
+    );
+
+    CREATE TABLE IF NOT EXISTS user_identities (
+        id SERIAL PRIMARY KEY,
+        user_id INTEGER NOT NULL,
+        issuer VARCHAR(255) NOT NULL,
+        subject VARCHAR(255) NOT NULL,
+        email VARCHAR(254) NOT NULL DEFAULT '',
+        last_login_at TIMESTAMPTZ,
+        created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+        UNIQUE (issuer, subject)
+    );`
+
+    _, err := Pool.Exec(context.Background(), query)
+    return err
 }
 
-func GetAllStocks(ctx context.Context) ([]models.Stock, error) {
+func GetStocks(ctx context.Context, portfolioID int) ([]models.Stock, error) {
     rows, err := Pool.Query(ctx, `
         SELECT 
             id,
             ticker,
             TO_CHAR(date, 'YYYY-MM-DD HH24:MI:SS') as formatted_date,
             price,
-            shares
+            shares,
+            COALESCE(currency, 'PLN')
         FROM stocks 
-        ORDER BY date DESC`)
+        WHERE portfolio_id = $1
+        ORDER BY date DESC`, portfolioID)
     if err != nil {
         return nil, err
     }
@@ -99,7 +400,7 @@ func GetAllStocks(ctx context.Context) ([]models.Stock, error) {
     var stocks []models.Stock
     for rows.Next() {
         var s models.Stock
-        if err := rows.Scan(&s.ID, &s.Symbol, &s.Time, &s.Price, &s.Shares); err != nil {
+        if err := rows.Scan(&s.ID, &s.Symbol, &s.Time, &s.Price, &s.Shares, &s.Currency); err != nil {
             return nil, fmt.Errorf("scan error: %v", err)
         }
         stocks = append(stocks, s)
@@ -118,12 +419,12 @@ func CreateUser(ctx context.Context, user *models.User) error {
     query := `
         INSERT INTO users (email, password)
         VALUES ($1, $2)
-        RETURNING id, created_at, updated_at`
+        RETURNING id, role, created_at, updated_at`
 
     err := Pool.QueryRow(ctx, query, user.Email, user.Password).
-        Scan(&user.ID, &user.CreatedAt, &user.UpdatedAt)
+        Scan(&user.ID, &user.Role, &user.CreatedAt, &user.UpdatedAt)
     if err != nil {
-        log.Printf("Database error creating user: %v", err)
+        reqctx.Logger(ctx).Error("Database error creating user", "error", err)
         if strings.Contains(err.Error(), "unique constraint") {
             return ErrDuplicateEmail
         }
@@ -133,17 +434,37 @@ func CreateUser(ctx context.Context, user *models.User) error {
     return nil
 }
 
-func GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+const userColumns = `id, email, password, role, email_verified_at, disabled_at, sessions_revoked_at, created_at, updated_at`
+
+func scanUser(row pgx.Row) (*models.User, error) {
     user := &models.User{}
-    query := `
-        SELECT id, email, password, created_at, updated_at
+    err := row.Scan(&user.ID, &user.Email, &user.Password, &user.Role, &user.EmailVerifiedAt,
+        &user.DisabledAt, &user.SessionsRevokedAt, &user.CreatedAt, &user.UpdatedAt)
+    if err == pgx.ErrNoRows {
+        return nil, ErrUserNotFound
+    }
+    return user, err
+}
+
+func GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+    user, err := scanUser(Pool.QueryRow(ctx, `
+        SELECT `+userColumns+`
         FROM users
-        WHERE email = $1`
+        WHERE lower(email) = lower($1)`, email))
+    if err != nil {
+        return nil, err
+    }
 
-    err := Pool.QueryRow(ctx, query, email).
-        Scan(&user.ID, &user.Email, &user.Password, &user.CreatedAt, &user.UpdatedAt)
+    return user, nil
+}
+
+func GetUserByID(ctx context.Context, id int) (*models.User, error) {
+    user, err := scanUser(Pool.QueryRow(ctx, `
+        SELECT `+userColumns+`
+        FROM users
+        WHERE id = $1`, id))
     if err != nil {
-        return nil, ErrUserNotFound
+        return nil, err
     }
 
     return user, nil
@@ -0,0 +1,88 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrSchemaIncomplete = errors.New("database schema incomplete")
+
+// Tables the server expects; users comes from migrations/, the rest from createTables
+var requiredTables = []string{
+    "users",
+    "portfolios",
+    "stocks",
+    "corporate_actions",
+    "cash_operations",
+    "transactions",
+    "transaction_audit",
+    "rate_limits",
+    "login_lockouts",
+    "password_resets",
+    "email_verifications",
+    "user_totp",
+    "recovery_codes",
+    "api_keys",
+    "imports",
+    "portfolio_members",
+    "portfolio_invites",
+    "portfolio_activity",
+    "share_links",
+    "oidc_logins",
+    "user_identities",
+}
+
+// Columns added to users by later migrations
+var requiredColumns = []string{
+    "email_verified_at",
+    "role",
+    "disabled_at",
+    "sessions_revoked_at",
+}
+
+func Ping(ctx context.Context) error {
+    return Pool.Ping(ctx)
+}
+
+// CheckSchema reports an error naming every required table that is missing,
+// or if the users migrations have not all been applied
+func CheckSchema(ctx context.Context) error {
+    rows, err := Pool.Query(ctx, `
+        SELECT name FROM unnest($1::text[]) AS name
+        WHERE to_regclass(name) IS NULL`, requiredTables)
+    if err != nil {
+        return err
+    }
+    defer rows.Close()
+
+    var missing []string
+    for rows.Next() {
+        var name string
+        if err := rows.Scan(&name); err != nil {
+            return err
+        }
+        missing = append(missing, name)
+    }
+    if err := rows.Err(); err != nil {
+        return err
+    }
+
+    if len(missing) > 0 {
+        return fmt.Errorf("%w: missing tables %s", ErrSchemaIncomplete, strings.Join(missing, ", "))
+    }
+
+    var count int
+    err = Pool.QueryRow(ctx, `
+        SELECT count(*) FROM information_schema.columns
+        WHERE table_schema = current_schema() AND table_name = 'users'
+          AND column_name = ANY($1)`, requiredColumns).Scan(&count)
+    if err != nil {
+        return err
+    }
+    if count < len(requiredColumns) {
+        return fmt.Errorf("%w: users is missing columns, apply db/migrations", ErrSchemaIncomplete)
+    }
+    return nil
+}
\ No newline at end of file
@@ -0,0 +1,137 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"server/models"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+    ErrOIDCStateInvalid = errors.New("OIDC login state invalid or expired")
+    ErrIdentityNotFound = errors.New("identity not found")
+    ErrIdentityLinked   = errors.New("identity already linked to an account")
+)
+
+// CreateOIDCLogin remembers a login in progress until its callback arrives,
+// clearing out abandoned ones
+func CreateOIDCLogin(ctx context.Context, stateHash []byte, nonce, codeVerifier string, expiresAt time.Time) error {
+    if _, err := Pool.Exec(ctx, `DELETE FROM oidc_logins WHERE expires_at < NOW()`); err != nil {
+        return err
+    }
+    _, err := Pool.Exec(ctx, `
+        INSERT INTO oidc_logins (state_hash, nonce, code_verifier, expires_at)
+        VALUES ($1, $2, $3, $4)`, stateHash, nonce, codeVerifier, expiresAt)
+    return err
+}
+
+// ClaimOIDCLogin consumes the login started with this state, so each one
+// can complete only once
+func ClaimOIDCLogin(ctx context.Context, stateHash []byte) (nonce, codeVerifier string, err error) {
+    var expiresAt time.Time
+    err = Pool.QueryRow(ctx, `
+        DELETE FROM oidc_logins WHERE state_hash = $1
+        RETURNING nonce, code_verifier, expires_at`, stateHash).Scan(&nonce, &codeVerifier, &expiresAt)
+    if err == pgx.ErrNoRows || (err == nil && !time.Now().Before(expiresAt)) {
+        return "", "", ErrOIDCStateInvalid
+    }
+    return nonce, codeVerifier, err
+}
+
+func GetUserByIdentity(ctx context.Context, issuer, subject string) (*models.User, error) {
+    return scanUser(Pool.QueryRow(ctx, `
+        SELECT `+userColumns+`
+        FROM users
+        WHERE id = (SELECT user_id FROM user_identities WHERE issuer = $1 AND subject = $2)`,
+        issuer, subject))
+}
+
+func LinkIdentity(ctx context.Context, userID int, issuer, subject, email string) error {
+    _, err := Pool.Exec(ctx, `
+        INSERT INTO user_identities (user_id, issuer, subject, email, last_login_at)
+        VALUES ($1, $2, $3, $4, NOW())`, userID, issuer, subject, email)
+    if err != nil && strings.Contains(err.Error(), "unique constraint") {
+        return ErrIdentityLinked
+    }
+    return err
+}
+
+// CreateUserWithIdentity registers an account for an identity that matched
+// none. The email counts as verified if the issuer said so.
+func CreateUserWithIdentity(ctx context.Context, user *models.User, issuer, subject string, emailVerified bool) error {
+    tx, err := Pool.Begin(ctx)
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback(ctx)
+
+    err = tx.QueryRow(ctx, `
+        INSERT INTO users (email, password, email_verified_at)
+        VALUES ($1, $2, CASE WHEN $3 THEN NOW() END)
+        RETURNING id, role, email_verified_at, created_at, updated_at`,
+        user.Email, user.Password, emailVerified,
+    ).Scan(&user.ID, &user.Role, &user.EmailVerifiedAt, &user.CreatedAt, &user.UpdatedAt)
+    if err != nil {
+        if strings.Contains(err.Error(), "unique constraint") {
+            return ErrDuplicateEmail
+        }
+        return fmt.Errorf("failed to create user: %w", err)
+    }
+
+    if _, err := tx.Exec(ctx, `
+        INSERT INTO user_identities (user_id, issuer, subject, email, last_login_at)
+        VALUES ($1, $2, $3, $4, NOW())`, user.ID, issuer, subject, user.Email); err != nil {
+        if strings.Contains(err.Error(), "unique constraint") {
+            return ErrIdentityLinked
+        }
+        return err
+    }
+    return tx.Commit(ctx)
+}
+
+// TouchIdentity records a login and the email the issuer currently reports
+func TouchIdentity(ctx context.Context, issuer, subject, email string) error {
+    _, err := Pool.Exec(ctx, `
+        UPDATE user_identities SET last_login_at = NOW(), email = $3
+        WHERE issuer = $1 AND subject = $2`, issuer, subject, email)
+    return err
+}
+
+func GetIdentities(ctx context.Context, userID int) ([]models.Identity, error) {
+    rows, err := Pool.Query(ctx, `
+        SELECT id, user_id, issuer, subject, email, last_login_at, created_at
+        FROM user_identities
+        WHERE user_id = $1
+        ORDER BY id`, userID)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    identities := make([]models.Identity, 0)
+    for rows.Next() {
+        var i models.Identity
+        if err := rows.Scan(&i.ID, &i.UserID, &i.Issuer, &i.Subject, &i.Email, &i.LastLoginAt, &i.CreatedAt); err != nil {
+            return nil, err
+        }
+        identities = append(identities, i)
+    }
+    return identities, rows.Err()
+}
+
+// DeleteIdentity unlinks an identity; only its owner may do so
+func DeleteIdentity(ctx context.Context, userID, id int) error {
+    tag, err := Pool.Exec(ctx, `DELETE FROM user_identities WHERE id = $1 AND user_id = $2`, id, userID)
+    if err != nil {
+        return err
+    }
+    if tag.RowsAffected() == 0 {
+        return ErrIdentityNotFound
+    }
+    return nil
+}
\ No newline at end of file
@@ -0,0 +1,306 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"server/models"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+    ErrImportNotFound     = errors.New("import not found")
+    ErrImportNotPreviewed = errors.New("import is not awaiting confirmation")
+)
+
+const importColumns = `id, user_id, portfolio_id, filename, status, total_rows, processed_rows,
+    transactions, dividends, cash_operations, error, attempts, created_at, started_at, finished_at`
+
+func scanImport(row pgx.Row, extra ...interface{}) (*models.Import, error) {
+    imp := &models.Import{}
+    dest := append([]interface{}{&imp.ID, &imp.UserID, &imp.PortfolioID, &imp.Filename, &imp.Status,
+        &imp.TotalRows, &imp.ProcessedRows, &imp.Transactions, &imp.Dividends, &imp.CashOperations,
+        &imp.Error, &imp.Attempts, &imp.CreatedAt, &imp.StartedAt, &imp.FinishedAt}, extra...)
+    if err := row.Scan(dest...); err != nil {
+        if err == pgx.ErrNoRows {
+            return nil, ErrImportNotFound
+        }
+        return nil, err
+    }
+
+    switch {
+    case imp.Status == models.ImportCompleted:
+        imp.Progress = 100
+    case imp.TotalRows > 0:
+        imp.Progress = float64(imp.ProcessedRows*1000/imp.TotalRows) / 10
+    }
+    return imp, nil
+}
+
+// CreateImport queues file for processing, or keeps it for a later
+// ConfirmImport when imp is a preview
+func CreateImport(ctx context.Context, imp *models.Import, file []byte) error {
+    if imp.Status == "" {
+        imp.Status = models.ImportQueued
+    }
+    var issues []byte
+    if imp.Issues != nil {
+        var err error
+        if issues, err = json.Marshal(imp.Issues); err != nil {
+            return err
+        }
+    }
+    return Pool.QueryRow(ctx, `
+        INSERT INTO imports (user_id, portfolio_id, filename, status, total_rows, issues, file)
+        VALUES ($1, $2, $3, $4, $5, $6, $7)
+        RETURNING id, created_at`,
+        imp.UserID, imp.PortfolioID, imp.Filename, imp.Status, imp.TotalRows, issues, file,
+    ).Scan(&imp.ID, &imp.CreatedAt)
+}
+
+// ConfirmImport queues a preview made within ttl
+func ConfirmImport(ctx context.Context, id int, ttl time.Duration) error {
+    tag, err := Pool.Exec(ctx, `
+        UPDATE imports SET status = 'queued', updated_at = NOW()
+        WHERE id = $1 AND status = 'previewed' AND created_at > NOW() - make_interval(secs => $2)`,
+        id, ttl.Seconds())
+    if err != nil {
+        return err
+    }
+    if tag.RowsAffected() == 0 {
+        return ErrImportNotPreviewed
+    }
+    return nil
+}
+
+// ExpirePreviews drops the files of previews nobody confirmed within ttl
+func ExpirePreviews(ctx context.Context, ttl time.Duration) (int64, error) {
+    tag, err := Pool.Exec(ctx, `
+        UPDATE imports SET status = 'expired', file = NULL, finished_at = NOW(), updated_at = NOW()
+        WHERE status = 'previewed' AND created_at <= NOW() - make_interval(secs => $1)`,
+        ttl.Seconds())
+    if err != nil {
+        return 0, err
+    }
+    return tag.RowsAffected(), nil
+}
+
+// ImportedExternalIDs returns which of the given statement IDs the portfolio
+// already has, by kind of record
+func ImportedExternalIDs(ctx context.Context, portfolioID int, ids map[string][]string) (map[string]map[string]bool, error) {
+    rows, err := Pool.Query(ctx, `
+        SELECT 'transaction', external_id FROM transactions
+        WHERE portfolio_id = $1 AND external_id = ANY($2)
+        UNION ALL
+        SELECT 'dividend', external_id FROM corporate_actions
+        WHERE portfolio_id = $1 AND external_id = ANY($3)
+        UNION ALL
+        SELECT 'cash', external_id FROM cash_operations
+        WHERE portfolio_id = $1 AND external_id = ANY($4)`,
+        portfolioID, ids[models.RecordTransaction], ids[models.RecordDividend], ids[models.RecordCash])
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    found := map[string]map[string]bool{
+        models.RecordTransaction: {},
+        models.RecordDividend:    {},
+        models.RecordCash:        {},
+    }
+    for rows.Next() {
+        var kind, id string
+        if err := rows.Scan(&kind, &id); err != nil {
+            return nil, err
+        }
+        found[kind][id] = true
+    }
+    return found, rows.Err()
+}
+
+// SaveImport stores a parsed statement and rebuilds the positions it touches
+// in one transaction, so a failed or interrupted import leaves nothing
+// behind. Records the portfolio already has are skipped, and imp's counts
+// are set to those actually added. Rows are saved chunkSize at a time,
+// calling progress after each chunk; an error from it aborts the import.
+func SaveImport(ctx context.Context, imp *models.Import, dividends []models.CorporateAction,
+    cashOps []models.CashOperation, txs []models.Transaction, chunkSize int, progress func(int) error) error {
+    tx, err := Pool.Begin(ctx)
+    if err != nil {
+        return fmt.Errorf("begin transaction: %v", err)
+    }
+    defer tx.Rollback(ctx)
+
+    pid := imp.PortfolioID
+    imp.Dividends, err = saveInChunks(dividends, chunkSize, func(c []models.CorporateAction) (int, error) {
+        return saveImportedDividends(ctx, tx, pid, c)
+    }, progress)
+    if err != nil {
+        return fmt.Errorf("saving dividends: %w", err)
+    }
+    imp.CashOperations, err = saveInChunks(cashOps, chunkSize, func(c []models.CashOperation) (int, error) {
+        return saveCashOperations(ctx, tx, pid, c)
+    }, progress)
+    if err != nil {
+        return fmt.Errorf("saving cash operations: %w", err)
+    }
+    imp.Transactions, err = saveInChunks(txs, chunkSize, func(c []models.Transaction) (int, error) {
+        return saveTransactions(ctx, tx, pid, c)
+    }, progress)
+    if err != nil {
+        return fmt.Errorf("saving transactions: %w", err)
+    }
+
+    if imp.Transactions > 0 {
+        symbols := make(map[string]bool)
+        for _, t := range txs {
+            if !symbols[t.Symbol] {
+                symbols[t.Symbol] = true
+                if err := recomputePosition(ctx, tx, pid, t.Symbol, false); err != nil {
+                    return fmt.Errorf("updating position %s: %w", t.Symbol, err)
+                }
+            }
+        }
+    }
+    return tx.Commit(ctx)
+}
+
+// Returns the total the save calls report
+func saveInChunks[T any](items []T, chunkSize int, save func([]T) (int, error), progress func(int) error) (int, error) {
+    saved := 0
+    for len(items) > 0 {
+        n := min(len(items), chunkSize)
+        added, err := save(items[:n])
+        if err != nil {
+            return saved, err
+        }
+        saved += added
+        if err := progress(n); err != nil {
+            return saved, err
+        }
+        items = items[n:]
+    }
+    return saved, nil
+}
+
+// Runs the queued statements and adds up the rows they affected
+func execBatch(ctx context.Context, tx pgx.Tx, batch *pgx.Batch) (int, error) {
+    br := tx.SendBatch(ctx, batch)
+    defer br.Close()
+
+    affected := 0
+    for i := 0; i < batch.Len(); i++ {
+        tag, err := br.Exec()
+        if err != nil {
+            return 0, err
+        }
+        affected += int(tag.RowsAffected())
+    }
+    return affected, br.Close()
+}
+
+// ClaimImport takes the oldest queued import, or one whose worker has not
+// reported progress for staleAfter and is presumed dead. It returns nil when
+// there is nothing to do.
+func ClaimImport(ctx context.Context, staleAfter time.Duration) (*models.Import, []byte, error) {
+    var file []byte
+    imp, err := scanImport(Pool.QueryRow(ctx, `
+        UPDATE imports
+        SET status = 'processing', attempts = attempts + 1, processed_rows = 0,
+            started_at = NOW(), updated_at = NOW()
+        WHERE id = (
+            SELECT id FROM imports
+            WHERE status = 'queued'
+                OR (status = 'processing' AND updated_at < NOW() - make_interval(secs => $1))
+            ORDER BY id
+            LIMIT 1
+            FOR UPDATE SKIP LOCKED)
+        RETURNING `+importColumns+`, file`, staleAfter.Seconds()), &file)
+    if err == ErrImportNotFound {
+        return nil, nil, nil
+    }
+    return imp, file, err
+}
+
+// UpdateImportProgress also tells other workers this one is still alive
+func UpdateImportProgress(ctx context.Context, id, processed, total int) error {
+    _, err := Pool.Exec(ctx, `
+        UPDATE imports SET processed_rows = $2, total_rows = $3, updated_at = NOW()
+        WHERE id = $1`, id, processed, total)
+    return err
+}
+
+// FinishImport stores the outcome and drops the file, which is no longer needed
+func FinishImport(ctx context.Context, imp *models.Import) error {
+    issues, err := json.Marshal(imp.Issues)
+    if err != nil {
+        return err
+    }
+    _, err = Pool.Exec(ctx, `
+        UPDATE imports
+        SET status = $2, processed_rows = $3, total_rows = $4, transactions = $5, dividends = $6,
+            cash_operations = $7, error = $8, issues = $9, file = NULL,
+            finished_at = NOW(), updated_at = NOW()
+        WHERE id = $1`,
+        imp.ID, imp.Status, imp.ProcessedRows, imp.TotalRows, imp.Transactions, imp.Dividends,
+        imp.CashOperations, imp.Error, issues)
+    if err != nil {
+        return fmt.Errorf("failed to finish import: %v", err)
+    }
+    return nil
+}
+
+// RequeueImport hands an interrupted import back for another worker
+func RequeueImport(ctx context.Context, id int) error {
+    _, err := Pool.Exec(ctx, `
+        UPDATE imports SET status = 'queued', updated_at = NOW()
+        WHERE id = $1 AND status = 'processing'`, id)
+    return err
+}
+
+// GetImport includes the per-row issues, which the lists leave out
+func GetImport(ctx context.Context, id int) (*models.Import, error) {
+    var issues []byte
+    imp, err := scanImport(Pool.QueryRow(ctx, `
+        SELECT `+importColumns+`, issues
+        FROM imports
+        WHERE id = $1`, id), &issues)
+    if err != nil {
+        return nil, err
+    }
+    if issues != nil {
+        if err := json.Unmarshal(issues, &imp.Issues); err != nil {
+            return nil, fmt.Errorf("decoding import issues: %v", err)
+        }
+    }
+    return imp, nil
+}
+
+// GetImports returns the most recent imports first. A userID of 0 lists
+// every user's.
+func GetImports(ctx context.Context, userID, limit int) ([]models.Import, error) {
+    rows, err := Pool.Query(ctx, `
+        SELECT `+importColumns+`
+        FROM imports
+        WHERE $1 = 0 OR user_id = $1
+        ORDER BY id DESC
+        LIMIT $2`, userID, limit)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    imports := make([]models.Import, 0)
+    for rows.Next() {
+        imp, err := scanImport(rows)
+        if err != nil {
+            return nil, err
+        }
+        imports = append(imports, *imp)
+    }
+    return imports, rows.Err()
+}
\ No newline at end of file
@@ -0,0 +1,218 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"server/models"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+    ErrMemberNotFound = errors.New("portfolio member not found")
+    ErrMemberExists   = errors.New("portfolio already shared with this user")
+    ErrInviteNotFound = errors.New("portfolio invitation not found")
+)
+
+const inviteColumns = `i.id, i.portfolio_id, p.name, i.email, i.access, i.invited_by,
+    TO_CHAR(i.created_at, 'YYYY-MM-DD HH24:MI:SS')`
+
+func GetPortfolioMembers(ctx context.Context, portfolioID int) ([]models.PortfolioMember, error) {
+    rows, err := Pool.Query(ctx, `
+        SELECT m.portfolio_id, m.user_id, u.email, m.access, m.invited_by,
+            TO_CHAR(m.created_at, 'YYYY-MM-DD HH24:MI:SS')
+        FROM portfolio_members m
+        JOIN users u ON u.id = m.user_id
+        WHERE m.portfolio_id = $1
+        ORDER BY m.created_at, m.user_id`, portfolioID)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    members := make([]models.PortfolioMember, 0)
+    for rows.Next() {
+        var m models.PortfolioMember
+        if err := rows.Scan(&m.PortfolioID, &m.UserID, &m.Email, &m.Access, &m.InvitedBy, &m.CreatedAt); err != nil {
+            return nil, fmt.Errorf("scan error: %v", err)
+        }
+        members = append(members, m)
+    }
+    return members, rows.Err()
+}
+
+func UpdatePortfolioMember(ctx context.Context, portfolioID, userID int, access string) error {
+    tag, err := Pool.Exec(ctx, `
+        UPDATE portfolio_members SET access = $3
+        WHERE portfolio_id = $1 AND user_id = $2`, portfolioID, userID, access)
+    if err != nil {
+        return err
+    }
+    if tag.RowsAffected() == 0 {
+        return ErrMemberNotFound
+    }
+    return nil
+}
+
+func RemovePortfolioMember(ctx context.Context, portfolioID, userID int) error {
+    tag, err := Pool.Exec(ctx, `
+        DELETE FROM portfolio_members
+        WHERE portfolio_id = $1 AND user_id = $2`, portfolioID, userID)
+    if err != nil {
+        return err
+    }
+    if tag.RowsAffected() == 0 {
+        return ErrMemberNotFound
+    }
+    return nil
+}
+
+// InvitePortfolioMember offers access to an email address. Inviting the same
+// address again replaces the earlier invitation. Fills in ID and CreatedAt.
+func InvitePortfolioMember(ctx context.Context, inv *models.PortfolioInvitation) error {
+    err := Pool.QueryRow(ctx, `
+        INSERT INTO portfolio_invites (portfolio_id, email, access, invited_by)
+        VALUES ($1, $2, $3, $4)
+        ON CONFLICT (portfolio_id, email) DO UPDATE
+        SET access = EXCLUDED.access, invited_by = EXCLUDED.invited_by, created_at = NOW()
+        RETURNING id, TO_CHAR(created_at, 'YYYY-MM-DD HH24:MI:SS')`,
+        inv.PortfolioID, inv.Email, inv.Access, inv.InvitedBy,
+    ).Scan(&inv.ID, &inv.CreatedAt)
+    if err != nil {
+        return fmt.Errorf("failed to save invitation: %w", err)
+    }
+    return nil
+}
+
+func queryInvites(ctx context.Context, where string, arg interface{}) ([]models.PortfolioInvitation, error) {
+    rows, err := Pool.Query(ctx, `
+        SELECT `+inviteColumns+`
+        FROM portfolio_invites i
+        JOIN portfolios p ON p.id = i.portfolio_id
+        WHERE `+where+`
+        ORDER BY i.created_at, i.id`, arg)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    invites := make([]models.PortfolioInvitation, 0)
+    for rows.Next() {
+        var i models.PortfolioInvitation
+        if err := rows.Scan(&i.ID, &i.PortfolioID, &i.PortfolioName, &i.Email, &i.Access, &i.InvitedBy,
+            &i.CreatedAt); err != nil {
+            return nil, fmt.Errorf("scan error: %v", err)
+        }
+        invites = append(invites, i)
+    }
+    return invites, rows.Err()
+}
+
+func GetPortfolioInvites(ctx context.Context, portfolioID int) ([]models.PortfolioInvitation, error) {
+    return queryInvites(ctx, "i.portfolio_id = $1", portfolioID)
+}
+
+// GetInvitesForEmail lists the invitations waiting for an address
+func GetInvitesForEmail(ctx context.Context, email string) ([]models.PortfolioInvitation, error) {
+    return queryInvites(ctx, "i.email = $1", email)
+}
+
+// DeletePortfolioInvite revokes an invitation to the given portfolio
+func DeletePortfolioInvite(ctx context.Context, portfolioID, id int) error {
+    tag, err := Pool.Exec(ctx, `DELETE FROM portfolio_invites WHERE id = $1 AND portfolio_id = $2`, id, portfolioID)
+    if err != nil {
+        return err
+    }
+    if tag.RowsAffected() == 0 {
+        return ErrInviteNotFound
+    }
+    return nil
+}
+
+// AcceptPortfolioInvite turns an invitation addressed to email into a
+// membership for userID. Accepting again after being removed restores the
+// invited access.
+func AcceptPortfolioInvite(ctx context.Context, id, userID int, email string) (*models.PortfolioMember, error) {
+    tx, err := Pool.Begin(ctx)
+    if err != nil {
+        return nil, fmt.Errorf("begin transaction: %v", err)
+    }
+    defer tx.Rollback(ctx)
+
+    m := &models.PortfolioMember{UserID: userID, Email: email}
+    var ownerID int
+    err = tx.QueryRow(ctx, `
+        DELETE FROM portfolio_invites i
+        USING portfolios p
+        WHERE i.id = $1 AND i.email = $2 AND p.id = i.portfolio_id
+        RETURNING i.portfolio_id, i.access, i.invited_by, p.user_id`, id, email,
+    ).Scan(&m.PortfolioID, &m.Access, &m.InvitedBy, &ownerID)
+    if err == pgx.ErrNoRows {
+        return nil, ErrInviteNotFound
+    }
+    if err != nil {
+        return nil, fmt.Errorf("failed to claim invitation: %v", err)
+    }
+    if ownerID == userID {
+        return nil, ErrMemberExists
+    }
+
+    err = tx.QueryRow(ctx, `
+        INSERT INTO portfolio_members (portfolio_id, user_id, access, invited_by)
+        VALUES ($1, $2, $3, $4)
+        ON CONFLICT (portfolio_id, user_id) DO UPDATE SET access = EXCLUDED.access
+        RETURNING TO_CHAR(created_at, 'YYYY-MM-DD HH24:MI:SS')`,
+        m.PortfolioID, m.UserID, m.Access, m.InvitedBy,
+    ).Scan(&m.CreatedAt)
+    if err != nil {
+        return nil, fmt.Errorf("failed to add portfolio member: %w", err)
+    }
+
+    return m, tx.Commit(ctx)
+}
+
+// LogActivity records that userID made a change to a portfolio; details is
+// stored as JSON
+func LogActivity(ctx context.Context, portfolioID, userID int, action string, details interface{}) error {
+    data, err := json.Marshal(details)
+    if err != nil {
+        return err
+    }
+    _, err = Pool.Exec(ctx, `
+        INSERT INTO portfolio_activity (portfolio_id, user_id, action, details)
+        VALUES ($1, $2, $3, $4)`, portfolioID, userID, action, data)
+    if err != nil {
+        return fmt.Errorf("failed to log activity: %v", err)
+    }
+    return nil
+}
+
+func GetPortfolioActivity(ctx context.Context, portfolioID int) ([]models.PortfolioActivity, error) {
+    rows, err := Pool.Query(ctx, `
+        SELECT a.id, a.portfolio_id, a.user_id, COALESCE(u.email, ''), a.action, a.details,
+            TO_CHAR(a.created_at, 'YYYY-MM-DD HH24:MI:SS')
+        FROM portfolio_activity a
+        LEFT JOIN users u ON u.id = a.user_id
+        WHERE a.portfolio_id = $1
+        ORDER BY a.created_at DESC, a.id DESC`, portfolioID)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    entries := make([]models.PortfolioActivity, 0)
+    for rows.Next() {
+        var e models.PortfolioActivity
+        var details []byte
+        if err := rows.Scan(&e.ID, &e.PortfolioID, &e.UserID, &e.UserEmail, &e.Action, &details,
+            &e.CreatedAt); err != nil {
+            return nil, fmt.Errorf("scan error: %v", err)
+        }
+        e.Details = details
+        entries = append(entries, e)
+    }
+    return entries, rows.Err()
+}
\ No newline at end of file
@@ -0,0 +1,9 @@
+-- Applied automatically at startup when missing (see db.createTables)
+-- Emails are compared case-insensitively from now on. Accounts whose emails
+-- differ only in case must be merged by hand first, or the index fails.
+UPDATE users SET email = lower(trim(email));
+CREATE UNIQUE INDEX users_email_lower_key ON users (lower(email));
+
+ALTER TABLE users ADD COLUMN email_verified_at TIMESTAMPTZ;
+-- Accounts created before verification existed are trusted as they are
+UPDATE users SET email_verified_at = created_at;
\ No newline at end of file
@@ -0,0 +1,7 @@
+-- Applied automatically at startup when missing (see db.createTables)
+-- Roles are admin, user and viewer (read-only). Set auth.admin_emails to
+-- promote the first administrators at startup.
+ALTER TABLE users ADD COLUMN role VARCHAR(16) NOT NULL DEFAULT 'user';
+ALTER TABLE users ADD COLUMN disabled_at TIMESTAMPTZ;
+-- Sessions issued before this instant are rejected
+ALTER TABLE users ADD COLUMN sessions_revoked_at TIMESTAMPTZ;
\ No newline at end of file
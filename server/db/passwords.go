@@ -0,0 +1,107 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var ErrResetTokenInvalid = errors.New("reset token invalid or expired")
+
+// UpdatePassword stores a new hash and revokes any reset links still pending,
+// every session issued so far and every API key
+func UpdatePassword(ctx context.Context, userID int, password []byte) error {
+    tx, err := Pool.Begin(ctx)
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback(ctx)
+
+    if err := setPassword(ctx, tx, userID, password); err != nil {
+        return err
+    }
+    return tx.Commit(ctx)
+}
+
+// ReplacePasswordHash swaps in an upgraded hash of the same password. It only
+// applies while the stored hash is still old, so a password changed in the
+// meantime is never overwritten.
+func ReplacePasswordHash(ctx context.Context, userID int, old, new []byte) error {
+    _, err := Pool.Exec(ctx, `
+        UPDATE users SET password = $3
+        WHERE id = $1 AND password = $2`, userID, old, new)
+    return err
+}
+
+func CreatePasswordReset(ctx context.Context, userID int, tokenHash []byte, expiresAt time.Time) error {
+    _, err := Pool.Exec(ctx, `
+        INSERT INTO password_resets (user_id, token_hash, expires_at)
+        VALUES ($1, $2, $3)`, userID, tokenHash, expiresAt)
+    return err
+}
+
+// PasswordResetUser returns the owner of a reset token that can still be used
+func PasswordResetUser(ctx context.Context, tokenHash []byte) (int, error) {
+    var userID int
+    err := Pool.QueryRow(ctx, `
+        SELECT user_id FROM password_resets
+        WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW()`, tokenHash).Scan(&userID)
+    if err == pgx.ErrNoRows {
+        return 0, ErrResetTokenInvalid
+    }
+    return userID, err
+}
+
+// ResetPassword spends the reset token and sets the password of its owner,
+// which also ends their sessions.
+// Claiming the token and checking it in one UPDATE makes it single-use even
+// under concurrent requests.
+func ResetPassword(ctx context.Context, tokenHash []byte, password []byte) (userID int, err error) {
+    tx, err := Pool.Begin(ctx)
+    if err != nil {
+        return 0, err
+    }
+    defer tx.Rollback(ctx)
+
+    err = tx.QueryRow(ctx, `
+        UPDATE password_resets SET used_at = NOW()
+        WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW()
+        RETURNING user_id`, tokenHash).Scan(&userID)
+    if err == pgx.ErrNoRows {
+        return 0, ErrResetTokenInvalid
+    }
+    if err != nil {
+        return 0, err
+    }
+
+    if err := setPassword(ctx, tx, userID, password); err != nil {
+        return 0, err
+    }
+    return userID, tx.Commit(ctx)
+}
+
+// Sessions are revoked as of now, truncated to the millisecond precision of
+// token issue times so a session issued right after is still accepted. API
+// keys are deleted, since whoever knew the old password could have made one.
+func setPassword(ctx context.Context, tx pgx.Tx, userID int, password []byte) error {
+    tag, err := tx.Exec(ctx, `
+        UPDATE users SET password = $2, sessions_revoked_at = $3, updated_at = NOW()
+        WHERE id = $1`, userID, password, time.Now().Truncate(time.Millisecond))
+    if err != nil {
+        return err
+    }
+    if tag.RowsAffected() == 0 {
+        return ErrUserNotFound
+    }
+
+    if _, err := tx.Exec(ctx, `DELETE FROM api_keys WHERE user_id = $1`, userID); err != nil {
+        return err
+    }
+
+    _, err = tx.Exec(ctx, `
+        UPDATE password_resets SET used_at = NOW()
+        WHERE user_id = $1 AND used_at IS NULL`, userID)
+    return err
+}
\ No newline at end of file
@@ -0,0 +1,114 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"server/models"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const DefaultPortfolioName = "Main"
+
+var (
+    ErrPortfolioNotFound  = errors.New("portfolio not found")
+    ErrDuplicatePortfolio = errors.New("portfolio already exists")
+)
+
+func GetDefaultPortfolio(ctx context.Context, userID int) (*models.Portfolio, error) {
+    p := &models.Portfolio{}
+    err := Pool.QueryRow(ctx, `
+        INSERT INTO portfolios (user_id, name)
+        VALUES ($1, $2)
+        ON CONFLICT (user_id, name) DO UPDATE SET name = EXCLUDED.name
+        RETURNING id, user_id, name, currency, TO_CHAR(created_at, 'YYYY-MM-DD HH24:MI:SS')`,
+        userID, DefaultPortfolioName,
+    ).Scan(&p.ID, &p.UserID, &p.Name, &p.Currency, &p.CreatedAt)
+    p.Access = models.AccessOwner
+    if err != nil {
+        return nil, fmt.Errorf("failed to get default portfolio: %v", err)
+    }
+    return p, nil
+}
+
+func GetPortfolio(ctx context.Context, id int) (*models.Portfolio, error) {
+    p := &models.Portfolio{}
+    err := Pool.QueryRow(ctx, `
+        SELECT id, user_id, name, currency, TO_CHAR(created_at, 'YYYY-MM-DD HH24:MI:SS')
+        FROM portfolios
+        WHERE id = $1`, id,
+    ).Scan(&p.ID, &p.UserID, &p.Name, &p.Currency, &p.CreatedAt)
+    if err == pgx.ErrNoRows {
+        return nil, ErrPortfolioNotFound
+    }
+    if err != nil {
+        return nil, fmt.Errorf("failed to get portfolio: %v", err)
+    }
+    return p, nil
+}
+
+// GetPortfolioAccess returns the portfolio with the user's access level set,
+// or ErrPortfolioNotFound if it is neither theirs nor shared with them.
+func GetPortfolioAccess(ctx context.Context, id, userID int) (*models.Portfolio, error) {
+    p := &models.Portfolio{}
+    err := Pool.QueryRow(ctx, `
+        SELECT p.id, p.user_id, p.name, p.currency, TO_CHAR(p.created_at, 'YYYY-MM-DD HH24:MI:SS'),
+            CASE WHEN p.user_id = $2 THEN 'owner' ELSE m.access END
+        FROM portfolios p
+        LEFT JOIN portfolio_members m ON m.portfolio_id = p.id AND m.user_id = $2
+        WHERE p.id = $1 AND (p.user_id = $2 OR m.user_id IS NOT NULL)`, id, userID,
+    ).Scan(&p.ID, &p.UserID, &p.Name, &p.Currency, &p.CreatedAt, &p.Access)
+    if err == pgx.ErrNoRows {
+        return nil, ErrPortfolioNotFound
+    }
+    if err != nil {
+        return nil, fmt.Errorf("failed to get portfolio: %v", err)
+    }
+    return p, nil
+}
+
+// GetPortfolios lists the user's own portfolios followed by those shared with them
+func GetPortfolios(ctx context.Context, userID int) ([]models.Portfolio, error) {
+    rows, err := Pool.Query(ctx, `
+        SELECT p.id, p.user_id, p.name, p.currency, TO_CHAR(p.created_at, 'YYYY-MM-DD HH24:MI:SS'),
+            CASE WHEN p.user_id = $1 THEN 'owner' ELSE m.access END
+        FROM portfolios p
+        LEFT JOIN portfolio_members m ON m.portfolio_id = p.id AND m.user_id = $1
+        WHERE p.user_id = $1 OR m.user_id IS NOT NULL
+        ORDER BY p.user_id <> $1, p.id`, userID)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    portfolios := make([]models.Portfolio, 0)
+    for rows.Next() {
+        var p models.Portfolio
+        if err := rows.Scan(&p.ID, &p.UserID, &p.Name, &p.Currency, &p.CreatedAt, &p.Access); err != nil {
+            return nil, fmt.Errorf("scan error: %v", err)
+        }
+        portfolios = append(portfolios, p)
+    }
+
+    return portfolios, rows.Err()
+}
+
+func CreatePortfolio(ctx context.Context, p *models.Portfolio) error {
+    err := Pool.QueryRow(ctx, `
+        INSERT INTO portfolios (user_id, name, currency)
+        VALUES ($1, $2, $3)
+        RETURNING id, TO_CHAR(created_at, 'YYYY-MM-DD HH24:MI:SS')`,
+        p.UserID, p.Name, p.Currency,
+    ).Scan(&p.ID, &p.CreatedAt)
+    if err != nil {
+        if strings.Contains(err.Error(), "unique constraint") {
+            return ErrDuplicatePortfolio
+        }
+        return fmt.Errorf("failed to create portfolio: %w", err)
+    }
+    p.Access = models.AccessOwner
+    return nil
+}
\ No newline at end of file
@@ -0,0 +1,88 @@
+package db
+
+import (
+	"context"
+	"errors"
+
+	"server/models"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var ErrShareLinkNotFound = errors.New("share link not found")
+
+const shareLinkColumns = `id, portfolio_id, created_by, prefix, hide_amounts, hide_cost_basis, expires_at, last_used_at, created_at`
+
+func scanShareLink(row pgx.Row) (*models.ShareLink, error) {
+    l := &models.ShareLink{}
+    err := row.Scan(&l.ID, &l.PortfolioID, &l.CreatedBy, &l.Prefix, &l.HideAmounts, &l.HideCostBasis,
+        &l.ExpiresAt, &l.LastUsedAt, &l.CreatedAt)
+    if err == pgx.ErrNoRows {
+        return nil, ErrShareLinkNotFound
+    }
+    return l, err
+}
+
+func CreateShareLink(ctx context.Context, l *models.ShareLink, tokenHash []byte) error {
+    return Pool.QueryRow(ctx, `
+        INSERT INTO share_links (portfolio_id, created_by, prefix, token_hash, hide_amounts, hide_cost_basis, expires_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7)
+        RETURNING id, created_at`,
+        l.PortfolioID, l.CreatedBy, l.Prefix, tokenHash, l.HideAmounts, l.HideCostBasis, l.ExpiresAt,
+    ).Scan(&l.ID, &l.CreatedAt)
+}
+
+func GetShareLinks(ctx context.Context, portfolioID int) ([]models.ShareLink, error) {
+    rows, err := Pool.Query(ctx, `
+        SELECT `+shareLinkColumns+`
+        FROM share_links
+        WHERE portfolio_id = $1
+        ORDER BY id`, portfolioID)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    links := make([]models.ShareLink, 0)
+    for rows.Next() {
+        l, err := scanShareLink(rows)
+        if err != nil {
+            return nil, err
+        }
+        links = append(links, *l)
+    }
+    return links, rows.Err()
+}
+
+func CountShareLinks(ctx context.Context, portfolioID int) (int, error) {
+    var n int
+    err := Pool.QueryRow(ctx, `SELECT count(*) FROM share_links WHERE portfolio_id = $1`, portfolioID).Scan(&n)
+    return n, err
+}
+
+func GetShareLinkByHash(ctx context.Context, tokenHash []byte) (*models.ShareLink, error) {
+    return scanShareLink(Pool.QueryRow(ctx, `
+        SELECT `+shareLinkColumns+`
+        FROM share_links
+        WHERE token_hash = $1`, tokenHash))
+}
+
+// DeleteShareLink revokes a link of the given portfolio
+func DeleteShareLink(ctx context.Context, portfolioID, id int) error {
+    tag, err := Pool.Exec(ctx, `DELETE FROM share_links WHERE id = $1 AND portfolio_id = $2`, id, portfolioID)
+    if err != nil {
+        return err
+    }
+    if tag.RowsAffected() == 0 {
+        return ErrShareLinkNotFound
+    }
+    return nil
+}
+
+// TouchShareLink records a view, at most once a minute like TouchAPIKey
+func TouchShareLink(ctx context.Context, id int) error {
+    _, err := Pool.Exec(ctx, `
+        UPDATE share_links SET last_used_at = NOW()
+        WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute')`, id)
+    return err
+}
\ No newline at end of file
@@ -0,0 +1,147 @@
+package db
+
+import (
+	"context"
+	"errors"
+
+	"server/models"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+    ErrTOTPNotFound        = errors.New("two-factor authentication not set up")
+    ErrTOTPAlreadyEnabled  = errors.New("two-factor authentication already enabled")
+    ErrTOTPCodeReused      = errors.New("code already used")
+    ErrRecoveryCodeInvalid = errors.New("recovery code invalid or used")
+)
+
+func GetTOTP(ctx context.Context, userID int) (*models.TOTP, error) {
+    t := &models.TOTP{}
+    err := Pool.QueryRow(ctx, `
+        SELECT user_id, secret, enabled_at, last_step
+        FROM user_totp
+        WHERE user_id = $1`, userID,
+    ).Scan(&t.UserID, &t.Secret, &t.EnabledAt, &t.LastStep)
+    if err == pgx.ErrNoRows {
+        return nil, ErrTOTPNotFound
+    }
+    if err != nil {
+        return nil, err
+    }
+    return t, nil
+}
+
+// SaveTOTPSecret starts or restarts enrollment. It refuses to replace the
+// secret once 2FA is enabled.
+func SaveTOTPSecret(ctx context.Context, userID int, secret string) error {
+    tag, err := Pool.Exec(ctx, `
+        INSERT INTO user_totp (user_id, secret)
+        VALUES ($1, $2)
+        ON CONFLICT (user_id) DO UPDATE
+        SET secret = EXCLUDED.secret, last_step = 0, created_at = NOW()
+        WHERE user_totp.enabled_at IS NULL`, userID, secret)
+    if err != nil {
+        return err
+    }
+    if tag.RowsAffected() == 0 {
+        return ErrTOTPAlreadyEnabled
+    }
+    return nil
+}
+
+// EnableTOTP finishes enrollment and replaces any recovery codes, keyed by
+// their lookup ID
+func EnableTOTP(ctx context.Context, userID int, step int64, recoveryHashes map[string]string) error {
+    tx, err := Pool.Begin(ctx)
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback(ctx)
+
+    tag, err := tx.Exec(ctx, `
+        UPDATE user_totp SET enabled_at = NOW(), last_step = $2
+        WHERE user_id = $1 AND enabled_at IS NULL`, userID, step)
+    if err != nil {
+        return err
+    }
+    if tag.RowsAffected() == 0 {
+        return ErrTOTPAlreadyEnabled
+    }
+
+    if _, err := tx.Exec(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID); err != nil {
+        return err
+    }
+    for id, hash := range recoveryHashes {
+        if _, err := tx.Exec(ctx, `
+            INSERT INTO recovery_codes (user_id, code_id, code_hash)
+            VALUES ($1, $2, $3)`, userID, id, hash); err != nil {
+            return err
+        }
+    }
+    return tx.Commit(ctx)
+}
+
+func DisableTOTP(ctx context.Context, userID int) error {
+    tx, err := Pool.Begin(ctx)
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback(ctx)
+
+    if _, err := tx.Exec(ctx, `DELETE FROM user_totp WHERE user_id = $1`, userID); err != nil {
+        return err
+    }
+    if _, err := tx.Exec(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID); err != nil {
+        return err
+    }
+    return tx.Commit(ctx)
+}
+
+// UseTOTPStep records step as used. Only a later step than the last one
+// succeeds, so each code works once even across concurrent logins.
+func UseTOTPStep(ctx context.Context, userID int, step int64) error {
+    tag, err := Pool.Exec(ctx, `
+        UPDATE user_totp SET last_step = $2
+        WHERE user_id = $1 AND last_step < $2`, userID, step)
+    if err != nil {
+        return err
+    }
+    if tag.RowsAffected() == 0 {
+        return ErrTOTPCodeReused
+    }
+    return nil
+}
+
+// GetRecoveryCode returns the row ID and hash of an unused recovery code
+func GetRecoveryCode(ctx context.Context, userID int, codeID string) (id int, hash string, err error) {
+    err = Pool.QueryRow(ctx, `
+        SELECT id, code_hash FROM recovery_codes
+        WHERE user_id = $1 AND code_id = $2 AND used_at IS NULL`, userID, codeID,
+    ).Scan(&id, &hash)
+    if err == pgx.ErrNoRows {
+        return 0, "", ErrRecoveryCodeInvalid
+    }
+    return id, hash, err
+}
+
+func UseRecoveryCode(ctx context.Context, id int) error {
+    tag, err := Pool.Exec(ctx, `
+        UPDATE recovery_codes SET used_at = NOW()
+        WHERE id = $1 AND used_at IS NULL`, id)
+    if err != nil {
+        return err
+    }
+    if tag.RowsAffected() == 0 {
+        return ErrRecoveryCodeInvalid
+    }
+    return nil
+}
+
+func CountRecoveryCodes(ctx context.Context, userID int) (int, error) {
+    var n int
+    err := Pool.QueryRow(ctx, `
+        SELECT count(*) FROM recovery_codes
+        WHERE user_id = $1 AND used_at IS NULL`, userID).Scan(&n)
+    return n, err
+}
\ No newline at end of file
@@ -0,0 +1,339 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"server/models"
+	"server/utils"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const transactionColumns = `
+    id, portfolio_id, symbol, side, quantity, price, currency, commission,
+    commission_currency, TO_CHAR(time, 'YYYY-MM-DD HH24:MI:SS'), source, COALESCE(external_id, '')`
+
+// Returns how many were new; the rest were imported before
+func saveTransactions(ctx context.Context, tx pgx.Tx, portfolioID int, txs []models.Transaction) (int, error) {
+    batch := &pgx.Batch{}
+    for _, t := range txs {
+        batch.Queue(`
+            INSERT INTO transactions
+                (portfolio_id, symbol, side, quantity, price, currency, commission,
+                 commission_currency, time, source, external_id)
+            VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+            ON CONFLICT (portfolio_id, external_id) DO NOTHING`,
+            portfolioID, t.Symbol, t.Side, t.Quantity, t.Price, t.Currency, t.Commission,
+            t.CommissionCurrency, t.Time, t.Source, t.ExternalID)
+    }
+
+    n, err := execBatch(ctx, tx, batch)
+    if err != nil {
+        return 0, fmt.Errorf("failed to save transactions: %v", err)
+    }
+    return n, nil
+}
+
+func GetTransactions(ctx context.Context, portfolioID int) ([]models.Transaction, error) {
+    rows, err := Pool.Query(ctx, `
+        SELECT `+transactionColumns+`
+        FROM transactions
+        WHERE portfolio_id = $1
+        ORDER BY time, id`, portfolioID)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    return scanTransactions(rows)
+}
+
+func scanTransactions(rows pgx.Rows) ([]models.Transaction, error) {
+    txs := make([]models.Transaction, 0)
+    for rows.Next() {
+        var t models.Transaction
+        if err := rows.Scan(&t.ID, &t.PortfolioID, &t.Symbol, &t.Side, &t.Quantity, &t.Price,
+            &t.Currency, &t.Commission, &t.CommissionCurrency, &t.Time, &t.Source,
+            &t.ExternalID); err != nil {
+            return nil, fmt.Errorf("scan error: %v", err)
+        }
+        txs = append(txs, t)
+    }
+
+    return txs, rows.Err()
+}
+
+var (
+    ErrTransactionNotFound = errors.New("transaction not found")
+    ErrInsufficientShares  = errors.New("sell exceeds shares held")
+)
+
+const (
+    AuditCreate = "create"
+    AuditUpdate = "update"
+    AuditDelete = "delete"
+)
+
+func GetTransaction(ctx context.Context, id int) (*models.Transaction, error) {
+    rows, err := Pool.Query(ctx, `
+        SELECT `+transactionColumns+`
+        FROM transactions
+        WHERE id = $1`, id)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    txs, err := scanTransactions(rows)
+    if err != nil {
+        return nil, err
+    }
+    if len(txs) == 0 {
+        return nil, ErrTransactionNotFound
+    }
+    return &txs[0], nil
+}
+
+func CreateTransaction(ctx context.Context, userID int, t *models.Transaction) error {
+    tx, err := Pool.Begin(ctx)
+    if err != nil {
+        return fmt.Errorf("begin transaction: %v", err)
+    }
+    defer tx.Rollback(ctx)
+
+    err = tx.QueryRow(ctx, `
+        INSERT INTO transactions
+            (portfolio_id, symbol, side, quantity, price, currency, commission,
+             commission_currency, time, source)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+        RETURNING id`,
+        t.PortfolioID, t.Symbol, t.Side, t.Quantity, t.Price, t.Currency, t.Commission,
+        t.CommissionCurrency, t.Time, t.Source,
+    ).Scan(&t.ID)
+    if err != nil {
+        return fmt.Errorf("failed to create transaction: %v", err)
+    }
+
+    if err := writeAudit(ctx, tx, userID, AuditCreate, t.PortfolioID, t.ID, nil, t); err != nil {
+        return err
+    }
+    if err := saveTradeCash(ctx, tx, t); err != nil {
+        return err
+    }
+    if err := recomputePosition(ctx, tx, t.PortfolioID, t.Symbol, true); err != nil {
+        return err
+    }
+
+    return tx.Commit(ctx)
+}
+
+func UpdateTransaction(ctx context.Context, userID int, before, after *models.Transaction) error {
+    tx, err := Pool.Begin(ctx)
+    if err != nil {
+        return fmt.Errorf("begin transaction: %v", err)
+    }
+    defer tx.Rollback(ctx)
+
+    _, err = tx.Exec(ctx, `
+        UPDATE transactions
+        SET symbol = $2, side = $3, quantity = $4, price = $5, currency = $6,
+            commission = $7, commission_currency = $8, time = $9
+        WHERE id = $1`,
+        after.ID, after.Symbol, after.Side, after.Quantity, after.Price, after.Currency,
+        after.Commission, after.CommissionCurrency, after.Time)
+    if err != nil {
+        return fmt.Errorf("failed to update transaction: %v", err)
+    }
+
+    if err := writeAudit(ctx, tx, userID, AuditUpdate, after.PortfolioID, after.ID, before, after); err != nil {
+        return err
+    }
+    if err := saveTradeCash(ctx, tx, after); err != nil {
+        return err
+    }
+    if err := recomputePosition(ctx, tx, after.PortfolioID, after.Symbol, true); err != nil {
+        return err
+    }
+    if before.Symbol != after.Symbol {
+        if err := recomputePosition(ctx, tx, before.PortfolioID, before.Symbol, true); err != nil {
+            return err
+        }
+    }
+
+    return tx.Commit(ctx)
+}
+
+func DeleteTransaction(ctx context.Context, userID int, t *models.Transaction) error {
+    tx, err := Pool.Begin(ctx)
+    if err != nil {
+        return fmt.Errorf("begin transaction: %v", err)
+    }
+    defer tx.Rollback(ctx)
+
+    // Its cash operations go with it
+    if _, err := tx.Exec(ctx, `DELETE FROM transactions WHERE id = $1`, t.ID); err != nil {
+        return fmt.Errorf("failed to delete transaction: %v", err)
+    }
+
+    if err := writeAudit(ctx, tx, userID, AuditDelete, t.PortfolioID, t.ID, t, nil); err != nil {
+        return err
+    }
+    if err := recomputePosition(ctx, tx, t.PortfolioID, t.Symbol, true); err != nil {
+        return err
+    }
+
+    return tx.Commit(ctx)
+}
+
+func RecomputePositions(ctx context.Context, portfolioID int, symbols []string) error {
+    tx, err := Pool.Begin(ctx)
+    if err != nil {
+        return fmt.Errorf("begin transaction: %v", err)
+    }
+    defer tx.Rollback(ctx)
+
+    for _, symbol := range symbols {
+        if err := recomputePosition(ctx, tx, portfolioID, symbol, false); err != nil {
+            return fmt.Errorf("%s: %w", symbol, err)
+        }
+    }
+
+    return tx.Commit(ctx)
+}
+
+// Rebuilds the stocks row for symbol from its trades, splits and spin-offs.
+// Broker statements may start after the original purchase, so when strict is
+// false overselling just closes the position instead of failing.
+func recomputePosition(ctx context.Context, tx pgx.Tx, portfolioID int, symbol string, strict bool) error {
+    shares, average, last, err := replayPosition(ctx, tx, portfolioID, symbol, nil, strict)
+    if err != nil {
+        return err
+    }
+
+    if shares == 0 {
+        _, err := tx.Exec(ctx, `DELETE FROM stocks WHERE portfolio_id = $1 AND ticker = $2`,
+            portfolioID, symbol)
+        return err
+    }
+
+    _, err = tx.Exec(ctx, `
+        INSERT INTO stocks (portfolio_id, ticker, date, price, shares, currency)
+        VALUES ($1, $2, $3, $4, $5, $6)
+        ON CONFLICT (portfolio_id, ticker) DO UPDATE
+        SET price = EXCLUDED.price, shares = EXCLUDED.shares, date = EXCLUDED.date`,
+        portfolioID, symbol, last, average, shares, utils.CurrencyForSymbol(symbol))
+    if err != nil {
+        return fmt.Errorf("failed to update position: %v", err)
+    }
+    return nil
+}
+
+// Replays the history of symbol in date order, stopping before the given
+// date when it is set. Buys move the average price, sells only reduce the
+// share count.
+func replayPosition(ctx context.Context, tx pgx.Tx, portfolioID int, symbol string, before *string,
+    strict bool) (shares, average float64, last time.Time, err error) {
+
+    rows, err := tx.Query(ctx, `
+        SELECT time, 0 AS kind, side, quantity, price, 0 AS ratio, 0 AS cost_fraction
+        FROM transactions
+        WHERE portfolio_id = $1 AND symbol = $2 AND ($5::date IS NULL OR time < $5::date)
+        UNION ALL
+        SELECT ex_date::timestamptz, 1 AS kind, type, 0, 0, ratio, cost_fraction
+        FROM corporate_actions
+        WHERE portfolio_id = $1 AND symbol = $2 AND type IN ($3, $4) AND ($5::date IS NULL OR ex_date < $5::date)
+        ORDER BY 1, 2`,
+        portfolioID, symbol, models.ActionSplit, models.ActionSpinOff, before)
+    if err != nil {
+        return 0, 0, last, fmt.Errorf("failed to load position history: %v", err)
+    }
+    defer rows.Close()
+
+    for rows.Next() {
+        var when time.Time
+        var kind int
+        var side string
+        var quantity, price, ratio, costFraction float64
+        if err := rows.Scan(&when, &kind, &side, &quantity, &price, &ratio, &costFraction); err != nil {
+            return 0, 0, last, fmt.Errorf("scan error: %v", err)
+        }
+        last = when
+
+        switch side {
+        case models.SideBuy:
+            average = (average*shares + price*quantity) / (shares + quantity)
+            shares += quantity
+        case models.SideSell:
+            shares -= quantity
+            if strict && shares < -1e-6 {
+                return 0, 0, last, ErrInsufficientShares
+            }
+            if shares <= 1e-6 {
+                shares, average = 0, 0
+            }
+        case models.ActionSplit:
+            shares *= ratio
+            average /= ratio
+        case models.ActionSpinOff:
+            average *= 1 - costFraction
+        }
+    }
+    if err := rows.Err(); err != nil {
+        return 0, 0, last, err
+    }
+    return shares, average, last, nil
+}
+
+func writeAudit(ctx context.Context, tx pgx.Tx, userID int, action string, portfolioID, transactionID int,
+    before, after *models.Transaction) error {
+
+    var beforeData, afterData []byte
+    if before != nil {
+        beforeData, _ = json.Marshal(before)
+    }
+    if after != nil {
+        afterData, _ = json.Marshal(after)
+    }
+
+    _, err := tx.Exec(ctx, `
+        INSERT INTO transaction_audit
+            (transaction_id, portfolio_id, user_id, action, before_data, after_data)
+        VALUES ($1, $2, $3, $4, $5, $6)`,
+        transactionID, portfolioID, userID, action, beforeData, afterData)
+    if err != nil {
+        return fmt.Errorf("failed to write audit entry: %v", err)
+    }
+    return nil
+}
+
+func GetTransactionAudit(ctx context.Context, portfolioID int) ([]models.TransactionAudit, error) {
+    rows, err := Pool.Query(ctx, `
+        SELECT a.id, a.transaction_id, a.user_id, COALESCE(u.email, ''), a.action, a.before_data, a.after_data,
+            TO_CHAR(a.created_at, 'YYYY-MM-DD HH24:MI:SS')
+        FROM transaction_audit a
+        LEFT JOIN users u ON u.id = a.user_id
+        WHERE a.portfolio_id = $1
+        ORDER BY a.created_at DESC, a.id DESC`, portfolioID)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    entries := make([]models.TransactionAudit, 0)
+    for rows.Next() {
+        var e models.TransactionAudit
+        var before, after []byte
+        if err := rows.Scan(&e.ID, &e.TransactionID, &e.UserID, &e.UserEmail, &e.Action, &before, &after,
+            &e.CreatedAt); err != nil {
+            return nil, fmt.Errorf("scan error: %v", err)
+        }
+        e.Before, e.After = before, after
+        entries = append(entries, e)
+    }
+
+    return entries, rows.Err()
+}
\ No newline at end of file
@@ -0,0 +1,111 @@
+package db
+
+import (
+	"context"
+	"errors"
+
+	"server/models"
+)
+
+var ErrLastAdmin = errors.New("cannot remove the last active administrator")
+
+func ListUsers(ctx context.Context) ([]models.User, error) {
+    rows, err := Pool.Query(ctx, `
+        SELECT `+userColumns+`
+        FROM users
+        ORDER BY id`)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    users := make([]models.User, 0)
+    for rows.Next() {
+        u, err := scanUser(rows)
+        if err != nil {
+            return nil, err
+        }
+        users = append(users, *u)
+    }
+    return users, rows.Err()
+}
+
+// SetUserRole changes a role, refusing to demote the last active admin
+func SetUserRole(ctx context.Context, id int, role string) error {
+    return updateUser(ctx, id, role != models.RoleAdmin, `
+        UPDATE users SET role = $2, updated_at = NOW()
+        WHERE id = $1`, id, role)
+}
+
+// SetUserDisabled blocks or unblocks logins and every existing session and
+// API key of the user
+func SetUserDisabled(ctx context.Context, id int, disabled bool) error {
+    if disabled {
+        return updateUser(ctx, id, true, `
+            UPDATE users SET disabled_at = NOW(), updated_at = NOW()
+            WHERE id = $1 AND disabled_at IS NULL`, id)
+    }
+    return updateUser(ctx, id, false, `
+        UPDATE users SET disabled_at = NULL, updated_at = NOW()
+        WHERE id = $1`, id)
+}
+
+// RevokeSessions invalidates every session token issued to the user so far
+func RevokeSessions(ctx context.Context, id int) error {
+    return updateUser(ctx, id, false, `
+        UPDATE users SET sessions_revoked_at = NOW()
+        WHERE id = $1`, id)
+}
+
+// PromoteAdmins gives the admin role to existing accounts with these emails
+func PromoteAdmins(ctx context.Context, emails []string) (int64, error) {
+    if len(emails) == 0 {
+        return 0, nil
+    }
+    tag, err := Pool.Exec(ctx, `
+        UPDATE users SET role = 'admin', updated_at = NOW()
+        WHERE lower(email) = ANY($1) AND role <> 'admin'`, emails)
+    return tag.RowsAffected(), err
+}
+
+// Runs query on user id. When removesAdmin is set, the change is rolled back
+// if it leaves no active administrator.
+func updateUser(ctx context.Context, id int, removesAdmin bool, query string, args ...interface{}) error {
+    tx, err := Pool.Begin(ctx)
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback(ctx)
+
+    // Serialises admin changes so two admins cannot demote each other at once
+    if removesAdmin {
+        if _, err := tx.Exec(ctx, `LOCK TABLE users IN SHARE ROW EXCLUSIVE MODE`); err != nil {
+            return err
+        }
+    }
+
+    var exists bool
+    if err := tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM users WHERE id = $1)`, id).Scan(&exists); err != nil {
+        return err
+    }
+    if !exists {
+        return ErrUserNotFound
+    }
+
+    if _, err := tx.Exec(ctx, query, args...); err != nil {
+        return err
+    }
+
+    if removesAdmin {
+        var admins int
+        if err := tx.QueryRow(ctx, `
+            SELECT count(*) FROM users
+            WHERE role = 'admin' AND disabled_at IS NULL`).Scan(&admins); err != nil {
+            return err
+        }
+        if admins == 0 {
+            return ErrLastAdmin
+        }
+    }
+    return tx.Commit(ctx)
+}
\ No newline at end of file
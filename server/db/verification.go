@@ -0,0 +1,51 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var ErrVerificationTokenInvalid = errors.New("verification token invalid or expired")
+
+func CreateEmailVerification(ctx context.Context, userID int, tokenHash []byte, expiresAt time.Time) error {
+    _, err := Pool.Exec(ctx, `
+        INSERT INTO email_verifications (user_id, token_hash, expires_at)
+        VALUES ($1, $2, $3)`, userID, tokenHash, expiresAt)
+    return err
+}
+
+// VerifyEmail spends the token and marks the email of its owner verified.
+// Other links sent to the same user stop working too.
+func VerifyEmail(ctx context.Context, tokenHash []byte) (userID int, err error) {
+    tx, err := Pool.Begin(ctx)
+    if err != nil {
+        return 0, err
+    }
+    defer tx.Rollback(ctx)
+
+    err = tx.QueryRow(ctx, `
+        UPDATE email_verifications SET used_at = NOW()
+        WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW()
+        RETURNING user_id`, tokenHash).Scan(&userID)
+    if err == pgx.ErrNoRows {
+        return 0, ErrVerificationTokenInvalid
+    }
+    if err != nil {
+        return 0, err
+    }
+
+    if _, err := tx.Exec(ctx, `
+        UPDATE users SET email_verified_at = NOW(), updated_at = NOW()
+        WHERE id = $1 AND email_verified_at IS NULL`, userID); err != nil {
+        return 0, err
+    }
+    if _, err := tx.Exec(ctx, `
+        UPDATE email_verifications SET used_at = NOW()
+        WHERE user_id = $1 AND used_at IS NULL`, userID); err != nil {
+        return 0, err
+    }
+    return userID, tx.Commit(ctx)
+}
\ No newline at end of file
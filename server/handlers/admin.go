@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"server/apierr"
+	"server/db"
+	"server/models"
+	"server/reqctx"
+)
+
+// The user named by the {id} path parameter, who must not be the caller
+func adminTarget(w http.ResponseWriter, r *http.Request) (int, bool) {
+    id, err := strconv.Atoi(r.PathValue("id"))
+    if err != nil {
+        writeError(w, r, apierr.BadRequest("invalid_id", "User id must be a number"))
+        return 0, false
+    }
+    if id == currentUserID(r) {
+        writeError(w, r, apierr.New(http.StatusUnprocessableEntity, "cannot_modify_self",
+            "Administrators cannot change their own account here"))
+        return 0, false
+    }
+    return id, true
+}
+
+func HandleAdminListUsers(w http.ResponseWriter, r *http.Request) {
+    users, err := db.ListUsers(r.Context())
+    if err != nil {
+        writeError(w, r, fmt.Errorf("listing users: %w", err))
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(users)
+}
+
+func HandleAdminSetRole(w http.ResponseWriter, r *http.Request) {
+    id, ok := adminTarget(w, r)
+    if !ok {
+        return
+    }
+
+    var req models.RoleChange
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        writeError(w, r, invalidBody())
+        return
+    }
+    if !models.ValidRole(req.Role) {
+        writeError(w, r, apierr.Validation(apierr.FieldError{Field: "role", Message: "role must be admin, user or viewer"}))
+        return
+    }
+
+    if err := db.SetUserRole(r.Context(), id, req.Role); err != nil {
+        writeError(w, r, err)
+        return
+    }
+    reqctx.Logger(r.Context()).Info("User role changed", "target_user_id", id, "role", req.Role)
+    w.WriteHeader(http.StatusNoContent)
+}
+
+func HandleAdminDisableUser(w http.ResponseWriter, r *http.Request) {
+    setDisabled(w, r, true)
+}
+
+func HandleAdminEnableUser(w http.ResponseWriter, r *http.Request) {
+    setDisabled(w, r, false)
+}
+
+func setDisabled(w http.ResponseWriter, r *http.Request, disabled bool) {
+    id, ok := adminTarget(w, r)
+    if !ok {
+        return
+    }
+
+    if err := db.SetUserDisabled(r.Context(), id, disabled); err != nil {
+        writeError(w, r, err)
+        return
+    }
+    reqctx.Logger(r.Context()).Info("User disabled state changed", "target_user_id", id, "disabled", disabled)
+    w.WriteHeader(http.StatusNoContent)
+}
+
+// Logs the user out everywhere. API keys stay valid; disable the account
+// to stop those too.
+func HandleAdminRevokeSessions(w http.ResponseWriter, r *http.Request) {
+    id, ok := adminTarget(w, r)
+    if !ok {
+        return
+    }
+
+    if err := db.RevokeSessions(r.Context(), id); err != nil {
+        writeError(w, r, err)
+        return
+    }
+    reqctx.Logger(r.Context()).Info("User sessions revoked", "target_user_id", id)
+    w.WriteHeader(http.StatusNoContent)
+}
+
+// Most recent first; ?userId= narrows to one user, ?limit= caps the count
+func HandleAdminListImports(w http.ResponseWriter, r *http.Request) {
+    userID := 0
+    if v := r.URL.Query().Get("userId"); v != "" {
+        id, err := strconv.Atoi(v)
+        if err != nil {
+            writeError(w, r, apierr.BadRequest("invalid_user_id", "userId must be a number"))
+            return
+        }
+        userID = id
+    }
+
+    limit, err := importLimit(r)
+    if err != nil {
+        writeError(w, r, err)
+        return
+    }
+
+    imports, err := db.GetImports(r.Context(), userID, limit)
+    if err != nil {
+        writeError(w, r, fmt.Errorf("listing imports: %w", err))
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(imports)
+}
\ No newline at end of file
@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"server/apierr"
+	"server/auth"
+	"server/db"
+	"server/models"
+	"server/reqctx"
+)
+
+const (
+    maxAPIKeysPerUser = 25
+    apiKeyPrefix      = "stk_"
+)
+
+func HandleListAPIKeys(w http.ResponseWriter, r *http.Request) {
+    keys, err := db.GetAPIKeys(r.Context(), currentUserID(r))
+    if err != nil {
+        writeError(w, r, fmt.Errorf("retrieving API keys: %w", err))
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(keys)
+}
+
+// Creates a key and returns it in full; only its hash is stored, so it
+// cannot be shown again
+func HandleCreateAPIKey(w http.ResponseWriter, r *http.Request) {
+    userID := currentUserID(r)
+
+    var k models.APIKey
+    if err := json.NewDecoder(r.Body).Decode(&k); err != nil {
+        writeError(w, r, invalidBody())
+        return
+    }
+    if err := k.Validate(time.Now()); err != nil {
+        writeError(w, r, err)
+        return
+    }
+
+    count, err := db.CountAPIKeys(r.Context(), userID)
+    if err != nil {
+        writeError(w, r, fmt.Errorf("counting API keys: %w", err))
+        return
+    }
+    if count >= maxAPIKeysPerUser {
+        writeError(w, r, apierr.Conflict("too_many_api_keys",
+            fmt.Sprintf("At most %d API keys are allowed; revoke one first", maxAPIKeysPerUser)))
+        return
+    }
+
+    token, _, err := auth.NewToken()
+    if err != nil {
+        writeError(w, r, fmt.Errorf("generating API key: %w", err))
+        return
+    }
+    key := apiKeyPrefix + token
+    k.UserID = userID
+    k.Prefix = key[:len(apiKeyPrefix)+6]
+
+    if err := db.CreateAPIKey(r.Context(), &k, auth.HashToken(key)); err != nil {
+        writeError(w, r, fmt.Errorf("storing API key: %w", err))
+        return
+    }
+    reqctx.Logger(r.Context()).Info("API key created", "api_key_id", k.ID, "scopes", k.Scopes)
+
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(http.StatusCreated)
+    json.NewEncoder(w).Encode(models.NewAPIKey{APIKey: k, Key: key})
+}
+
+func HandleDeleteAPIKey(w http.ResponseWriter, r *http.Request) {
+    id, err := strconv.Atoi(r.PathValue("id"))
+    if err != nil {
+        writeError(w, r, apierr.BadRequest("invalid_id", "API key id must be a number"))
+        return
+    }
+
+    if err := db.DeleteAPIKey(r.Context(), currentUserID(r), id); err != nil {
+        writeError(w, r, err)
+        return
+    }
+    reqctx.Logger(r.Context()).Info("API key revoked", "api_key_id", id)
+    w.WriteHeader(http.StatusNoContent)
+}
\ No newline at end of file
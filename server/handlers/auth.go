@@ -2,48 +2,60 @@ package handlers
 
 import (
 	"encoding/json"
-	"log"
+	"errors"
+	"fmt"
 	"net/http"
+	"strings"
+	"sync"
 
+	"server/apierr"
 	"server/auth"
 	"server/db"
+	"server/metrics"
 	"server/models"
+	"server/reqctx"
 )
 
 func HandleRegister(w http.ResponseWriter, r *http.Request) {
+    if !allow(w, r, "register:ip:"+clientIP(r), registerPerIP) {
+        return
+    }
+
     var creds models.Credentials
     if err := json.NewDecoder(r.Body).Decode(&creds); err != nil {
-        http.Error(w, "Invalid request body", http.StatusBadRequest)
+        writeError(w, r, invalidBody())
         return
     }
 
     if creds.Email == "" || creds.Password == "" {
-        http.Error(w, "Email and password required", http.StatusBadRequest)
+        writeError(w, r, apierr.BadRequest("credentials_required", "Email and password required"))
         return
     }
-
-    hashedPassword, err := auth.GenerateHash(creds.Password, auth.DefaultParams)
+    email, err := models.NormalizeEmail(creds.Email)
     if err != nil {
-        log.Printf("Error hashing password: %v", err)
-        http.Error(w, "Server error", http.StatusInternalServerError)
+        writeError(w, r, err)
+        return
+    }
+    if err := checkPassword("password", creds.Password, email); err != nil {
+        writeError(w, r, err)
+        return
+    }
+
+    hashedPassword, ok := hashPassword(w, r, creds.Password)
+    if !ok {
         return
     }
 
     user := models.User{
-        Email:    creds.Email,
-        Password: []byte(hashedPassword),
+        Email:    email,
+        Password: hashedPassword,
     }
 
     if err := db.CreateUser(r.Context(), &user); err != nil {
-        log.Printf("Error creating user: %v", err)
-        switch err {
-        case db.ErrDuplicateEmail:
-            http.Error(w, "Email already exists", http.StatusConflict)
-        default:
-            http.Error(w, "Failed to create user", http.StatusInternalServerError)
-        }
+        writeError(w, r, err)
         return
     }
+    sendVerificationEmail(r, &user)
 
     w.WriteHeader(http.StatusCreated)
     json.NewEncoder(w).Encode(map[string]string{
@@ -52,31 +64,168 @@ func HandleRegister(w http.ResponseWriter, r *http.Request) {
 }
 
 func HandleLogin(w http.ResponseWriter, r *http.Request) {
+    if !allow(w, r, "login:ip:"+clientIP(r), loginPerIP) {
+        return
+    }
+
     var creds models.Credentials
     if err := json.NewDecoder(r.Body).Decode(&creds); err != nil {
-        http.Error(w, "Invalid request body", http.StatusBadRequest)
+        writeError(w, r, invalidBody())
         return
     }
 
-    user, err := db.GetUserByEmail(r.Context(), creds.Email)
-    if err != nil {
-        http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+    // Keyed by the submitted email whether or not the account exists, so
+    // lockouts do not reveal which emails are registered
+    account := "login:account:" + strings.ToLower(strings.TrimSpace(creds.Email))
+    if !allow(w, r, account, loginPerAccount) {
+        return
+    }
+    if lockedFor, err := limiter.LockedFor(r.Context(), account); err == nil && lockedFor > 0 {
+        metrics.LoginAttempts.WithLabelValues("locked").Inc()
+        writeTooManyRequests(w, r, lockedFor, "account_locked", "Too many failed logins, try again later")
         return
     }
 
+    user, lookupErr := db.GetUserByEmail(r.Context(), creds.Email)
+    // Anything but a missing user is our failure, not the caller's, and must
+    // not count towards a lockout
+    if lookupErr != nil && !errors.Is(lookupErr, db.ErrUserNotFound) {
+        writeError(w, r, fmt.Errorf("looking up user: %w", lookupErr))
+        return
+    }
+
+    release, ok := acquireHashSlot(w, r)
+    if !ok {
+        return
+    }
+    // Unknown emails are compared against a dummy hash, so they take as long
+    // as a wrong password and response times do not reveal registered emails
+    if lookupErr != nil {
+        auth.ComparePasswordAndHash(creds.Password, unknownUserHash())
+        release()
+        loginFailed(w, r, account)
+        return
+    }
     match, err := auth.ComparePasswordAndHash(creds.Password, string(user.Password))
     if err != nil || !match {
-        http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+        release()
+        loginFailed(w, r, account)
+        return
+    }
+    // Reuses the slot, since the hash just compared costs the same again
+    upgradeHash(r, user, creds.Password)
+    release()
+
+    if !accountUsable(w, r, user) {
+        return
+    }
+
+    totp, err := db.GetTOTP(r.Context(), user.ID)
+    if err != nil && !errors.Is(err, db.ErrTOTPNotFound) {
+        writeError(w, r, fmt.Errorf("loading 2FA settings: %w", err))
+        return
+    }
+    // Failures are only cleared once the second factor is verified too, so
+    // knowing the password does not allow endless guessing of codes
+    if totp != nil && totp.EnabledAt != nil {
+        challenge, err := auth.GenerateChallengeToken(user.ID)
+        if err != nil {
+            writeError(w, r, fmt.Errorf("generating challenge: %w", err))
+            return
+        }
+        json.NewEncoder(w).Encode(map[string]interface{}{
+            "twoFactorRequired": true,
+            "challenge":         challenge,
+        })
         return
     }
 
+    if err := limiter.Reset(r.Context(), account); err != nil {
+        reqctx.Logger(r.Context()).Warn("Failed to reset login failures", "error", err)
+    }
+    issueSession(w, r, user)
+}
+
+func issueSession(w http.ResponseWriter, r *http.Request, user *models.User) {
     token, err := auth.GenerateToken(user.ID)
     if err != nil {
-        http.Error(w, "Server error", http.StatusInternalServerError)
+        writeError(w, r, fmt.Errorf("generating token: %w", err))
         return
     }
 
-    json.NewEncoder(w).Encode(map[string]string{
-        "token": token,
+    metrics.LoginAttempts.WithLabelValues("success").Inc()
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "token":         token,
+        "emailVerified": user.EmailVerifiedAt != nil,
     })
+}
+
+func loginFailed(w http.ResponseWriter, r *http.Request, account string) {
+    metrics.LoginAttempts.WithLabelValues("failure").Inc()
+
+    lockedFor, err := limiter.Fail(r.Context(), account, lockoutPolicy())
+    if err != nil {
+        reqctx.Logger(r.Context()).Warn("Failed to record login failure", "error", err)
+    }
+    if lockedFor > 0 {
+        reqctx.Logger(r.Context()).Warn("Account locked after failed logins", "lockout", lockedFor)
+        writeTooManyRequests(w, r, lockedFor, "account_locked", "Too many failed logins, try again later")
+        return
+    }
+    writeError(w, r, apierr.Unauthorized("invalid_credentials", "Invalid credentials"))
+}
+
+var dummyHash struct {
+    sync.Mutex
+    hash string
+}
+
+// Returns a hash of a random password made with the current parameters and
+// pepper, for logins to emails that have no account. The caller must hold a
+// hashing slot, as the first call makes the hash.
+func unknownUserHash() string {
+    dummyHash.Lock()
+    defer dummyHash.Unlock()
+    if dummyHash.hash == "" || auth.NeedsRehash(dummyHash.hash, auth.DefaultParams) {
+        password, _, err := auth.NewToken()
+        if err == nil {
+            dummyHash.hash, err = auth.GenerateHash(password, auth.DefaultParams)
+        }
+        if err != nil {
+            return ""
+        }
+    }
+    return dummyHash.hash
+}
+
+// Rehashes the password of a user who just logged in if the stored hash
+// predates the current Argon2 parameters or pepper. Failures are logged and
+// retried at the next login.
+func upgradeHash(r *http.Request, user *models.User, password string) {
+    if !auth.NeedsRehash(string(user.Password), auth.DefaultParams) {
+        return
+    }
+    hash, err := auth.GenerateHash(password, auth.DefaultParams)
+    if err == nil {
+        err = db.ReplacePasswordHash(r.Context(), user.ID, user.Password, []byte(hash))
+    }
+    if err != nil {
+        reqctx.Logger(r.Context()).Warn("Failed to upgrade password hash", "user_id", user.ID, "error", err)
+        return
+    }
+    reqctx.Logger(r.Context()).Info("Upgraded password hash", "user_id", user.ID)
+}
+
+// Checks made once the user has proven who they are, so the answer does not
+// reveal anything about accounts to others
+func accountUsable(w http.ResponseWriter, r *http.Request, user *models.User) bool {
+    if user.DisabledAt != nil {
+        writeError(w, r, apierr.New(http.StatusForbidden, "account_disabled", "This account has been disabled"))
+        return false
+    }
+    if settings.Auth.UnverifiedAccess == "deny" && user.EmailVerifiedAt == nil {
+        writeError(w, r, errEmailUnverified)
+        return false
+    }
+    return true
 }
\ No newline at end of file
@@ -0,0 +1,156 @@
+package handlers_test
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"server/models"
+)
+
+func TestCashOperationSign(t *testing.T) {
+    h, _ := setup(t)
+    token := verifiedUser(t, h, "cash").Token
+
+    tests := []struct {
+        kind   string
+        amount float64
+        status int
+    }{
+        {models.CashDeposit, 1000, http.StatusCreated},
+        {models.CashDeposit, -1000, http.StatusUnprocessableEntity},
+        {models.CashWithdrawal, -200, http.StatusCreated},
+        {models.CashWithdrawal, 200, http.StatusUnprocessableEntity},
+        {models.CashCommission, -5, http.StatusCreated},
+        {models.CashCommission, 5, http.StatusUnprocessableEntity},
+        {models.CashTax, -19, http.StatusCreated},
+        {models.CashTax, 19, http.StatusUnprocessableEntity},
+        {models.CashOther, -1, http.StatusCreated},
+    }
+    for _, tt := range tests {
+        op := map[string]any{"type": tt.kind, "amount": tt.amount, "currency": "PLN"}
+        rec := call(t, h, "POST", "/cash", token, op)
+        if rec.Code != tt.status {
+            t.Errorf("%s of %v: %d %s, want %d", tt.kind, tt.amount, rec.Code, rec.Body, tt.status)
+        }
+        if tt.status == http.StatusUnprocessableEntity {
+            wantError(t, rec, tt.status, "validation_failed")
+        }
+    }
+
+    rec := call(t, h, "GET", "/cash", token, nil)
+    if rec.Code != http.StatusOK {
+        t.Fatalf("cash: %d %s", rec.Code, rec.Body)
+    }
+    var resp struct {
+        Balances []models.CashBalance `json:"balances"`
+    }
+    decode(t, rec, &resp)
+    if len(resp.Balances) != 1 || resp.Balances[0].Balance != 1000-200-5-19-1 {
+        t.Errorf("balances = %+v, want 775 PLN", resp.Balances)
+    }
+}
+
+func TestCashOperationFields(t *testing.T) {
+    h, _ := setup(t)
+    token := verifiedUser(t, h, "cashfields").Token
+
+    tomorrow := time.Now().AddDate(0, 0, 1).Format("2006-01-02")
+    tests := []struct {
+        name  string
+        op    map[string]any
+        field string
+    }{
+        {"date only", map[string]any{"type": "deposit", "amount": 100, "time": "2024-03-01"}, ""},
+        {"date and time", map[string]any{"type": "deposit", "amount": 100, "time": "2024-03-01 10:30:00"}, ""},
+        {"future date", map[string]any{"type": "deposit", "amount": 100, "time": tomorrow}, "time"},
+        {"not a date", map[string]any{"type": "deposit", "amount": 100, "time": "March"}, "time"},
+        {"lower case currency", map[string]any{"type": "deposit", "amount": 100, "currency": "usd"}, ""},
+        {"long currency", map[string]any{"type": "deposit", "amount": 100, "currency": "USDX"}, "currency"},
+        {"currency with digits", map[string]any{"type": "deposit", "amount": 100, "currency": "US1"}, "currency"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            rec := call(t, h, "POST", "/cash", token, tt.op)
+            if tt.field == "" {
+                if rec.Code != http.StatusCreated {
+                    t.Fatalf("got %d %s, want 201", rec.Code, rec.Body)
+                }
+                return
+            }
+            wantError(t, rec, http.StatusUnprocessableEntity, "validation_failed")
+            if !strings.Contains(rec.Body.String(), `"field":"`+tt.field+`"`) {
+                t.Errorf("no %s field error in %s", tt.field, rec.Body)
+            }
+        })
+    }
+}
+
+func TestManualEntriesMoveCash(t *testing.T) {
+    h, _ := setup(t)
+    token := verifiedUser(t, h, "ledger").Token
+
+    balances := func(step string, want map[string]float64) {
+        t.Helper()
+        rec := call(t, h, "GET", "/cash", token, nil)
+        if rec.Code != http.StatusOK {
+            t.Fatalf("%s: cash: %d %s", step, rec.Code, rec.Body)
+        }
+        var resp struct {
+            Balances []models.CashBalance `json:"balances"`
+        }
+        decode(t, rec, &resp)
+        got := map[string]float64{}
+        for _, b := range resp.Balances {
+            got[b.Currency] = b.Balance
+        }
+        if len(got) != len(want) {
+            t.Errorf("%s: balances = %v, want %v", step, got, want)
+            return
+        }
+        for currency, balance := range want {
+            if got[currency] != balance {
+                t.Errorf("%s: balances = %v, want %v", step, got, want)
+                return
+            }
+        }
+    }
+
+    buy := map[string]any{"symbol": "AAPL.US", "side": "buy", "quantity": 10, "price": 100, "currency": "USD",
+        "commission": 5, "commissionCurrency": "PLN", "time": "2024-01-10"}
+    rec := call(t, h, "POST", "/transactions", token, buy)
+    if rec.Code != http.StatusCreated {
+        t.Fatalf("buy: %d %s", rec.Code, rec.Body)
+    }
+    var bought models.Transaction
+    decode(t, rec, &bought)
+    balances("buy", map[string]float64{"USD": -1000, "PLN": -5})
+
+    buy["quantity"] = 4
+    if rec := call(t, h, "PUT", fmt.Sprintf("/transactions/%d", bought.ID), token, buy); rec.Code != http.StatusOK {
+        t.Fatalf("edit: %d %s", rec.Code, rec.Body)
+    }
+    balances("edited buy", map[string]float64{"USD": -400, "PLN": -5})
+
+    sell := map[string]any{"symbol": "AAPL.US", "side": "sell", "quantity": 2, "price": 150, "currency": "USD", "time": "2024-02-10"}
+    rec = call(t, h, "POST", "/transactions", token, sell)
+    if rec.Code != http.StatusCreated {
+        t.Fatalf("sell: %d %s", rec.Code, rec.Body)
+    }
+    var sold models.Transaction
+    decode(t, rec, &sold)
+    balances("sell", map[string]float64{"USD": -100, "PLN": -5})
+
+    dividend := map[string]any{"symbol": "AAPL.US", "type": "dividend", "exDate": "2024-03-01", "total": 8, "currency": "USD"}
+    if rec := call(t, h, "POST", "/corporate-actions", token, dividend); rec.Code != http.StatusCreated {
+        t.Fatalf("dividend: %d %s", rec.Code, rec.Body)
+    }
+    balances("dividend", map[string]float64{"USD": -92, "PLN": -5})
+
+    if rec := call(t, h, "DELETE", fmt.Sprintf("/transactions/%d", sold.ID), token, nil); rec.Code != http.StatusNoContent {
+        t.Fatalf("delete: %d %s", rec.Code, rec.Body)
+    }
+    balances("deleted sell", map[string]float64{"USD": -392, "PLN": -5})
+}
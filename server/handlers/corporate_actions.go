@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"server/apierr"
+	"server/db"
+	"server/models"
+	"server/utils"
+)
+
+func HandleListCorporateActions(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "application/json")
+
+    portfolio, err := resolvePortfolio(r)
+    if err != nil {
+        writeError(w, r, err)
+        return
+    }
+
+    actions, err := db.GetCorporateActions(r.Context(), portfolio.ID, r.URL.Query().Get("symbol"))
+    if err != nil {
+        writeError(w, r, fmt.Errorf("retrieving corporate actions: %w", err))
+        return
+    }
+    json.NewEncoder(w).Encode(actions)
+}
+
+func HandleCreateCorporateAction(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "application/json")
+
+    portfolio, err := resolvePortfolio(r)
+    if err != nil {
+        writeError(w, r, err)
+        return
+    }
+
+    var action models.CorporateAction
+    if err := json.NewDecoder(r.Body).Decode(&action); err != nil {
+        writeError(w, r, invalidBody())
+        return
+    }
+
+    if err := action.Validate(); err != nil {
+        writeError(w, r, err)
+        return
+    }
+    action.PortfolioID = portfolio.ID
+    action.Source = models.SourceManual
+    action.ExternalID = ""
+
+    if err := db.ApplyCorporateAction(r.Context(), &action); err != nil {
+        writeError(w, r, fmt.Errorf("applying corporate action: %w", err))
+        return
+    }
+    logActivity(r, portfolio.ID, models.ActivityCorporateActionCreated, action)
+
+    w.WriteHeader(http.StatusCreated)
+    json.NewEncoder(w).Encode(action)
+}
+
+func HandleDividendIncome(w http.ResponseWriter, r *http.Request) {
+    year := time.Now().Year()
+    if y := r.URL.Query().Get("year"); y != "" {
+        parsed, err := strconv.Atoi(y)
+        if err != nil {
+            writeError(w, r, apierr.Validation(apierr.FieldError{Field: "year", Message: "year must be a number"}))
+            return
+        }
+        year = parsed
+    }
+
+    portfolio, err := resolvePortfolio(r)
+    if err != nil {
+        writeError(w, r, err)
+        return
+    }
+
+    income, err := db.GetDividendIncome(r.Context(), portfolio.ID, year)
+    if err != nil {
+        writeError(w, r, fmt.Errorf("retrieving dividend income: %w", err))
+        return
+    }
+
+    var total float64
+    for _, d := range income {
+        total += d.Total
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "year":      year,
+        "dividends": income,
+        "total":     utils.RoundToTwo(total),
+    })
+}
\ No newline at end of file
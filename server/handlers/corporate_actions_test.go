@@ -0,0 +1,44 @@
+package handlers_test
+
+import (
+	"math"
+	"net/http"
+	"testing"
+
+	"server/models"
+)
+
+func TestSplitBackdatedBeforeBuy(t *testing.T) {
+    h, _ := setup(t)
+    token := verifiedUser(t, h, "split").Token
+
+    trades := []map[string]any{
+        {"symbol": "AAPL.US", "side": "buy", "quantity": 10, "price": 100, "currency": "USD", "time": "2024-01-10"},
+        {"symbol": "AAPL.US", "side": "buy", "quantity": 5, "price": 30, "currency": "USD", "time": "2024-03-10"},
+    }
+    for _, trade := range trades {
+        if rec := call(t, h, "POST", "/transactions", token, trade); rec.Code != http.StatusCreated {
+            t.Fatalf("transaction: %d %s", rec.Code, rec.Body)
+        }
+    }
+
+    // Only the January buy was held on the ex-date
+    split := map[string]any{"symbol": "AAPL.US", "type": "split", "exDate": "2024-02-01", "ratio": 4, "currency": "USD"}
+    if rec := call(t, h, "POST", "/corporate-actions", token, split); rec.Code != http.StatusCreated {
+        t.Fatalf("split: %d %s", rec.Code, rec.Body)
+    }
+
+    rec := call(t, h, "GET", "/stocks", token, nil)
+    if rec.Code != http.StatusOK {
+        t.Fatalf("stocks: %d %s", rec.Code, rec.Body)
+    }
+    var stocks []models.Stock
+    decode(t, rec, &stocks)
+    if len(stocks) != 1 {
+        t.Fatalf("stocks = %+v, want one position", stocks)
+    }
+    got := stocks[0]
+    if got.Shares != 45 || math.Abs(got.Price-(1000+150)/45.0) > 1e-9 {
+        t.Errorf("position = %v shares at %v, want 45 at %v", got.Shares, got.Price, (1000+150)/45.0)
+    }
+}
@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"server/apierr"
+	"server/db"
+	"server/models"
+	"server/reqctx"
+)
+
+// Sentinel errors from the db layer and the problem each one is reported as
+var knownErrors = []struct {
+    err    error
+    status int
+    code   string
+    detail string
+}{
+    {db.ErrDuplicateEmail, http.StatusConflict, "email_taken", "Email already exists"},
+    {db.ErrPortfolioNotFound, http.StatusNotFound, "portfolio_not_found", "Portfolio not found"},
+    {db.ErrDuplicatePortfolio, http.StatusConflict, "portfolio_exists", "Portfolio already exists"},
+    {db.ErrPositionNotFound, http.StatusNotFound, "position_not_found", "No position held in symbol"},
+    {db.ErrTransactionNotFound, http.StatusNotFound, "transaction_not_found", "Transaction not found"},
+    {db.ErrResetTokenInvalid, http.StatusBadRequest, "invalid_reset_token", "Reset link is invalid or has expired"},
+    {db.ErrVerificationTokenInvalid, http.StatusBadRequest, "invalid_verification_token", "Verification link is invalid or has expired"},
+    {db.ErrTOTPNotFound, http.StatusNotFound, "totp_not_found", "Two-factor authentication is not set up"},
+    {db.ErrTOTPAlreadyEnabled, http.StatusConflict, "totp_already_enabled", "Two-factor authentication is already enabled"},
+    {db.ErrAPIKeyNotFound, http.StatusNotFound, "api_key_not_found", "API key not found"},
+    {db.ErrUserNotFound, http.StatusNotFound, "user_not_found", "User not found"},
+    {db.ErrMemberNotFound, http.StatusNotFound, "member_not_found", "This user is not a member of the portfolio"},
+    {db.ErrInviteNotFound, http.StatusNotFound, "invite_not_found", "Invitation not found"},
+    {db.ErrMemberExists, http.StatusConflict, "member_exists", "The portfolio is already shared with this user"},
+    {db.ErrShareLinkNotFound, http.StatusNotFound, "share_link_not_found", "This link is invalid, expired or has been revoked"},
+    {db.ErrOIDCStateInvalid, http.StatusBadRequest, "oidc_state_invalid", "The sign-in attempt expired or was already used, please start again"},
+    {db.ErrIdentityNotFound, http.StatusNotFound, "identity_not_found", "Identity not found"},
+    {db.ErrIdentityLinked, http.StatusConflict, "identity_linked", "This identity is already linked to an account"},
+    {db.ErrImportNotFound, http.StatusNotFound, "import_not_found", "Import not found"},
+    {db.ErrImportNotPreviewed, http.StatusConflict, "import_not_previewed", "Only a preview that has not expired can be confirmed"},
+    {db.ErrLastAdmin, http.StatusConflict, "last_admin", "At least one active administrator must remain"},
+    {db.ErrInsufficientShares, http.StatusUnprocessableEntity, "insufficient_shares", "Sell quantity exceeds shares held"},
+}
+
+// Writes err as an application/problem+json response. Unrecognised errors
+// are logged and reported as a generic internal error.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+    var apiErr *apierr.Error
+    var fieldErr *models.FieldError
+
+    switch {
+    case errors.As(err, &apiErr):
+    case errors.As(err, &fieldErr):
+        apiErr = apierr.Validation(apierr.FieldError{Field: fieldErr.Field, Message: fieldErr.Message})
+    default:
+        for _, k := range knownErrors {
+            if errors.Is(err, k.err) {
+                apiErr = apierr.New(k.status, k.code, k.detail)
+                break
+            }
+        }
+        if apiErr == nil {
+            apiErr = apierr.Internal(err)
+        }
+    }
+
+    if apiErr.Status >= http.StatusInternalServerError {
+        reqctx.Logger(r.Context()).Error("Request failed", "code", apiErr.Code, "error", apiErr)
+    }
+    apierr.Write(w, r, apiErr)
+}
+
+func invalidBody() error {
+    return apierr.BadRequest("invalid_body", "Invalid request body")
+}
+
+func invalidStatement(err error) error {
+    return &apierr.Error{
+        Status: http.StatusUnprocessableEntity,
+        Code:   "invalid_statement",
+        Detail: "The file is not a valid broker statement",
+        Err:    err,
+    }
+}
@@ -0,0 +1,396 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"server/apierr"
+	"server/db"
+	"server/models"
+	"server/reqctx"
+	"server/utils"
+
+	"github.com/xuri/excelize/v2"
+)
+
+type exportFilter struct {
+    from string
+    to   string
+}
+
+func parseExportFilter(r *http.Request) (exportFilter, error) {
+    var filter exportFilter
+    for _, p := range []struct {
+        name string
+        dst  *string
+    }{{"from", &filter.from}, {"to", &filter.to}} {
+        value := r.URL.Query().Get(p.name)
+        if value == "" {
+            continue
+        }
+        if _, err := time.Parse("2006-01-02", value); err != nil {
+            return filter, apierr.Validation(apierr.FieldError{Field: p.name, Message: p.name + " must be in YYYY-MM-DD format"})
+        }
+        *p.dst = value
+    }
+    if filter.from != "" && filter.to != "" && filter.from > filter.to {
+        return filter, apierr.Validation(apierr.FieldError{Field: "from", Message: "from must not be after to"})
+    }
+    return filter, nil
+}
+
+// Timestamps are "YYYY-MM-DD HH:MM:SS", so comparing the date prefix is enough
+func (f exportFilter) includes(timestamp string) bool {
+    if len(timestamp) < 10 {
+        return false
+    }
+    day := timestamp[:10]
+    return (f.from == "" || day >= f.from) && (f.to == "" || day <= f.to)
+}
+
+type historyEntry struct {
+    date     string
+    kind     string
+    opType   string
+    symbol   string
+    currency string
+    amount   float64
+    details  string
+}
+
+type exportData struct {
+    portfolio    *models.Portfolio
+    stocks       []models.Stock
+    prices       map[string]float64
+    transactions []models.Transaction
+    history      []historyEntry
+}
+
+func loadExportData(r *http.Request, portfolio *models.Portfolio, filter exportFilter, withPrices bool) (*exportData, error) {
+    ctx := r.Context()
+    data := &exportData{portfolio: portfolio, prices: make(map[string]float64)}
+
+    stocks, err := db.GetStocks(ctx, portfolio.ID)
+    if err != nil {
+        return nil, fmt.Errorf("failed to retrieve stocks: %v", err)
+    }
+    data.stocks = stocks
+
+    if withPrices {
+        for _, s := range stocks {
+            price, err := fetchQuote(ctx, utils.StooqSymbol(s.Symbol))
+            if err != nil {
+                reqctx.Logger(ctx).Warn("Failed to fetch price", "symbol", s.Symbol, "error", err)
+                continue
+            }
+            data.prices[s.Symbol] = price
+        }
+    }
+
+    txs, err := db.GetTransactions(ctx, portfolio.ID)
+    if err != nil {
+        return nil, fmt.Errorf("failed to retrieve transactions: %v", err)
+    }
+    for _, t := range txs {
+        if filter.includes(t.Time) {
+            data.transactions = append(data.transactions, t)
+        }
+    }
+
+    ops, err := db.GetCashOperations(ctx, portfolio.ID)
+    if err != nil {
+        return nil, fmt.Errorf("failed to retrieve cash operations: %v", err)
+    }
+    for _, op := range ops {
+        if !filter.includes(op.Time) {
+            continue
+        }
+        data.history = append(data.history, historyEntry{
+            date:     op.Time,
+            kind:     "cash",
+            opType:   op.Type,
+            symbol:   op.Symbol,
+            currency: op.Currency,
+            amount:   op.Amount,
+            details:  op.Comment,
+        })
+    }
+
+    actions, err := db.GetCorporateActions(ctx, portfolio.ID, "")
+    if err != nil {
+        return nil, fmt.Errorf("failed to retrieve corporate actions: %v", err)
+    }
+    for _, a := range actions {
+        if !filter.includes(a.ExDate) {
+            continue
+        }
+        details := ""
+        switch a.Type {
+        case models.ActionSplit:
+            details = fmt.Sprintf("ratio %g", a.Ratio)
+        case models.ActionRename:
+            details = "renamed to " + a.NewSymbol
+        case models.ActionSpinOff:
+            details = fmt.Sprintf("%g %s per share", a.Ratio, a.NewSymbol)
+        }
+        data.history = append(data.history, historyEntry{
+            date:     a.ExDate,
+            kind:     "corporate action",
+            opType:   a.Type,
+            symbol:   a.Symbol,
+            currency: a.Currency,
+            amount:   a.Total,
+            details:  details,
+        })
+    }
+
+    sort.SliceStable(data.history, func(i, j int) bool {
+        return data.history[i].date > data.history[j].date
+    })
+
+    return data, nil
+}
+
+func HandleExport(w http.ResponseWriter, r *http.Request) {
+    format := r.URL.Query().Get("format")
+    if format == "" {
+        format = "xlsx"
+    }
+
+    dataset := r.URL.Query().Get("dataset")
+    if dataset == "" {
+        dataset = "holdings"
+    }
+
+    if format != "xlsx" && format != "csv" {
+        writeError(w, r, apierr.Validation(apierr.FieldError{Field: "format", Message: "format must be xlsx or csv"}))
+        return
+    }
+    if format == "csv" && dataset != "holdings" && dataset != "transactions" && dataset != "history" {
+        writeError(w, r, apierr.Validation(apierr.FieldError{Field: "dataset", Message: "dataset must be holdings, transactions or history"}))
+        return
+    }
+
+    filter, err := parseExportFilter(r)
+    if err != nil {
+        writeError(w, r, err)
+        return
+    }
+
+    portfolio, err := resolvePortfolio(r)
+    if err != nil {
+        writeError(w, r, err)
+        return
+    }
+
+    withPrices := format == "xlsx" || dataset == "holdings"
+    data, err := loadExportData(r, portfolio, filter, withPrices)
+    if err != nil {
+        writeError(w, r, fmt.Errorf("loading export data: %w", err))
+        return
+    }
+
+    filename := exportFilename(portfolio, filter)
+
+    if format == "csv" {
+        w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+        w.Header().Set("Content-Disposition",
+            fmt.Sprintf(`attachment; filename="%s-%s.csv"`, filename, dataset))
+        if err := writeExportCSV(w, data, dataset); err != nil {
+            reqctx.Logger(r.Context()).Error("Failed to write CSV export", "error", err)
+        }
+        return
+    }
+
+    f, err := buildExportWorkbook(data)
+    if err != nil {
+        writeError(w, r, fmt.Errorf("building export workbook: %w", err))
+        return
+    }
+    defer f.Close()
+
+    w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+    w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.xlsx"`, filename))
+    if err := f.Write(w); err != nil {
+        reqctx.Logger(r.Context()).Error("Failed to write export workbook", "error", err)
+    }
+}
+
+func exportFilename(p *models.Portfolio, filter exportFilter) string {
+    name := strings.Map(func(r rune) rune {
+        if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' {
+            return r
+        }
+        return '-'
+    }, p.Name)
+
+    filename := "portfolio-" + name
+    if filter.from != "" {
+        filename += "-from-" + filter.from
+    }
+    if filter.to != "" {
+        filename += "-to-" + filter.to
+    }
+    return filename
+}
+
+var holdingsHeader = []string{
+    "Symbol", "Currency", "Shares", "Average price", "Current price", "Cost", "Value", "P&L", "P&L %",
+}
+
+var transactionsHeader = []string{
+    "Date", "Symbol", "Side", "Quantity", "Price", "Currency", "Commission", "Commission currency", "Source",
+}
+
+var historyHeader = []string{"Date", "Kind", "Type", "Symbol", "Currency", "Amount", "Details"}
+
+func transactionRow(t models.Transaction) []interface{} {
+    return []interface{}{
+        t.Time, t.Symbol, t.Side, t.Quantity, t.Price, t.Currency, t.Commission,
+        t.CommissionCurrency, t.Source,
+    }
+}
+
+func historyRow(h historyEntry) []interface{} {
+    return []interface{}{h.date, h.kind, h.opType, h.symbol, h.currency, h.amount, h.details}
+}
+
+func writeExportCSV(w http.ResponseWriter, data *exportData, dataset string) error {
+    cw := csv.NewWriter(w)
+
+    switch dataset {
+    case "holdings":
+        cw.Write(holdingsHeader)
+        for _, s := range data.stocks {
+            cost := s.Shares * s.Price
+            row := []interface{}{s.Symbol, s.Currency, s.Shares, s.Price, "", utils.RoundToTwo(cost), "", "", ""}
+            if price, ok := data.prices[s.Symbol]; ok {
+                value := s.Shares * price
+                row[4] = price
+                row[6] = utils.RoundToTwo(value)
+                row[7] = utils.RoundToTwo(value - cost)
+                if cost != 0 {
+                    row[8] = utils.RoundToTwo((value - cost) / cost * 100)
+                }
+            }
+            cw.Write(toStrings(row))
+        }
+
+    case "transactions":
+        cw.Write(transactionsHeader)
+        for _, t := range data.transactions {
+            cw.Write(toStrings(transactionRow(t)))
+        }
+
+    case "history":
+        cw.Write(historyHeader)
+        for _, h := range data.history {
+            cw.Write(toStrings(historyRow(h)))
+        }
+    }
+
+    cw.Flush()
+    return cw.Error()
+}
+
+func buildExportWorkbook(data *exportData) (*excelize.File, error) {
+    f := excelize.NewFile()
+
+    holdings := "Holdings"
+    if err := f.SetSheetName("Sheet1", holdings); err != nil {
+        return nil, err
+    }
+    if err := writeSheet(f, holdings, holdingsHeader, 0, nil); err != nil {
+        return nil, err
+    }
+
+    money, err := f.NewStyle(&excelize.Style{NumFmt: 4}) // #,##0.00
+    if err != nil {
+        return nil, err
+    }
+    percent, err := f.NewStyle(&excelize.Style{NumFmt: 10}) // 0.00%
+    if err != nil {
+        return nil, err
+    }
+
+    for i, s := range data.stocks {
+        row := i + 2
+        values := []interface{}{s.Symbol, s.Currency, s.Shares, s.Price}
+        if price, ok := data.prices[s.Symbol]; ok {
+            values = append(values, price)
+        }
+        cell, _ := excelize.CoordinatesToCellName(1, row)
+        if err := f.SetSheetRow(holdings, cell, &values); err != nil {
+            return nil, err
+        }
+
+        // Keep P&L as formulas so edits to the current price recalculate
+        formulas := map[string]string{
+            "F": fmt.Sprintf("C%d*D%d", row, row),
+            "G": fmt.Sprintf("IF(E%d=\"\",\"\",C%d*E%d)", row, row, row),
+            "H": fmt.Sprintf("IF(G%d=\"\",\"\",G%d-F%d)", row, row, row),
+            "I": fmt.Sprintf("IF(OR(G%d=\"\",F%d=0),\"\",H%d/F%d)", row, row, row, row),
+        }
+        for col, formula := range formulas {
+            if err := f.SetCellFormula(holdings, fmt.Sprintf("%s%d", col, row), formula); err != nil {
+                return nil, err
+            }
+        }
+    }
+
+    if n := len(data.stocks); n > 0 {
+        last := n + 1
+        f.SetCellStyle(holdings, "D2", fmt.Sprintf("H%d", last), money)
+        f.SetCellStyle(holdings, "I2", fmt.Sprintf("I%d", last), percent)
+    }
+
+    if err := writeSheet(f, "Transactions", transactionsHeader, len(data.transactions), func(i int) []interface{} {
+        return transactionRow(data.transactions[i])
+    }); err != nil {
+        return nil, err
+    }
+
+    if err := writeSheet(f, "History", historyHeader, len(data.history), func(i int) []interface{} {
+        return historyRow(data.history[i])
+    }); err != nil {
+        return nil, err
+    }
+
+    return f, nil
+}
+
+func writeSheet(f *excelize.File, sheet string, header []string, n int, row func(int) []interface{}) error {
+    if _, err := f.NewSheet(sheet); err != nil {
+        return err
+    }
+
+    values := make([]interface{}, len(header))
+    for i, h := range header {
+        values[i] = h
+    }
+    if err := f.SetSheetRow(sheet, "A1", &values); err != nil {
+        return err
+    }
+
+    bold, err := f.NewStyle(&excelize.Style{Font: &excelize.Font{Bold: true}})
+    if err != nil {
+        return err
+    }
+    lastCol, _ := excelize.ColumnNumberToName(len(header))
+    f.SetCellStyle(sheet, "A1", lastCol+"1", bold)
+    f.SetColWidth(sheet, "A", lastCol, 16)
+    f.SetPanes(sheet, &excelize.Panes{Freeze: true, YSplit: 1, TopLeftCell: "A2", ActivePane: "bottomLeft"})
+
+    for i := 0; i < n; i++ {
+        values := row(i)
+        cell, _ := excelize.CoordinatesToCellName(1, i+2)
+        if err := f.SetSheetRow(sheet, cell, &values); err != nil {
+            return err
+        }
+    }
+    return nil
+}
\ No newline at end of file
@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"server/db"
+	"server/reqctx"
+)
+
+type checkResult struct {
+    Status    string  `json:"status"`
+    LatencyMs float64 `json:"latencyMs"`
+    Error     string  `json:"error,omitempty"`
+}
+
+type readiness struct {
+    Status string                 `json:"status"`
+    Checks map[string]checkResult `json:"checks"`
+}
+
+// HandleHealthz only reports that the process is up and serving
+func HandleHealthz(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "application/json")
+    w.Header().Set("Cache-Control", "no-store")
+    json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// HandleReadyz checks every dependency in parallel and returns 503 if any fails
+func HandleReadyz(w http.ResponseWriter, r *http.Request) {
+    checks := map[string]func(context.Context) error{
+        "database":   db.Ping,
+        "migrations": db.CheckSchema,
+    }
+    if settings.Health.CheckMarketData {
+        checks["marketData"] = checkMarketData
+    }
+
+    result := readiness{Status: "ok", Checks: make(map[string]checkResult, len(checks))}
+    var mu sync.Mutex
+    var wg sync.WaitGroup
+
+    for name, check := range checks {
+        wg.Add(1)
+        go func(name string, check func(context.Context) error) {
+            defer wg.Done()
+
+            ctx, cancel := context.WithTimeout(r.Context(), settings.Health.Timeout)
+            defer cancel()
+
+            start := time.Now()
+            err := check(ctx)
+            res := checkResult{
+                Status:    "ok",
+                LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+            }
+            if err != nil {
+                reqctx.Logger(r.Context()).Warn("Readiness check failed", "check", name, "error", err)
+                res.Status = "error"
+                res.Error = checkError(err)
+            }
+
+            mu.Lock()
+            result.Checks[name] = res
+            if err != nil {
+                result.Status = "unavailable"
+            }
+            mu.Unlock()
+        }(name, check)
+    }
+    wg.Wait()
+
+    w.Header().Set("Content-Type", "application/json")
+    w.Header().Set("Cache-Control", "no-store")
+    if result.Status != "ok" {
+        w.WriteHeader(http.StatusServiceUnavailable)
+    }
+    json.NewEncoder(w).Encode(result)
+}
+
+func checkMarketData(ctx context.Context) error {
+    req, err := http.NewRequestWithContext(ctx, "HEAD", settings.Market.StooqURL, nil)
+    if err != nil {
+        return err
+    }
+    resp, err := marketClient.Do(req)
+    if err != nil {
+        return err
+    }
+    resp.Body.Close()
+    if resp.StatusCode >= http.StatusInternalServerError {
+        return fmt.Errorf("provider returned %s", resp.Status)
+    }
+    return nil
+}
+
+// The endpoint is unauthenticated, so only timeouts and schema problems are
+// described; other errors stay in the log.
+func checkError(err error) string {
+    switch {
+    case errors.Is(err, context.DeadlineExceeded):
+        return "timed out"
+    case errors.Is(err, db.ErrSchemaIncomplete):
+        return err.Error()
+    default:
+        return "unavailable"
+    }
+}
\ No newline at end of file
@@ -0,0 +1,154 @@
+package handlers_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"server/auth"
+	"server/config"
+	"server/db"
+	"server/handlers"
+	"server/mail"
+	"server/mail/mailtest"
+	"server/ratelimit"
+	"server/router"
+)
+
+// setup connects to TEST_DATABASE_URL, whose schema InitDB brings up to
+// date, and delivers mail to a local SMTP fake. Each test starts with fresh
+// rate limits.
+func setup(t *testing.T, configure ...func(*config.Config)) (http.Handler, *mailtest.Server) {
+    t.Helper()
+    url := os.Getenv("TEST_DATABASE_URL")
+    if url == "" {
+        t.Skip("TEST_DATABASE_URL is not set")
+    }
+    if db.Pool == nil {
+        if err := db.InitDB(url); err != nil {
+            t.Fatal(err)
+        }
+    }
+    if err := auth.InitJWT(nil); err != nil {
+        t.Fatal(err)
+    }
+
+    smtp, err := mailtest.NewServer()
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { smtp.Close() })
+
+    cfg := config.Default()
+    cfg.Mail.AppURL = "http://app.test"
+    cfg.Mail.SMTPHost = smtp.Host()
+    cfg.Mail.SMTPPort = smtp.Port()
+    cfg.Mail.From = "noreply@example.com"
+    for _, f := range configure {
+        f(cfg)
+    }
+    handlers.Configure(cfg)
+    handlers.SetMailer(mail.NewSMTPSender(cfg.Mail))
+    handlers.SetLimiter(ratelimit.New(ratelimit.NewMemoryStore()))
+    return router.New(), smtp
+}
+
+// call sends a JSON request to an API path. A bare token is sent as a
+// session; one with a scheme, such as "ApiKey stk_...", is sent as it is.
+func call(t *testing.T, h http.Handler, method, path, token string, body any, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+    t.Helper()
+    var buf bytes.Buffer
+    if body != nil {
+        json.NewEncoder(&buf).Encode(body)
+    }
+    req := httptest.NewRequest(method, router.APIPrefix+path, &buf)
+    req.Header.Set("Content-Type", "application/json")
+    if strings.Contains(token, " ") {
+        req.Header.Set("Authorization", token)
+    } else if token != "" {
+        req.Header.Set("Authorization", "Bearer "+token)
+    }
+    for _, c := range cookies {
+        req.AddCookie(c)
+    }
+    rec := httptest.NewRecorder()
+    h.ServeHTTP(rec, req)
+    return rec
+}
+
+func decode(t *testing.T, rec *httptest.ResponseRecorder, v any) {
+    t.Helper()
+    if err := json.NewDecoder(rec.Body).Decode(v); err != nil {
+        t.Fatalf("decoding %s: %v", rec.Body, err)
+    }
+}
+
+func wantError(t *testing.T, rec *httptest.ResponseRecorder, status int, code string) {
+    t.Helper()
+    if rec.Code != status || !strings.Contains(rec.Body.String(), `"`+code+`"`) {
+        t.Fatalf("got %d %s, want %d %s", rec.Code, rec.Body, status, code)
+    }
+}
+
+func login(t *testing.T, h http.Handler, email, password string) string {
+    t.Helper()
+    rec := call(t, h, "POST", "/login", "", map[string]string{"email": email, "password": password})
+    if rec.Code != http.StatusOK {
+        t.Fatalf("login: %d %s", rec.Code, rec.Body)
+    }
+    var resp struct {
+        Token string `json:"token"`
+    }
+    decode(t, rec, &resp)
+    return resp.Token
+}
+
+// testUser is an account made by verifiedUser, logged in with Token
+type testUser struct {
+    ID       int
+    Email    string
+    Password string
+    Token    string
+}
+
+// verifiedUser registers an account with a verified email and logs it in.
+// The name makes the email easy to spot in a failure.
+func verifiedUser(t *testing.T, h http.Handler, name string) testUser {
+    t.Helper()
+    u := testUser{
+        Email:    fmt.Sprintf("%s.%d@example.com", name, time.Now().UnixNano()),
+        Password: "Original-Password-1",
+    }
+    if rec := call(t, h, "POST", "/register", "", map[string]string{"email": u.Email, "password": u.Password}); rec.Code != http.StatusCreated {
+        t.Fatalf("register: %d %s", rec.Code, rec.Body)
+    }
+    err := db.Pool.QueryRow(context.Background(),
+        `UPDATE users SET email_verified_at = NOW() WHERE email = $1 RETURNING id`, u.Email).Scan(&u.ID)
+    if err != nil {
+        t.Fatal(err)
+    }
+    u.Token = login(t, h, u.Email, u.Password)
+    return u
+}
+
+// apiKey creates a personal API key with the given scopes and returns it as
+// a credential for call
+func apiKey(t *testing.T, h http.Handler, token string, scopes ...string) string {
+    t.Helper()
+    rec := call(t, h, "POST", "/api-keys", token, map[string]any{"name": "test", "scopes": scopes})
+    if rec.Code != http.StatusCreated {
+        t.Fatalf("create API key: %d %s", rec.Code, rec.Body)
+    }
+    var resp struct {
+        Key string `json:"key"`
+    }
+    decode(t, rec, &resp)
+    return "ApiKey " + resp.Key
+}
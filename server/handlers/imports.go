@@ -0,0 +1,450 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"server/apierr"
+	"server/db"
+	"server/metrics"
+	"server/models"
+	"server/reqctx"
+
+	"github.com/xuri/excelize/v2"
+)
+
+const (
+    defaultImportHistory = 100
+    maxImportHistory     = 1000
+
+    maxImportIssues    = 500
+    maxImportAttempts  = 3
+    importPollInterval = 30 * time.Second // Picks up jobs queued on other instances
+)
+
+func HandleGetImport(w http.ResponseWriter, r *http.Request) {
+    imp, err := loadImport(r, models.AccessViewer)
+    if err != nil {
+        writeError(w, r, err)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(imp)
+}
+
+// Applies a dry run upload; the statement is processed like any other import
+func HandleConfirmImport(w http.ResponseWriter, r *http.Request) {
+    imp, err := loadImport(r, models.AccessEditor)
+    if err != nil {
+        writeError(w, r, err)
+        return
+    }
+
+    if err := db.ConfirmImport(r.Context(), imp.ID, settings.Import.PreviewTTL); err != nil {
+        writeError(w, r, err)
+        return
+    }
+    wakeImportWorkers()
+    reqctx.Logger(r.Context()).Info("Import confirmed", "import_id", imp.ID)
+
+    imp.Status = models.ImportQueued
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(http.StatusAccepted)
+    json.NewEncoder(w).Encode(imp)
+}
+
+// The import named by {id}. The uploader can always follow it, anyone else
+// needs access to the portfolio.
+func loadImport(r *http.Request, need string) (*models.Import, error) {
+    id, err := strconv.Atoi(r.PathValue("id"))
+    if err != nil {
+        return nil, db.ErrImportNotFound
+    }
+
+    imp, err := db.GetImport(r.Context(), id)
+    if err != nil {
+        return nil, err
+    }
+    if imp.UserID == currentUserID(r) && need == models.AccessViewer {
+        return imp, nil
+    }
+
+    p, err := db.GetPortfolioAccess(r.Context(), imp.PortfolioID, currentUserID(r))
+    if errors.Is(err, db.ErrPortfolioNotFound) {
+        return nil, db.ErrImportNotFound
+    } else if err != nil {
+        return nil, err
+    }
+    if err := checkAccess(p, need); err != nil {
+        return nil, err
+    }
+    return imp, nil
+}
+
+// The caller's own imports, most recent first
+func HandleListImports(w http.ResponseWriter, r *http.Request) {
+    limit, err := importLimit(r)
+    if err != nil {
+        writeError(w, r, err)
+        return
+    }
+
+    imports, err := db.GetImports(r.Context(), currentUserID(r), limit)
+    if err != nil {
+        writeError(w, r, fmt.Errorf("listing imports: %w", err))
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(imports)
+}
+
+func importLimit(r *http.Request) (int, error) {
+    v := r.URL.Query().Get("limit")
+    if v == "" {
+        return defaultImportHistory, nil
+    }
+    n, err := strconv.Atoi(v)
+    if err != nil || n < 1 || n > maxImportHistory {
+        return 0, apierr.BadRequest("invalid_limit", fmt.Sprintf("limit must be between 1 and %d", maxImportHistory))
+    }
+    return n, nil
+}
+
+// Problems found while parsing a statement. A row may be looked at by more
+// than one parser, so repeats are dropped.
+type importIssues struct {
+    issues []models.ImportIssue
+    seen   map[string]bool
+}
+
+func newImportIssues() *importIssues {
+    return &importIssues{issues: make([]models.ImportIssue, 0), seen: make(map[string]bool)}
+}
+
+func (c *importIssues) add(row int, severity, message string) {
+    key := strconv.Itoa(row) + "|" + message
+    if c.seen[key] || len(c.issues) >= maxImportIssues {
+        return
+    }
+    c.seen[key] = true
+    c.issues = append(c.issues, models.ImportIssue{Row: row, Severity: severity, Message: message})
+}
+
+// In row order
+func (c *importIssues) list() []models.ImportIssue {
+    sort.SliceStable(c.issues, func(i, j int) bool { return c.issues[i].Row < c.issues[j].Row })
+    return c.issues
+}
+
+var importWake = make(chan struct{}, 1)
+
+// Nudges an idle worker; the periodic poll is the fallback
+func wakeImportWorkers() {
+    select {
+    case importWake <- struct{}{}:
+    default:
+    }
+}
+
+// RunImportWorkers processes queued imports until ctx is cancelled. Jobs live
+// in the database, so an import interrupted by a shutdown is picked up again
+// by whichever instance claims it next.
+func RunImportWorkers(ctx context.Context) {
+    var wg sync.WaitGroup
+    for i := 0; i < settings.Import.Workers; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            importWorker(ctx)
+        }()
+    }
+    defer wg.Wait()
+
+    ticker := time.NewTicker(importPollInterval)
+    defer ticker.Stop()
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+            if n, err := db.ExpirePreviews(ctx, settings.Import.PreviewTTL); err != nil && ctx.Err() == nil {
+                slog.Warn("Failed to expire import previews", "error", err)
+            } else if n > 0 {
+                slog.Info("Expired import previews", "count", n)
+            }
+        }
+    }
+}
+
+func importWorker(ctx context.Context) {
+    ticker := time.NewTicker(importPollInterval)
+    defer ticker.Stop()
+
+    for {
+        for ctx.Err() == nil && runNextImport(ctx) {
+        }
+
+        select {
+        case <-ctx.Done():
+            return
+        case <-importWake:
+        case <-ticker.C:
+        }
+    }
+}
+
+// Reports whether there was a job to run
+func runNextImport(ctx context.Context) bool {
+    imp, file, err := db.ClaimImport(ctx, settings.Import.JobTimeout)
+    if err != nil {
+        if ctx.Err() == nil {
+            slog.Error("Failed to claim import", "error", err)
+        }
+        return false
+    }
+    if imp == nil {
+        return false
+    }
+    // There may be more; let a sibling look while this one works
+    wakeImportWorkers()
+
+    start := time.Now()
+    err = processImport(ctx, imp, file)
+    metrics.ObserveJob("import", start, err)
+    return true
+}
+
+func processImport(ctx context.Context, imp *models.Import, file []byte) error {
+    log := slog.With("import_id", imp.ID, "portfolio_id", imp.PortfolioID)
+
+    var err error
+    if imp.Attempts > maxImportAttempts {
+        err = errors.New("too many attempts")
+        imp.Error = "The import was interrupted too many times"
+    } else {
+        jobCtx, cancel := context.WithTimeout(ctx, settings.Import.JobTimeout)
+        err = importStatement(jobCtx, imp, file)
+        cancel()
+    }
+
+    var apiErr *apierr.Error
+    switch {
+    case err == nil:
+        imp.Status = models.ImportCompleted
+        log.Info("Import completed", "transactions", imp.Transactions, "dividends", imp.Dividends,
+            "cash_operations", imp.CashOperations, "issues", len(imp.Issues))
+    case ctx.Err() != nil:
+        // The statement is saved in one transaction, which was rolled back,
+        // so the next attempt starts over
+        if err := db.RequeueImport(context.WithoutCancel(ctx), imp.ID); err != nil {
+            log.Error("Failed to requeue interrupted import", "error", err)
+        }
+        return ctx.Err()
+    case errors.As(err, &apiErr):
+        imp.Status = models.ImportFailed
+        imp.Error = apiErr.Detail
+        log.Warn("Import rejected", "error", err)
+    default:
+        imp.Status = models.ImportFailed
+        if imp.Error == "" {
+            imp.Error = "The import could not be completed"
+        }
+        log.Error("Import failed", "error", err)
+    }
+
+    if err := db.FinishImport(context.WithoutCancel(ctx), imp); err != nil {
+        log.Error("Failed to record import outcome", "error", err)
+    }
+    return err
+}
+
+type parsedStatement struct {
+    dividends    []models.CorporateAction
+    cashOps      []models.CashOperation
+    transactions []models.Transaction
+    issues       []models.ImportIssue
+}
+
+func (s *parsedStatement) records() int {
+    return len(s.dividends) + len(s.cashOps) + len(s.transactions)
+}
+
+func parseStatement(file []byte) (*parsedStatement, error) {
+    f, err := excelize.OpenReader(bytes.NewReader(file))
+    if err != nil {
+        return nil, invalidStatement(err)
+    }
+    defer f.Close()
+
+    st := &parsedStatement{}
+    issues := newImportIssues()
+    if st.dividends, err = ParseDividendsXLSX(f, issues); err != nil {
+        return nil, invalidStatement(err)
+    }
+    if st.cashOps, err = ParseCashOperationsXLSX(f, issues); err != nil {
+        return nil, invalidStatement(err)
+    }
+    if st.transactions, err = ParseTransactionsXLSX(f, issues); err != nil {
+        return nil, invalidStatement(err)
+    }
+    st.issues = issues.list()
+    return st, nil
+}
+
+// Parses the upload and stores it as a preview to be confirmed later,
+// reporting what applying it would change
+func previewImport(w http.ResponseWriter, r *http.Request, portfolio *models.Portfolio, filename string, file []byte) {
+    st, err := parseStatement(file)
+    if err != nil {
+        writeError(w, r, err)
+        return
+    }
+
+    preview, err := buildPreview(r.Context(), portfolio.ID, st)
+    if err != nil {
+        writeError(w, r, err)
+        return
+    }
+
+    preview.Import = &models.Import{
+        UserID:      currentUserID(r),
+        PortfolioID: portfolio.ID,
+        Filename:    filename,
+        Status:      models.ImportPreviewed,
+        TotalRows:   st.records(),
+        Issues:      st.issues,
+    }
+    if err := db.CreateImport(r.Context(), preview.Import, file); err != nil {
+        writeError(w, r, fmt.Errorf("saving preview: %w", err))
+        return
+    }
+    reqctx.Logger(r.Context()).Info("Import previewed", "import_id", preview.Import.ID, "duplicates", len(preview.Duplicates))
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(preview)
+}
+
+// Records already in the portfolio, or repeated in the statement, are
+// duplicates; the rest are what importing would add.
+func buildPreview(ctx context.Context, portfolioID int, st *parsedStatement) (*models.ImportPreview, error) {
+    ids := map[string][]string{}
+    for _, t := range st.transactions {
+        ids[models.RecordTransaction] = append(ids[models.RecordTransaction], t.ExternalID)
+    }
+    for _, d := range st.dividends {
+        ids[models.RecordDividend] = append(ids[models.RecordDividend], d.ExternalID)
+    }
+    for _, op := range st.cashOps {
+        ids[models.RecordCash] = append(ids[models.RecordCash], op.ExternalID)
+    }
+    known, err := db.ImportedExternalIDs(ctx, portfolioID, ids)
+    if err != nil {
+        return nil, fmt.Errorf("checking for duplicates: %w", err)
+    }
+
+    preview := &models.ImportPreview{
+        Transactions:   st.transactions,
+        Dividends:      len(st.dividends),
+        CashOperations: len(st.cashOps),
+        Positions:      make([]models.PositionDelta, 0),
+        Duplicates:     make([]models.ImportDuplicate, 0),
+    }
+    // The parsers skip rows without an ID, so every record can be matched
+    duplicate := func(kind, id, symbol, time string) bool {
+        if !known[kind][id] {
+            known[kind][id] = true
+            return false
+        }
+        preview.Duplicates = append(preview.Duplicates, models.ImportDuplicate{
+            Kind: kind, ExternalID: id, Symbol: symbol, Time: time,
+        })
+        return true
+    }
+
+    changes := make(map[string]float64)
+    added := make([]models.Transaction, 0, len(st.transactions))
+    for _, t := range st.transactions {
+        if duplicate(models.RecordTransaction, t.ExternalID, t.Symbol, t.Time) {
+            continue
+        }
+        if t.Side == models.SideSell {
+            changes[t.Symbol] -= t.Quantity
+        } else {
+            changes[t.Symbol] += t.Quantity
+        }
+        added = append(added, t)
+    }
+    for _, d := range st.dividends {
+        duplicate(models.RecordDividend, d.ExternalID, d.Symbol, d.ExDate)
+    }
+    for _, op := range st.cashOps {
+        duplicate(models.RecordCash, op.ExternalID, op.Symbol, op.Time)
+    }
+
+    stocks, err := db.GetStocks(ctx, portfolioID)
+    if err != nil {
+        return nil, fmt.Errorf("retrieving stocks: %w", err)
+    }
+    held := make(map[string]float64, len(stocks))
+    for _, s := range stocks {
+        held[s.Symbol] = s.Shares
+    }
+    for _, symbol := range transactionSymbols(added) {
+        preview.Positions = append(preview.Positions, models.PositionDelta{
+            Symbol:          symbol,
+            CurrentShares:   held[symbol],
+            Change:          changes[symbol],
+            ResultingShares: held[symbol] + changes[symbol],
+        })
+    }
+    return preview, nil
+}
+
+func importStatement(ctx context.Context, imp *models.Import, file []byte) error {
+    st, err := parseStatement(file)
+    if err != nil {
+        return err
+    }
+    imp.Issues = st.issues
+
+    imp.TotalRows = st.records()
+    imp.ProcessedRows = 0
+    progress := func(n int) error {
+        imp.ProcessedRows += n
+        return db.UpdateImportProgress(ctx, imp.ID, imp.ProcessedRows, imp.TotalRows)
+    }
+    if err := progress(0); err != nil {
+        return fmt.Errorf("updating progress: %w", err)
+    }
+
+    err = db.SaveImport(ctx, imp, st.dividends, st.cashOps, st.transactions, settings.Import.ChunkSize, progress)
+    if err != nil {
+        return err
+    }
+    metrics.ImportRows.WithLabelValues("dividend").Add(float64(imp.Dividends))
+    metrics.ImportRows.WithLabelValues("cash").Add(float64(imp.CashOperations))
+    metrics.ImportRows.WithLabelValues("transaction").Add(float64(imp.Transactions))
+
+    pid := imp.PortfolioID
+    if err := db.LogActivity(ctx, pid, imp.UserID, models.ActivityStatementImported, map[string]interface{}{
+        "importId":       imp.ID,
+        "filename":       imp.Filename,
+        "transactions":   imp.Transactions,
+        "dividends":      imp.Dividends,
+        "cashOperations": imp.CashOperations,
+    }); err != nil {
+        slog.Warn("Failed to log portfolio activity", "portfolio_id", pid, "error", err)
+    }
+    return nil
+}
\ No newline at end of file
@@ -0,0 +1,10 @@
+package handlers
+
+import "server/mail"
+
+// Replaced by SetMailer at startup; logs messages until then
+var mailer mail.Sender = mail.LogSender{}
+
+func SetMailer(m mail.Sender) {
+    mailer = m
+}
\ No newline at end of file
@@ -0,0 +1,270 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"server/apierr"
+	"server/db"
+	"server/mail"
+	"server/models"
+	"server/reqctx"
+)
+
+func HandleListMembers(w http.ResponseWriter, r *http.Request) {
+    portfolio, err := resolvePortfolioFor(r, models.AccessViewer)
+    if err != nil {
+        writeError(w, r, err)
+        return
+    }
+
+    members, err := db.GetPortfolioMembers(r.Context(), portfolio.ID)
+    if err != nil {
+        writeError(w, r, fmt.Errorf("retrieving members: %w", err))
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(members)
+}
+
+// Invites an email address to the portfolio and lets it know by email. The
+// response is the same whether or not an account uses the address, so
+// owners cannot use it to find out who is registered.
+func HandleAddMember(w http.ResponseWriter, r *http.Request) {
+    portfolio, err := resolvePortfolioFor(r, models.AccessOwner)
+    if err != nil {
+        writeError(w, r, err)
+        return
+    }
+
+    var req models.PortfolioInvite
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        writeError(w, r, invalidBody())
+        return
+    }
+    if err := req.Validate(); err != nil {
+        writeError(w, r, err)
+        return
+    }
+
+    owner, err := db.GetUserByID(r.Context(), portfolio.UserID)
+    if err != nil {
+        writeError(w, r, fmt.Errorf("looking up owner: %w", err))
+        return
+    }
+    if owner.Email == req.Email {
+        writeError(w, r, apierr.Validation(apierr.FieldError{Field: "email", Message: "the owner already has full access"}))
+        return
+    }
+
+    invite := models.PortfolioInvitation{
+        PortfolioID:   portfolio.ID,
+        PortfolioName: portfolio.Name,
+        Email:         req.Email,
+        Access:        req.Access,
+        InvitedBy:     currentUserID(r),
+    }
+    if err := db.InvitePortfolioMember(r.Context(), &invite); err != nil {
+        writeError(w, r, err)
+        return
+    }
+    logActivity(r, portfolio.ID, models.ActivityMemberInvited, map[string]interface{}{
+        "inviteId": invite.ID,
+        "email":    invite.Email,
+        "access":   invite.Access,
+    })
+
+    err = mailer.Send(r.Context(), mail.Message{
+        To:      invite.Email,
+        Subject: "A portfolio has been shared with you",
+        Body: fmt.Sprintf("You have been offered %s access to the portfolio %q.\n"+
+            "Sign in with this email address, or create an account with it, to accept:\n%s/invites\n",
+            invite.Access, portfolio.Name, settings.Mail.AppURL),
+    })
+    if err != nil {
+        reqctx.Logger(r.Context()).Error("Failed to send share invitation", "invite_id", invite.ID, "error", err)
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(http.StatusAccepted)
+    json.NewEncoder(w).Encode(invite)
+}
+
+func HandleListInvites(w http.ResponseWriter, r *http.Request) {
+    portfolio, err := resolvePortfolioFor(r, models.AccessOwner)
+    if err != nil {
+        writeError(w, r, err)
+        return
+    }
+
+    invites, err := db.GetPortfolioInvites(r.Context(), portfolio.ID)
+    if err != nil {
+        writeError(w, r, fmt.Errorf("retrieving invitations: %w", err))
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(invites)
+}
+
+func HandleDeleteInvite(w http.ResponseWriter, r *http.Request) {
+    portfolio, err := resolvePortfolioFor(r, models.AccessOwner)
+    if err != nil {
+        writeError(w, r, err)
+        return
+    }
+    id, ok := inviteParam(w, r)
+    if !ok {
+        return
+    }
+
+    if err := db.DeletePortfolioInvite(r.Context(), portfolio.ID, id); err != nil {
+        writeError(w, r, err)
+        return
+    }
+    logActivity(r, portfolio.ID, models.ActivityInviteRevoked, map[string]interface{}{"inviteId": id})
+    w.WriteHeader(http.StatusNoContent)
+}
+
+// Lists the invitations waiting for the caller's email
+func HandleListMyInvites(w http.ResponseWriter, r *http.Request) {
+    user, err := db.GetUserByID(r.Context(), currentUserID(r))
+    if err != nil {
+        writeError(w, r, fmt.Errorf("looking up user: %w", err))
+        return
+    }
+
+    invites, err := db.GetInvitesForEmail(r.Context(), user.Email)
+    if err != nil {
+        writeError(w, r, fmt.Errorf("retrieving invitations: %w", err))
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(invites)
+}
+
+// Accepts an invitation to the caller's email. The route requires a
+// verified email, so only the owner of the address can accept.
+func HandleAcceptInvite(w http.ResponseWriter, r *http.Request) {
+    id, ok := inviteParam(w, r)
+    if !ok {
+        return
+    }
+    user, err := db.GetUserByID(r.Context(), currentUserID(r))
+    if err != nil {
+        writeError(w, r, fmt.Errorf("looking up user: %w", err))
+        return
+    }
+
+    member, err := db.AcceptPortfolioInvite(r.Context(), id, user.ID, user.Email)
+    if err != nil {
+        writeError(w, r, err)
+        return
+    }
+    logActivity(r, member.PortfolioID, models.ActivityMemberAdded, map[string]interface{}{
+        "userId": member.UserID,
+        "email":  member.Email,
+        "access": member.Access,
+    })
+
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(http.StatusCreated)
+    json.NewEncoder(w).Encode(member)
+}
+
+func HandleUpdateMember(w http.ResponseWriter, r *http.Request) {
+    portfolio, err := resolvePortfolioFor(r, models.AccessOwner)
+    if err != nil {
+        writeError(w, r, err)
+        return
+    }
+    userID, ok := memberParam(w, r)
+    if !ok {
+        return
+    }
+
+    var req models.MemberChange
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        writeError(w, r, invalidBody())
+        return
+    }
+    if !models.ValidMemberAccess(req.Access) {
+        writeError(w, r, apierr.Validation(apierr.FieldError{Field: "access", Message: "access must be viewer or editor"}))
+        return
+    }
+
+    if err := db.UpdatePortfolioMember(r.Context(), portfolio.ID, userID, req.Access); err != nil {
+        writeError(w, r, err)
+        return
+    }
+    logActivity(r, portfolio.ID, models.ActivityMemberUpdated, map[string]interface{}{
+        "userId": userID,
+        "access": req.Access,
+    })
+    w.WriteHeader(http.StatusNoContent)
+}
+
+// The owner can remove anyone; members can remove themselves to leave
+func HandleRemoveMember(w http.ResponseWriter, r *http.Request) {
+    userID, ok := memberParam(w, r)
+    if !ok {
+        return
+    }
+    need := models.AccessOwner
+    if userID == currentUserID(r) {
+        need = models.AccessViewer
+    }
+    portfolio, err := resolvePortfolioFor(r, need)
+    if err != nil {
+        writeError(w, r, err)
+        return
+    }
+
+    if err := db.RemovePortfolioMember(r.Context(), portfolio.ID, userID); err != nil {
+        writeError(w, r, err)
+        return
+    }
+    logActivity(r, portfolio.ID, models.ActivityMemberRemoved, map[string]interface{}{
+        "userId": userID,
+    })
+    w.WriteHeader(http.StatusNoContent)
+}
+
+func HandlePortfolioActivity(w http.ResponseWriter, r *http.Request) {
+    portfolio, err := resolvePortfolio(r)
+    if err != nil {
+        writeError(w, r, err)
+        return
+    }
+
+    entries, err := db.GetPortfolioActivity(r.Context(), portfolio.ID)
+    if err != nil {
+        writeError(w, r, fmt.Errorf("retrieving activity: %w", err))
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(entries)
+}
+
+func inviteParam(w http.ResponseWriter, r *http.Request) (int, bool) {
+    id, err := strconv.Atoi(r.PathValue("inviteID"))
+    if err != nil {
+        writeError(w, r, apierr.BadRequest("invalid_id", "Invitation id must be a number"))
+        return 0, false
+    }
+    return id, true
+}
+
+func memberParam(w http.ResponseWriter, r *http.Request) (int, bool) {
+    id, err := strconv.Atoi(r.PathValue("userID"))
+    if err != nil {
+        writeError(w, r, apierr.BadRequest("invalid_id", "Member user id must be a number"))
+        return 0, false
+    }
+    return id, true
+}
\ No newline at end of file
@@ -0,0 +1,118 @@
+package handlers_test
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"server/models"
+)
+
+func TestInviteDoesNotRevealAccounts(t *testing.T) {
+    h, _ := setup(t)
+    owner := verifiedUser(t, h, "owner").Token
+    invitee := verifiedUser(t, h, "invitee")
+    other := verifiedUser(t, h, "other").Token
+    unknown := fmt.Sprintf("nobody.%d@example.com", time.Now().UnixNano())
+
+    // Listing creates the default portfolio
+    rec := call(t, h, "GET", "/portfolios", owner, nil)
+    var portfolios []models.Portfolio
+    decode(t, rec, &portfolios)
+    if len(portfolios) != 1 {
+        t.Fatalf("portfolios = %+v", portfolios)
+    }
+    members := fmt.Sprintf("/portfolios/%d/members", portfolios[0].ID)
+
+    invites := map[string]models.PortfolioInvitation{}
+    for _, email := range []string{invitee.Email, unknown} {
+        rec := call(t, h, "POST", members, owner, map[string]string{"email": email, "access": models.AccessViewer})
+        if rec.Code != http.StatusAccepted {
+            t.Fatalf("invite %s: %d %s", email, rec.Code, rec.Body)
+        }
+        var inv models.PortfolioInvitation
+        decode(t, rec, &inv)
+        if inv.Email != email || inv.Access != models.AccessViewer {
+            t.Errorf("invite = %+v", inv)
+        }
+        invites[email] = inv
+    }
+
+    accept := fmt.Sprintf("/invites/%d/accept", invites[invitee.Email].ID)
+    wantError(t, call(t, h, "POST", accept, other, nil), http.StatusNotFound, "invite_not_found")
+
+    rec = call(t, h, "GET", "/invites", invitee.Token, nil)
+    var waiting []models.PortfolioInvitation
+    decode(t, rec, &waiting)
+    if len(waiting) != 1 || waiting[0].ID != invites[invitee.Email].ID {
+        t.Fatalf("invitee's invitations = %+v", waiting)
+    }
+    if rec := call(t, h, "POST", accept, invitee.Token, nil); rec.Code != http.StatusCreated {
+        t.Fatalf("accept: %d %s", rec.Code, rec.Body)
+    }
+
+    rec = call(t, h, "GET", members, owner, nil)
+    var list []models.PortfolioMember
+    decode(t, rec, &list)
+    if len(list) != 1 || list[0].Email != invitee.Email {
+        t.Errorf("members = %+v, want only %s", list, invitee.Email)
+    }
+    rec = call(t, h, "GET", fmt.Sprintf("/portfolios/%d/invites", portfolios[0].ID), owner, nil)
+    var pending []models.PortfolioInvitation
+    decode(t, rec, &pending)
+    if len(pending) != 1 || pending[0].Email != unknown {
+        t.Errorf("pending invitations = %+v, want only %s", pending, unknown)
+    }
+}
+
+func TestMemberWriteAccess(t *testing.T) {
+    h, _ := setup(t)
+    owner := verifiedUser(t, h, "owner").Token
+    viewer := verifiedUser(t, h, "viewer")
+    editor := verifiedUser(t, h, "editor")
+
+    rec := call(t, h, "GET", "/portfolios", owner, nil)
+    var portfolios []models.Portfolio
+    decode(t, rec, &portfolios)
+    if len(portfolios) != 1 {
+        t.Fatalf("portfolios = %+v", portfolios)
+    }
+    base := fmt.Sprintf("/portfolios/%d", portfolios[0].ID)
+
+    for _, m := range []struct {
+        user   testUser
+        access string
+    }{{viewer, models.AccessViewer}, {editor, models.AccessEditor}} {
+        rec := call(t, h, "POST", base+"/members", owner, map[string]string{"email": m.user.Email, "access": m.access})
+        if rec.Code != http.StatusAccepted {
+            t.Fatalf("invite %s: %d %s", m.access, rec.Code, rec.Body)
+        }
+        var inv models.PortfolioInvitation
+        decode(t, rec, &inv)
+        if rec := call(t, h, "POST", fmt.Sprintf("/invites/%d/accept", inv.ID), m.user.Token, nil); rec.Code != http.StatusCreated {
+            t.Fatalf("accept %s: %d %s", m.access, rec.Code, rec.Body)
+        }
+    }
+
+    // In order, since the split needs the shares bought before it
+    writes := []struct {
+        path string
+        body any
+        want int // For the editor
+    }{
+        {"/transactions", map[string]any{"symbol": "AAPL.US", "side": "buy", "quantity": 10, "price": 100, "currency": "USD", "time": "2024-01-10"}, http.StatusCreated},
+        {"/cash", map[string]any{"type": "deposit", "amount": 1000}, http.StatusCreated},
+        {"/corporate-actions", map[string]any{"symbol": "AAPL.US", "type": "split", "exDate": "2024-02-01", "ratio": 2, "currency": "USD"}, http.StatusCreated},
+        // Not a multipart form, so the editor gets past the access check only
+        {"/positions", nil, http.StatusBadRequest},
+    }
+    for _, tt := range writes {
+        t.Run(tt.path, func(t *testing.T) {
+            wantError(t, call(t, h, "POST", base+tt.path, viewer.Token, tt.body), http.StatusForbidden, "portfolio_read_only")
+            if rec := call(t, h, "POST", base+tt.path, editor.Token, tt.body); rec.Code != tt.want {
+                t.Errorf("editor: %d %s, want %d", rec.Code, rec.Body, tt.want)
+            }
+        })
+    }
+}
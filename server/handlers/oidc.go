@@ -0,0 +1,268 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"server/apierr"
+	"server/auth"
+	"server/db"
+	"server/models"
+	"server/reqctx"
+)
+
+var (
+    oidcMu     sync.Mutex
+    oidcClient *auth.OIDCClient
+)
+
+// Discovery runs on first use and is retried after a failure, so an issuer
+// that is down when the server starts only affects SSO logins
+func getOIDCClient(r *http.Request) (*auth.OIDCClient, error) {
+    if settings.OIDC.IssuerURL == "" {
+        return nil, apierr.NotFound("oidc_disabled", "Single sign-on is not configured")
+    }
+
+    oidcMu.Lock()
+    defer oidcMu.Unlock()
+    if oidcClient != nil {
+        return oidcClient, nil
+    }
+
+    cfg := settings.OIDC
+    client, err := auth.NewOIDCClient(r.Context(), cfg.IssuerURL, cfg.ClientID, cfg.ClientSecret, cfg.RedirectURL, cfg.Scopes)
+    if err != nil {
+        return nil, apierr.Upstream("Single sign-on is unavailable", err)
+    }
+    oidcClient = client
+    return client, nil
+}
+
+// Holds the state of the login the browser started, so a callback carrying
+// someone else's code and state is refused
+const oidcStateCookie = "oidc_state"
+
+// The cookie is scoped to the OIDC endpoints and sent along when the
+// frontend calls the callback from its own site
+func setOIDCStateCookie(w http.ResponseWriter, r *http.Request, value string, maxAge int) {
+    http.SetCookie(w, &http.Cookie{
+        Name:     oidcStateCookie,
+        Value:    value,
+        Path:     path.Dir(r.URL.Path),
+        MaxAge:   maxAge,
+        HttpOnly: true,
+        Secure:   strings.HasPrefix(settings.OIDC.RedirectURL, "https://"),
+        SameSite: http.SameSiteLaxMode,
+    })
+}
+
+// Starts an SSO login. The frontend sends the browser to authorizationUrl;
+// the state is also set in a cookie that HandleOIDCCallback checks.
+func HandleOIDCLogin(w http.ResponseWriter, r *http.Request) {
+    if !allow(w, r, "login:ip:"+clientIP(r), loginPerIP) {
+        return
+    }
+    client, err := getOIDCClient(r)
+    if err != nil {
+        writeError(w, r, err)
+        return
+    }
+
+    state, stateHash, err := auth.NewToken()
+    if err != nil {
+        writeError(w, r, fmt.Errorf("generating state: %w", err))
+        return
+    }
+    nonce, _, err := auth.NewToken()
+    if err != nil {
+        writeError(w, r, fmt.Errorf("generating nonce: %w", err))
+        return
+    }
+    verifier := auth.NewPKCEVerifier()
+
+    expiresAt := time.Now().Add(settings.OIDC.LoginTimeout)
+    if err := db.CreateOIDCLogin(r.Context(), stateHash, nonce, verifier, expiresAt); err != nil {
+        writeError(w, r, fmt.Errorf("storing OIDC login: %w", err))
+        return
+    }
+
+    setOIDCStateCookie(w, r, state, int(settings.OIDC.LoginTimeout.Seconds()))
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string]string{
+        "authorizationUrl": client.AuthCodeURL(state, nonce, verifier),
+        "state":            state,
+    })
+}
+
+// Completes an SSO login with the code and state the issuer redirected
+// back with, and answers like HandleLogin. The issuer is trusted to have
+// done any second factor, so TOTP is not asked for; identityUser never links
+// an issuer to an account that has TOTP enabled.
+func HandleOIDCCallback(w http.ResponseWriter, r *http.Request) {
+    if !allow(w, r, "login:ip:"+clientIP(r), loginPerIP) {
+        return
+    }
+
+    var req models.OIDCCallback
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        writeError(w, r, invalidBody())
+        return
+    }
+    if req.Code == "" || req.State == "" {
+        writeError(w, r, apierr.BadRequest("code_required", "Code and state are required"))
+        return
+    }
+
+    // Login CSRF: the state must belong to a login this browser started
+    cookie, err := r.Cookie(oidcStateCookie)
+    if err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(req.State)) != 1 {
+        writeError(w, r, db.ErrOIDCStateInvalid)
+        return
+    }
+    setOIDCStateCookie(w, r, "", -1)
+
+    client, err := getOIDCClient(r)
+    if err != nil {
+        writeError(w, r, err)
+        return
+    }
+    nonce, verifier, err := db.ClaimOIDCLogin(r.Context(), auth.HashToken(req.State))
+    if err != nil {
+        writeError(w, r, err)
+        return
+    }
+
+    identity, err := client.Exchange(r.Context(), req.Code, verifier, nonce)
+    if err != nil {
+        reqctx.Logger(r.Context()).Warn("OIDC login failed", "error", err)
+        writeError(w, r, apierr.Unauthorized("oidc_login_failed", "Single sign-on failed, please try again"))
+        return
+    }
+
+    user, ok := identityUser(w, r, identity)
+    if !ok {
+        return
+    }
+    if !accountUsable(w, r, user) {
+        return
+    }
+    reqctx.Logger(r.Context()).Info("OIDC login", "user_id", user.ID, "issuer", identity.Issuer)
+    issueSession(w, r, user)
+}
+
+// Finds the user an identity belongs to, linking or registering one as the
+// configuration allows. Writes the error response itself.
+func identityUser(w http.ResponseWriter, r *http.Request, identity *auth.OIDCIdentity) (*models.User, bool) {
+    user, err := db.GetUserByIdentity(r.Context(), identity.Issuer, identity.Subject)
+    if err == nil {
+        if err := db.TouchIdentity(r.Context(), identity.Issuer, identity.Subject, identity.Email); err != nil {
+            reqctx.Logger(r.Context()).Warn("Failed to record identity login", "user_id", user.ID, "error", err)
+        }
+        return user, true
+    }
+    if !errors.Is(err, db.ErrUserNotFound) {
+        writeError(w, r, fmt.Errorf("looking up identity: %w", err))
+        return nil, false
+    }
+
+    noAccount := apierr.New(http.StatusForbidden, "oidc_no_account", "No account is linked to this identity")
+    email, err := models.NormalizeEmail(identity.Email)
+    if err != nil {
+        writeError(w, r, noAccount)
+        return nil, false
+    }
+
+    if settings.OIDC.LinkByEmail && identity.EmailVerified {
+        user, err := db.GetUserByEmail(r.Context(), email)
+        if err == nil {
+            // An unverified account may have been registered by someone
+            // who does not own the address, to be handed the owner's logins
+            if user.EmailVerifiedAt == nil {
+                writeError(w, r, noAccount)
+                return nil, false
+            }
+            // Logins through the issuer skip TOTP, so linking would let the
+            // issuer stand in for the account's second factor
+            totp, err := db.GetTOTP(r.Context(), user.ID)
+            if err != nil && !errors.Is(err, db.ErrTOTPNotFound) {
+                writeError(w, r, fmt.Errorf("loading 2FA settings: %w", err))
+                return nil, false
+            }
+            if totp != nil && totp.EnabledAt != nil {
+                reqctx.Logger(r.Context()).Warn("Refused to link identity to a 2FA account", "user_id", user.ID, "issuer", identity.Issuer)
+                writeError(w, r, noAccount)
+                return nil, false
+            }
+            if err := db.LinkIdentity(r.Context(), user.ID, identity.Issuer, identity.Subject, email); err != nil {
+                writeError(w, r, fmt.Errorf("linking identity: %w", err))
+                return nil, false
+            }
+            reqctx.Logger(r.Context()).Info("Linked identity by email", "user_id", user.ID, "issuer", identity.Issuer)
+            return user, true
+        }
+        if !errors.Is(err, db.ErrUserNotFound) {
+            writeError(w, r, fmt.Errorf("looking up user: %w", err))
+            return nil, false
+        }
+    }
+
+    if !settings.OIDC.AutoRegister {
+        writeError(w, r, noAccount)
+        return nil, false
+    }
+
+    // The account gets a random password; a password login needs a reset first
+    password, _, err := auth.NewToken()
+    if err != nil {
+        writeError(w, r, fmt.Errorf("generating password: %w", err))
+        return nil, false
+    }
+    hash, ok := hashPassword(w, r, password)
+    if !ok {
+        return nil, false
+    }
+    user = &models.User{Email: email, Password: hash}
+    if err := db.CreateUserWithIdentity(r.Context(), user, identity.Issuer, identity.Subject, identity.EmailVerified); err != nil {
+        writeError(w, r, err)
+        return nil, false
+    }
+    reqctx.Logger(r.Context()).Info("Registered user from identity", "user_id", user.ID, "issuer", identity.Issuer)
+    if user.EmailVerifiedAt == nil {
+        sendVerificationEmail(r, user)
+    }
+    return user, true
+}
+
+func HandleListIdentities(w http.ResponseWriter, r *http.Request) {
+    identities, err := db.GetIdentities(r.Context(), currentUserID(r))
+    if err != nil {
+        writeError(w, r, fmt.Errorf("retrieving identities: %w", err))
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(identities)
+}
+
+func HandleDeleteIdentity(w http.ResponseWriter, r *http.Request) {
+    id, err := strconv.Atoi(r.PathValue("id"))
+    if err != nil {
+        writeError(w, r, apierr.BadRequest("invalid_id", "Identity id must be a number"))
+        return
+    }
+
+    if err := db.DeleteIdentity(r.Context(), currentUserID(r), id); err != nil {
+        writeError(w, r, err)
+        return
+    }
+    reqctx.Logger(r.Context()).Info("Identity unlinked", "identity_id", id)
+    w.WriteHeader(http.StatusNoContent)
+}
\ No newline at end of file
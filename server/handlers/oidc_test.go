@@ -0,0 +1,213 @@
+package handlers_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"server/auth"
+	"server/auth/oidctest"
+	"server/config"
+	"server/db"
+)
+
+// ssoLogin is a login started with /oidc/login
+type ssoLogin struct {
+    authURL string
+    state   string
+    cookie  *http.Cookie
+}
+
+func startSSO(t *testing.T, h http.Handler) ssoLogin {
+    t.Helper()
+    rec := call(t, h, "POST", "/oidc/login", "", nil)
+    if rec.Code != http.StatusOK {
+        t.Fatalf("oidc login: %d %s", rec.Code, rec.Body)
+    }
+    var resp struct {
+        AuthorizationURL string `json:"authorizationUrl"`
+        State            string `json:"state"`
+    }
+    decode(t, rec, &resp)
+    login := ssoLogin{authURL: resp.AuthorizationURL, state: resp.State}
+    for _, c := range rec.Result().Cookies() {
+        if c.Name == "oidc_state" {
+            login.cookie = c
+        }
+    }
+    if login.cookie == nil || !login.cookie.HttpOnly || login.cookie.SameSite != http.SameSiteLaxMode {
+        t.Fatalf("state cookie = %+v", login.cookie)
+    }
+    return login
+}
+
+// authorize follows the authorization URL, with query parameters changed as
+// given, and returns the code and state the provider redirects back with
+func authorize(t *testing.T, authURL string, change map[string]string) (code, state string) {
+    t.Helper()
+    u, err := url.Parse(authURL)
+    if err != nil {
+        t.Fatal(err)
+    }
+    q := u.Query()
+    for k, v := range change {
+        q.Set(k, v)
+    }
+    u.RawQuery = q.Encode()
+
+    client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+        return http.ErrUseLastResponse
+    }}
+    resp, err := client.Get(u.String())
+    if err != nil {
+        t.Fatal(err)
+    }
+    resp.Body.Close()
+    location, err := resp.Location()
+    if err != nil {
+        t.Fatalf("authorize: %d without redirect", resp.StatusCode)
+    }
+    return location.Query().Get("code"), location.Query().Get("state")
+}
+
+func finishSSO(t *testing.T, h http.Handler, code, state string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+    t.Helper()
+    return call(t, h, "POST", "/oidc/callback", "", map[string]string{"code": code, "state": state}, cookies...)
+}
+
+func TestOIDCLogin(t *testing.T) {
+    // The issuer URL is needed before the provider can serve
+    srv := httptest.NewUnstartedServer(nil)
+    provider, err := oidctest.NewProvider("http://"+srv.Listener.Addr().String(), "sso@example.com", true)
+    if err != nil {
+        t.Fatal(err)
+    }
+    srv.Config.Handler = provider
+    srv.Start()
+    defer srv.Close()
+
+    h, _ := setup(t, func(cfg *config.Config) {
+        cfg.OIDC.IssuerURL = provider.Issuer()
+        cfg.OIDC.ClientID = "stock-tracker"
+        cfg.OIDC.RedirectURL = "http://app.test/oidc/callback"
+        cfg.OIDC.AutoRegister = true
+        cfg.OIDC.LinkByEmail = true
+    })
+    email := func(name string) string {
+        return fmt.Sprintf("%s.%d@example.com", name, time.Now().UnixNano())
+    }
+
+    t.Run("code flow with PKCE and nonce", func(t *testing.T) {
+        hint := map[string]string{"login_hint": email("sso")}
+        for i := 0; i < 2; i++ {
+            login := startSSO(t, h)
+            code, state := authorize(t, login.authURL, hint)
+            if state != login.state {
+                t.Fatalf("provider returned state %q, want %q", state, login.state)
+            }
+            rec := finishSSO(t, h, code, state, login.cookie)
+            if rec.Code != http.StatusOK {
+                t.Fatalf("callback %d: %d %s", i, rec.Code, rec.Body)
+            }
+        }
+    })
+
+    t.Run("state not bound to the browser", func(t *testing.T) {
+        login := startSSO(t, h)
+        code, state := authorize(t, login.authURL, nil)
+        wantError(t, finishSSO(t, h, code, state), http.StatusBadRequest, "oidc_state_invalid")
+
+        // The attacker's own login, replayed in the victim's browser
+        other := startSSO(t, h)
+        wantError(t, finishSSO(t, h, code, state, other.cookie), http.StatusBadRequest, "oidc_state_invalid")
+    })
+
+    t.Run("nonce mismatch", func(t *testing.T) {
+        login := startSSO(t, h)
+        code, state := authorize(t, login.authURL, map[string]string{"nonce": "from-another-login"})
+        wantError(t, finishSSO(t, h, code, state, login.cookie), http.StatusUnauthorized, "oidc_login_failed")
+    })
+
+    t.Run("expired state", func(t *testing.T) {
+        login := startSSO(t, h)
+        code, state := authorize(t, login.authURL, nil)
+        _, err := db.Pool.Exec(context.Background(), `
+            UPDATE oidc_logins SET expires_at = NOW() - INTERVAL '1 second'
+            WHERE state_hash = $1`, auth.HashToken(state))
+        if err != nil {
+            t.Fatal(err)
+        }
+        wantError(t, finishSSO(t, h, code, state, login.cookie), http.StatusBadRequest, "oidc_state_invalid")
+    })
+
+    t.Run("links only verified accounts", func(t *testing.T) {
+        address := email("local")
+        rec := call(t, h, "POST", "/register", "", map[string]string{"email": address, "password": "Original-Password-1"})
+        if rec.Code != http.StatusCreated {
+            t.Fatalf("register: %d %s", rec.Code, rec.Body)
+        }
+        hint := map[string]string{"login_hint": address}
+
+        login := startSSO(t, h)
+        code, state := authorize(t, login.authURL, hint)
+        wantError(t, finishSSO(t, h, code, state, login.cookie), http.StatusForbidden, "oidc_no_account")
+
+        if _, err := db.Pool.Exec(context.Background(), `UPDATE users SET email_verified_at = NOW() WHERE email = $1`, address); err != nil {
+            t.Fatal(err)
+        }
+        login = startSSO(t, h)
+        code, state = authorize(t, login.authURL, hint)
+        if rec := finishSSO(t, h, code, state, login.cookie); rec.Code != http.StatusOK {
+            t.Fatalf("callback: %d %s", rec.Code, rec.Body)
+        }
+    })
+
+    t.Run("does not link accounts with 2FA", func(t *testing.T) {
+        address := email("totp")
+        rec := call(t, h, "POST", "/register", "", map[string]string{"email": address, "password": "Original-Password-1"})
+        if rec.Code != http.StatusCreated {
+            t.Fatalf("register: %d %s", rec.Code, rec.Body)
+        }
+        ctx := context.Background()
+        if _, err := db.Pool.Exec(ctx, `UPDATE users SET email_verified_at = NOW() WHERE email = $1`, address); err != nil {
+            t.Fatal(err)
+        }
+        user, err := db.GetUserByEmail(ctx, address)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if err := db.SaveTOTPSecret(ctx, user.ID, "JBSWY3DPEHPK3PXP"); err != nil {
+            t.Fatal(err)
+        }
+        if err := db.EnableTOTP(ctx, user.ID, 0, nil); err != nil {
+            t.Fatal(err)
+        }
+
+        login := startSSO(t, h)
+        code, state := authorize(t, login.authURL, map[string]string{"login_hint": address})
+        wantError(t, finishSSO(t, h, code, state, login.cookie), http.StatusForbidden, "oidc_no_account")
+
+        identities, err := db.GetIdentities(ctx, user.ID)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if len(identities) != 0 {
+            t.Errorf("identities = %+v, want none linked", identities)
+        }
+    })
+
+    t.Run("defaults neither link nor register", func(t *testing.T) {
+        h, _ := setup(t, func(cfg *config.Config) {
+            cfg.OIDC.IssuerURL = provider.Issuer()
+            cfg.OIDC.ClientID = "stock-tracker"
+            cfg.OIDC.RedirectURL = "http://app.test/oidc/callback"
+        })
+        login := startSSO(t, h)
+        code, state := authorize(t, login.authURL, map[string]string{"login_hint": email("unknown")})
+        wantError(t, finishSSO(t, h, code, state, login.cookie), http.StatusForbidden, "oidc_no_account")
+    })
+}
\ No newline at end of file
@@ -0,0 +1,225 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"server/apierr"
+	"server/auth"
+	"server/db"
+	"server/mail"
+	"server/models"
+	"server/reqctx"
+)
+
+func passwordPolicy() auth.PasswordPolicy {
+    return auth.PasswordPolicy{
+        MinLength:     settings.Password.MinLength,
+        MaxLength:     settings.Password.MaxLength,
+        RequireUpper:  settings.Password.RequireUpper,
+        RequireLower:  settings.Password.RequireLower,
+        RequireDigit:  settings.Password.RequireDigit,
+        RequireSymbol: settings.Password.RequireSymbol,
+    }
+}
+
+// Reports every broken policy rule against field
+func checkPassword(field, password, email string) error {
+    problems := passwordPolicy().Check(password, email)
+    if len(problems) == 0 {
+        return nil
+    }
+    fields := make([]apierr.FieldError, len(problems))
+    for i, p := range problems {
+        fields[i] = apierr.FieldError{Field: field, Message: p}
+    }
+    return apierr.Validation(fields...)
+}
+
+func hashPassword(w http.ResponseWriter, r *http.Request, password string) ([]byte, bool) {
+    release, ok := acquireHashSlot(w, r)
+    if !ok {
+        return nil, false
+    }
+    hash, err := auth.GenerateHash(password, auth.DefaultParams)
+    release()
+    if err != nil {
+        writeError(w, r, fmt.Errorf("hashing password: %w", err))
+        return nil, false
+    }
+    return []byte(hash), true
+}
+
+func HandleChangePassword(w http.ResponseWriter, r *http.Request) {
+    userID := currentUserID(r)
+    // A stolen token must not allow guessing the current password quickly
+    if !allow(w, r, "password:user:"+strconv.Itoa(userID), loginPerAccount) {
+        return
+    }
+
+    var req models.PasswordChange
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        writeError(w, r, invalidBody())
+        return
+    }
+
+    user, err := db.GetUserByID(r.Context(), userID)
+    if err != nil {
+        writeError(w, r, fmt.Errorf("loading user %d: %w", userID, err))
+        return
+    }
+
+    release, ok := acquireHashSlot(w, r)
+    if !ok {
+        return
+    }
+    match, err := auth.ComparePasswordAndHash(req.CurrentPassword, string(user.Password))
+    release()
+    if err != nil || !match {
+        writeError(w, r, apierr.Validation(apierr.FieldError{Field: "currentPassword", Message: "is incorrect"}))
+        return
+    }
+
+    if err := checkPassword("newPassword", req.NewPassword, user.Email); err != nil {
+        writeError(w, r, err)
+        return
+    }
+    hash, ok := hashPassword(w, r, req.NewPassword)
+    if !ok {
+        return
+    }
+
+    if err := db.UpdatePassword(r.Context(), userID, hash); err != nil {
+        writeError(w, r, fmt.Errorf("updating password: %w", err))
+        return
+    }
+    reqctx.Logger(r.Context()).Info("Password changed")
+
+    // Every other session and all API keys have just been revoked; this
+    // session continues with a fresh token
+    token, err := auth.GenerateToken(userID)
+    if err != nil {
+        writeError(w, r, fmt.Errorf("generating token: %w", err))
+        return
+    }
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string]string{"token": token})
+}
+
+// Always answers 202 so the response does not reveal whether an account
+// exists for the email.
+func HandleForgotPassword(w http.ResponseWriter, r *http.Request) {
+    if !allow(w, r, "reset:ip:"+clientIP(r), resetPerIP) {
+        return
+    }
+
+    var req models.EmailRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        writeError(w, r, invalidBody())
+        return
+    }
+    email := strings.TrimSpace(req.Email)
+    if email == "" {
+        writeError(w, r, apierr.Validation(apierr.FieldError{Field: "email", Message: "email is required"}))
+        return
+    }
+
+    accepted := func() {
+        w.Header().Set("Content-Type", "application/json")
+        w.WriteHeader(http.StatusAccepted)
+        json.NewEncoder(w).Encode(map[string]string{
+            "message": "If the account exists, a reset link has been sent",
+        })
+    }
+
+    // Silently drop excess requests rather than let anyone flood an inbox
+    retryAfter, err := limiter.Allow(r.Context(), "reset:account:"+strings.ToLower(email), resetPerAccount)
+    if err == nil && retryAfter > 0 {
+        accepted()
+        return
+    }
+
+    user, err := db.GetUserByEmail(r.Context(), email)
+    if err != nil || user.DisabledAt != nil {
+        accepted()
+        return
+    }
+
+    token, hash, err := auth.NewToken()
+    if err != nil {
+        writeError(w, r, fmt.Errorf("generating reset token: %w", err))
+        return
+    }
+    ttl := settings.Password.ResetTokenTTL
+    if err := db.CreatePasswordReset(r.Context(), user.ID, hash, time.Now().Add(ttl)); err != nil {
+        writeError(w, r, fmt.Errorf("storing reset token: %w", err))
+        return
+    }
+
+    msg := mail.Message{
+        To:      user.Email,
+        Subject: "Reset your password",
+        Body: fmt.Sprintf("Someone asked to reset the password for this account.\n\n"+
+            "Open this link within %s to choose a new password:\n%s/reset-password?token=%s\n\n"+
+            "If it was not you, ignore this email; your password stays unchanged.\n",
+            ttl, settings.Mail.AppURL, token),
+    }
+    if err := mailer.Send(r.Context(), msg); err != nil {
+        reqctx.Logger(r.Context()).Error("Failed to queue reset email", "user_id", user.ID, "error", err)
+    }
+    accepted()
+}
+
+func HandleResetPassword(w http.ResponseWriter, r *http.Request) {
+    if !allow(w, r, "reset:ip:"+clientIP(r), resetPerIP) {
+        return
+    }
+
+    var req models.PasswordReset
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        writeError(w, r, invalidBody())
+        return
+    }
+    if req.Token == "" {
+        writeError(w, r, db.ErrResetTokenInvalid)
+        return
+    }
+    tokenHash := auth.HashToken(req.Token)
+    // The owner is needed for the rule against using the email as password
+    ownerID, err := db.PasswordResetUser(r.Context(), tokenHash)
+    if err != nil {
+        writeError(w, r, err)
+        return
+    }
+    owner, err := db.GetUserByID(r.Context(), ownerID)
+    if err != nil {
+        writeError(w, r, fmt.Errorf("loading user %d: %w", ownerID, err))
+        return
+    }
+    if err := checkPassword("newPassword", req.NewPassword, owner.Email); err != nil {
+        writeError(w, r, err)
+        return
+    }
+
+    hash, ok := hashPassword(w, r, req.NewPassword)
+    if !ok {
+        return
+    }
+    userID, err := db.ResetPassword(r.Context(), tokenHash, hash)
+    if err != nil {
+        writeError(w, r, err)
+        return
+    }
+
+    // The reset proves control of the mailbox, so clear any login lockout
+    account := "login:account:" + strings.ToLower(strings.TrimSpace(owner.Email))
+    if err := limiter.Reset(r.Context(), account); err != nil {
+        reqctx.Logger(r.Context()).Warn("Failed to reset login failures", "error", err)
+    }
+    reqctx.Logger(r.Context()).Info("Password reset", "user_id", userID)
+    w.WriteHeader(http.StatusNoContent)
+}
\ No newline at end of file
@@ -0,0 +1,131 @@
+package handlers_test
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"server/mail/mailtest"
+)
+
+var resetLink = regexp.MustCompile(`/reset-password\?token=([A-Za-z0-9_-]+)`)
+
+// resetToken picks the token out of the last reset email sent to email
+func resetToken(t *testing.T, smtp *mailtest.Server, email string) string {
+    t.Helper()
+    msgs := smtp.Messages()
+    for i := len(msgs) - 1; i >= 0; i-- {
+        msg := msgs[i]
+        if len(msg.To) == 1 && strings.EqualFold(msg.To[0], email) && strings.Contains(msg.Data, "Subject: Reset your password") {
+            m := resetLink.FindStringSubmatch(msg.Data)
+            if m == nil {
+                t.Fatalf("no reset link in:\n%s", msg.Data)
+            }
+            return m[1]
+        }
+    }
+    t.Fatalf("no reset email for %s among %d messages", email, len(msgs))
+    return ""
+}
+
+func TestPasswordReset(t *testing.T) {
+    h, smtp := setup(t)
+
+    email := fmt.Sprintf("Reset.%d@Example.com", time.Now().UnixNano())
+    password := "Original-Password-1"
+    if rec := call(t, h, "POST", "/register", "", map[string]string{"email": email, "password": password}); rec.Code != http.StatusCreated {
+        t.Fatalf("register: %d %s", rec.Code, rec.Body)
+    }
+    session := login(t, h, email, password)
+
+    if rec := call(t, h, "POST", "/password/forgot", "", map[string]string{"email": email}); rec.Code != http.StatusAccepted {
+        t.Fatalf("forgot: %d %s", rec.Code, rec.Body)
+    }
+    token := resetToken(t, smtp, email)
+
+    // The owner's email is known from the token, so it cannot be the password
+    rec := call(t, h, "POST", "/password/reset", "", map[string]string{"token": token, "newPassword": email})
+    if rec.Code != http.StatusUnprocessableEntity || !strings.Contains(rec.Body.String(), "email address") {
+        t.Fatalf("reset to the email: %d %s", rec.Code, rec.Body)
+    }
+
+    newPassword := "Replaced-Password-2"
+    if rec := call(t, h, "POST", "/password/reset", "", map[string]string{"token": token, "newPassword": newPassword}); rec.Code != http.StatusNoContent {
+        t.Fatalf("reset: %d %s", rec.Code, rec.Body)
+    }
+    if rec := call(t, h, "POST", "/password/reset", "", map[string]string{"token": token, "newPassword": "Another-Password-3"}); rec.Code == http.StatusNoContent {
+        t.Fatal("reset token was accepted twice")
+    }
+    if rec := call(t, h, "GET", "/2fa", session, nil); rec.Code != http.StatusUnauthorized {
+        t.Fatalf("session from before the reset: %d, want 401", rec.Code)
+    }
+    if rec := call(t, h, "POST", "/login", "", map[string]string{"email": email, "password": password}); rec.Code == http.StatusOK {
+        t.Fatal("old password still logs in")
+    }
+    login(t, h, email, newPassword)
+}
+
+func TestChangePasswordRevokesOtherSessions(t *testing.T) {
+    h, _ := setup(t)
+
+    email := fmt.Sprintf("change.%d@example.com", time.Now().UnixNano())
+    password := "Original-Password-1"
+    if rec := call(t, h, "POST", "/register", "", map[string]string{"email": email, "password": password}); rec.Code != http.StatusCreated {
+        t.Fatalf("register: %d %s", rec.Code, rec.Body)
+    }
+    other := login(t, h, email, password)
+    current := login(t, h, email, password)
+
+    rec := call(t, h, "PUT", "/password", current, map[string]string{"currentPassword": password, "newPassword": "Replaced-Password-2"})
+    if rec.Code != http.StatusOK {
+        t.Fatalf("change: %d %s", rec.Code, rec.Body)
+    }
+    var resp struct {
+        Token string `json:"token"`
+    }
+    decode(t, rec, &resp)
+
+    for name, token := range map[string]string{"other": other, "current": current} {
+        if rec := call(t, h, "GET", "/2fa", token, nil); rec.Code != http.StatusUnauthorized {
+            t.Errorf("%s session: %d, want 401", name, rec.Code)
+        }
+    }
+    if rec := call(t, h, "GET", "/2fa", resp.Token, nil); rec.Code != http.StatusOK {
+        t.Errorf("token from the change: %d %s", rec.Code, rec.Body)
+    }
+}
+func TestPasswordChangeRevokesAPIKeys(t *testing.T) {
+    h, smtp := setup(t)
+    user := verifiedUser(t, h, "keys")
+
+    key := apiKey(t, h, user.Token, "admin")
+    if rec := call(t, h, "GET", "/transactions", key, nil); rec.Code != http.StatusOK {
+        t.Fatalf("key before the change: %d %s", rec.Code, rec.Body)
+    }
+    rec := call(t, h, "PUT", "/password", user.Token, map[string]string{"currentPassword": user.Password, "newPassword": "Replaced-Password-2"})
+    if rec.Code != http.StatusOK {
+        t.Fatalf("change: %d %s", rec.Code, rec.Body)
+    }
+    var resp struct {
+        Token string `json:"token"`
+    }
+    decode(t, rec, &resp)
+    if rec := call(t, h, "GET", "/transactions", key, nil); rec.Code != http.StatusUnauthorized {
+        t.Errorf("key after the change: %d, want 401", rec.Code)
+    }
+
+    key = apiKey(t, h, resp.Token, "read")
+    if rec := call(t, h, "POST", "/password/forgot", "", map[string]string{"email": user.Email}); rec.Code != http.StatusAccepted {
+        t.Fatalf("forgot: %d %s", rec.Code, rec.Body)
+    }
+    token := resetToken(t, smtp, user.Email)
+    if rec := call(t, h, "POST", "/password/reset", "", map[string]string{"token": token, "newPassword": "Another-Password-3"}); rec.Code != http.StatusNoContent {
+        t.Fatalf("reset: %d %s", rec.Code, rec.Body)
+    }
+    if rec := call(t, h, "GET", "/transactions", key, nil); rec.Code != http.StatusUnauthorized {
+        t.Errorf("key after the reset: %d, want 401", rec.Code)
+    }
+}
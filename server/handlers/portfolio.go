@@ -0,0 +1,371 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"server/apierr"
+	"server/db"
+	"server/models"
+	"server/reqctx"
+	"server/utils"
+)
+
+// Zero on public routes, where there is no principal
+func currentUserID(r *http.Request) int {
+    if p := reqctx.CurrentPrincipal(r.Context()); p != nil {
+        return p.UserID
+    }
+    return 0
+}
+
+// Path and query parameters are both accepted while the unversioned routes
+// are kept as aliases.
+func requestParam(r *http.Request, pathName, queryName string) string {
+    if v := r.PathValue(pathName); v != "" {
+        return v
+    }
+    return r.URL.Query().Get(queryName)
+}
+
+// Resolves the {id} path segment or "portfolio" query parameter to a portfolio
+// the caller can reach, falling back to their default portfolio. Reads need
+// viewer access to a shared portfolio and anything else editor access.
+func resolvePortfolio(r *http.Request) (*models.Portfolio, error) {
+    need := models.AccessEditor
+    if r.Method == "GET" {
+        need = models.AccessViewer
+    }
+    return resolvePortfolioFor(r, need)
+}
+
+func resolvePortfolioFor(r *http.Request, need string) (*models.Portfolio, error) {
+    userID := currentUserID(r)
+
+    idStr := requestParam(r, "id", "portfolio")
+    if idStr == "" {
+        return db.GetDefaultPortfolio(r.Context(), userID)
+    }
+
+    id, err := strconv.Atoi(idStr)
+    if err != nil {
+        return nil, db.ErrPortfolioNotFound
+    }
+
+    p, err := db.GetPortfolioAccess(r.Context(), id, userID)
+    if err != nil {
+        return nil, err
+    }
+    if err := checkAccess(p, need); err != nil {
+        return nil, err
+    }
+    return p, nil
+}
+
+func checkAccess(p *models.Portfolio, need string) error {
+    if models.AccessAtLeast(p.Access, need) {
+        return nil
+    }
+    if need == models.AccessOwner {
+        return apierr.New(http.StatusForbidden, "not_portfolio_owner", "Only the owner can do this")
+    }
+    return apierr.New(http.StatusForbidden, "portfolio_read_only", "You have read-only access to this portfolio")
+}
+
+// Best effort: a failure is logged rather than undoing the change
+func logActivity(r *http.Request, portfolioID int, action string, details interface{}) {
+    if err := db.LogActivity(r.Context(), portfolioID, currentUserID(r), action, details); err != nil {
+        reqctx.Logger(r.Context()).Warn("Failed to log portfolio activity", "portfolio_id", portfolioID, "action", action, "error", err)
+    }
+}
+
+func HandleListPortfolios(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "application/json")
+
+    // Make sure every user has at least the default portfolio
+    if _, err := db.GetDefaultPortfolio(r.Context(), currentUserID(r)); err != nil {
+        writeError(w, r, err)
+        return
+    }
+
+    portfolios, err := db.GetPortfolios(r.Context(), currentUserID(r))
+    if err != nil {
+        writeError(w, r, fmt.Errorf("retrieving portfolios: %w", err))
+        return
+    }
+    json.NewEncoder(w).Encode(portfolios)
+}
+
+func HandleCreatePortfolio(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "application/json")
+
+    var p models.Portfolio
+    if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+        writeError(w, r, invalidBody())
+        return
+    }
+
+    p.Name = strings.TrimSpace(p.Name)
+    if p.Name == "" {
+        writeError(w, r, apierr.Validation(apierr.FieldError{Field: "name", Message: "name is required"}))
+        return
+    }
+    p.Currency = strings.ToUpper(strings.TrimSpace(p.Currency))
+    if p.Currency == "" {
+        p.Currency = "PLN"
+    }
+    if len(p.Currency) != 3 {
+        writeError(w, r, apierr.Validation(apierr.FieldError{Field: "currency", Message: "currency must be a 3-letter code"}))
+        return
+    }
+    p.UserID = currentUserID(r)
+
+    if err := db.CreatePortfolio(r.Context(), &p); err != nil {
+        writeError(w, r, fmt.Errorf("creating portfolio: %w", err))
+        return
+    }
+
+    w.WriteHeader(http.StatusCreated)
+    json.NewEncoder(w).Encode(p)
+}
+
+func HandleGetPortfolio(w http.ResponseWriter, r *http.Request) {
+    portfolio, err := resolvePortfolio(r)
+    if err != nil {
+        writeError(w, r, err)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(portfolio)
+}
+
+func HandleGetPosition(w http.ResponseWriter, r *http.Request) {
+    portfolio, err := resolvePortfolio(r)
+    if err != nil {
+        writeError(w, r, err)
+        return
+    }
+
+    symbol := strings.ToUpper(r.PathValue("symbol"))
+    stocks, err := db.GetStocks(r.Context(), portfolio.ID)
+    if err != nil {
+        writeError(w, r, fmt.Errorf("retrieving stocks: %w", err))
+        return
+    }
+
+    var position *models.Stock
+    for i := range stocks {
+        if stocks[i].Symbol == symbol {
+            position = &stocks[i]
+            break
+        }
+    }
+    if position == nil {
+        writeError(w, r, db.ErrPositionNotFound)
+        return
+    }
+
+    txs, err := db.GetTransactions(r.Context(), portfolio.ID)
+    if err != nil {
+        writeError(w, r, fmt.Errorf("retrieving transactions: %w", err))
+        return
+    }
+    history := make([]models.Transaction, 0)
+    for _, t := range txs {
+        if t.Symbol == symbol {
+            history = append(history, t)
+        }
+    }
+
+    actions, err := db.GetCorporateActions(r.Context(), portfolio.ID, symbol)
+    if err != nil {
+        writeError(w, r, fmt.Errorf("retrieving corporate actions: %w", err))
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "position":         position,
+        "transactions":     history,
+        "corporateActions": actions,
+    })
+}
+
+func HandleListCash(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "application/json")
+
+    portfolio, err := resolvePortfolio(r)
+    if err != nil {
+        writeError(w, r, err)
+        return
+    }
+
+    ops, err := db.GetCashOperations(r.Context(), portfolio.ID)
+    if err != nil {
+        writeError(w, r, fmt.Errorf("retrieving cash operations: %w", err))
+        return
+    }
+
+    balances, err := db.GetCashBalances(r.Context(), portfolio.ID)
+    if err != nil {
+        writeError(w, r, fmt.Errorf("retrieving cash balances: %w", err))
+        return
+    }
+
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "balances":   balances,
+        "operations": ops,
+    })
+}
+
+func HandleCreateCashOperation(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "application/json")
+
+    portfolio, err := resolvePortfolio(r)
+    if err != nil {
+        writeError(w, r, err)
+        return
+    }
+
+    var op models.CashOperation
+    if err := json.NewDecoder(r.Body).Decode(&op); err != nil {
+        writeError(w, r, invalidBody())
+        return
+    }
+
+    if err := op.Validate(time.Now()); err != nil {
+        writeError(w, r, err)
+        return
+    }
+    if op.Currency == "" {
+        op.Currency = portfolio.Currency
+    }
+    op.PortfolioID = portfolio.ID
+    op.Source = models.SourceManual
+
+    if err := db.CreateCashOperation(r.Context(), &op); err != nil {
+        writeError(w, r, fmt.Errorf("creating cash operation: %w", err))
+        return
+    }
+    logActivity(r, portfolio.ID, models.ActivityCashCreated, op)
+
+    w.WriteHeader(http.StatusCreated)
+    json.NewEncoder(w).Encode(op)
+}
+
+func HandlePortfolioValue(w http.ResponseWriter, r *http.Request) {
+    portfolio, err := resolvePortfolio(r)
+    if err != nil {
+        writeError(w, r, err)
+        return
+    }
+
+    valuation, err := valuePortfolio(r, portfolio)
+    if err != nil {
+        writeError(w, r, err)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(valuation)
+}
+
+// Prices positions at current quotes and converts everything to the
+// portfolio currency. Positions that cannot be priced and cash that cannot
+// be converted carry an error and are left out of the totals.
+func valuePortfolio(r *http.Request, portfolio *models.Portfolio) (*models.PortfolioValuation, error) {
+    stocks, err := db.GetStocks(r.Context(), portfolio.ID)
+    if err != nil {
+        return nil, fmt.Errorf("retrieving stocks: %w", err)
+    }
+
+    balances, err := db.GetCashBalances(r.Context(), portfolio.ID)
+    if err != nil {
+        return nil, fmt.Errorf("retrieving cash balances: %w", err)
+    }
+
+    rates := newRateCache(r.Context(), portfolio.Currency)
+    valuation := &models.PortfolioValuation{
+        PortfolioID: portfolio.ID,
+        Currency:    portfolio.Currency,
+        Positions:   make([]models.PositionValue, 0, len(stocks)),
+        Cash:        balances,
+    }
+
+    for _, s := range stocks {
+        pv := models.PositionValue{
+            Symbol:       s.Symbol,
+            Shares:       s.Shares,
+            AveragePrice: s.Price,
+            Currency:     s.Currency,
+        }
+
+        price, err := fetchQuote(r.Context(), utils.StooqSymbol(s.Symbol))
+        if err != nil {
+            reqctx.Logger(r.Context()).Warn("Failed to fetch price", "symbol", s.Symbol, "error", err)
+            pv.Error = "price unavailable"
+            valuation.Positions = append(valuation.Positions, pv)
+            continue
+        }
+
+        rate, err := rates.get(s.Currency)
+        if err != nil {
+            reqctx.Logger(r.Context()).Warn("Failed to fetch exchange rate", "currency", s.Currency, "error", err)
+            pv.Error = "exchange rate unavailable"
+            valuation.Positions = append(valuation.Positions, pv)
+            continue
+        }
+
+        pv.CurrentPrice = utils.RoundToTwo(price)
+        pv.Value = utils.RoundToTwo(price * s.Shares)
+        pv.ValueBase = utils.RoundToTwo(price * s.Shares * rate)
+        valuation.PositionsValue += pv.ValueBase
+        valuation.Positions = append(valuation.Positions, pv)
+    }
+
+    for i, b := range balances {
+        rate, err := rates.get(b.Currency)
+        if err != nil {
+            reqctx.Logger(r.Context()).Warn("Failed to fetch exchange rate", "currency", b.Currency, "error", err)
+            balances[i].Error = "exchange rate unavailable"
+            continue
+        }
+        valuation.CashValue += b.Balance * rate
+    }
+
+    valuation.PositionsValue = utils.RoundToTwo(valuation.PositionsValue)
+    valuation.CashValue = utils.RoundToTwo(valuation.CashValue)
+    valuation.TotalValue = utils.RoundToTwo(valuation.PositionsValue + valuation.CashValue)
+
+    return valuation, nil
+}
+
+// Looks up Stooq FX quotes (e.g. "usdpln") once per request
+type rateCache struct {
+    ctx   context.Context
+    base  string
+    rates map[string]float64
+}
+
+func newRateCache(ctx context.Context, base string) *rateCache {
+    return &rateCache{ctx: ctx, base: base, rates: map[string]float64{base: 1}}
+}
+
+func (c *rateCache) get(currency string) (float64, error) {
+    if rate, ok := c.rates[currency]; ok {
+        return rate, nil
+    }
+
+    rate, err := fetchQuote(c.ctx, strings.ToLower(currency + c.base))
+    if err != nil {
+        return 0, err
+    }
+    c.rates[currency] = rate
+    return rate, nil
+}
\ No newline at end of file
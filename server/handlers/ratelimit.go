@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"server/apierr"
+	"server/auth"
+	"server/ratelimit"
+	"server/reqctx"
+)
+
+var (
+    limiter = ratelimit.New(ratelimit.NewMemoryStore())
+
+    loginPerIP, loginPerAccount, registerPerIP    ratelimit.Limit
+    resetPerIP, resetPerAccount, verifyPerAccount ratelimit.Limit
+    sharePerIP                                    ratelimit.Limit
+)
+
+func init() {
+    configureLimits()
+}
+
+// SetLimiter replaces the default in-memory limiter, e.g. with one backed by
+// Postgres when several instances run behind a load balancer.
+func SetLimiter(l *ratelimit.Limiter) {
+    limiter = l
+}
+
+func configureLimits() {
+    // Validated when the config was loaded
+    loginPerIP, _ = ratelimit.ParseLimit(settings.RateLimit.LoginPerIP)
+    loginPerAccount, _ = ratelimit.ParseLimit(settings.RateLimit.LoginPerAccount)
+    registerPerIP, _ = ratelimit.ParseLimit(settings.RateLimit.RegisterPerIP)
+    resetPerIP, _ = ratelimit.ParseLimit(settings.RateLimit.ResetPerIP)
+    resetPerAccount, _ = ratelimit.ParseLimit(settings.RateLimit.ResetPerAccount)
+    verifyPerAccount, _ = ratelimit.ParseLimit(settings.RateLimit.VerifyPerAccount)
+    sharePerIP, _ = ratelimit.ParseLimit(settings.RateLimit.SharePerIP)
+}
+
+func lockoutPolicy() ratelimit.LockoutPolicy {
+    return ratelimit.LockoutPolicy{
+        Threshold: settings.RateLimit.LockoutThreshold,
+        Base:      settings.RateLimit.LockoutBase,
+        Max:       settings.RateLimit.LockoutMax,
+        Window:    settings.RateLimit.LockoutWindow,
+    }
+}
+
+// Only trusts X-Forwarded-For behind a proxy, and then only the last entry,
+// which is the one the proxy itself appended.
+func clientIP(r *http.Request) string {
+    if settings.RateLimit.TrustProxy {
+        if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+            parts := strings.Split(fwd, ",")
+            return strings.TrimSpace(parts[len(parts)-1])
+        }
+    }
+    host, _, err := net.SplitHostPort(r.RemoteAddr)
+    if err != nil {
+        return r.RemoteAddr
+    }
+    return host
+}
+
+// Takes a token for key and writes a 429 if there is none. Limiter failures
+// let the request through so a store outage does not lock everyone out.
+func allow(w http.ResponseWriter, r *http.Request, key string, limit ratelimit.Limit) bool {
+    retryAfter, err := limiter.Allow(r.Context(), key, limit)
+    if err != nil {
+        reqctx.Logger(r.Context()).Warn("Rate limiter unavailable", "error", err)
+        return true
+    }
+    if retryAfter > 0 {
+        writeTooManyRequests(w, r, retryAfter, "rate_limited", "Too many requests, try again later")
+        return false
+    }
+    return true
+}
+
+func writeTooManyRequests(w http.ResponseWriter, r *http.Request, retryAfter time.Duration, code, detail string) {
+    seconds := int(retryAfter.Round(time.Second) / time.Second)
+    if seconds < 1 {
+        seconds = 1
+    }
+    w.Header().Set("Retry-After", strconv.Itoa(seconds))
+    writeError(w, r, apierr.New(http.StatusTooManyRequests, code, detail))
+}
+
+var errServerBusy = apierr.New(http.StatusServiceUnavailable, "server_busy", "Server is busy, try again shortly")
+
+// Waits for an Argon2 slot, giving up after auth.hash_wait_timeout
+func hashSlot(r *http.Request) (func(), error) {
+    ctx, cancel := context.WithTimeout(r.Context(), settings.Auth.HashWaitTimeout)
+    defer cancel()
+
+    release, err := auth.AcquireHashSlot(ctx)
+    if err != nil {
+        return nil, errServerBusy
+    }
+    return release, nil
+}
+
+// Like hashSlot, but answers 503 itself if the server stays saturated
+func acquireHashSlot(w http.ResponseWriter, r *http.Request) (func(), bool) {
+    release, err := hashSlot(r)
+    if err != nil {
+        w.Header().Set("Retry-After", "1")
+        writeError(w, r, err)
+        return nil, false
+    }
+    return release, true
+}
\ No newline at end of file
@@ -0,0 +1,19 @@
+package handlers
+
+import (
+	"net/http"
+
+	"server/config"
+)
+
+// Settings used by the handlers; replaced by Configure at startup
+var settings = config.Default()
+
+// Client for the quote provider
+var marketClient = &http.Client{Timeout: settings.Market.Timeout}
+
+func Configure(cfg *config.Config) {
+    settings = cfg
+    marketClient = &http.Client{Timeout: cfg.Market.Timeout}
+    configureLimits()
+}
\ No newline at end of file
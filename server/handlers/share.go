@@ -0,0 +1,230 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"server/apierr"
+	"server/auth"
+	"server/db"
+	"server/models"
+	"server/reqctx"
+	"server/utils"
+)
+
+const (
+    maxShareLinksPerPortfolio = 20
+    shareTokenPrefix          = "shr_"
+)
+
+func HandleListShareLinks(w http.ResponseWriter, r *http.Request) {
+    portfolio, err := resolvePortfolioFor(r, models.AccessOwner)
+    if err != nil {
+        writeError(w, r, err)
+        return
+    }
+
+    links, err := db.GetShareLinks(r.Context(), portfolio.ID)
+    if err != nil {
+        writeError(w, r, fmt.Errorf("retrieving share links: %w", err))
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(links)
+}
+
+// Creates a link and returns its token in full; only the hash is stored.
+// Amounts and cost basis are hidden unless the request says otherwise.
+func HandleCreateShareLink(w http.ResponseWriter, r *http.Request) {
+    portfolio, err := resolvePortfolioFor(r, models.AccessOwner)
+    if err != nil {
+        writeError(w, r, err)
+        return
+    }
+
+    l := models.ShareLink{HideAmounts: true, HideCostBasis: true}
+    if err := json.NewDecoder(r.Body).Decode(&l); err != nil {
+        writeError(w, r, invalidBody())
+        return
+    }
+    if err := l.Validate(time.Now()); err != nil {
+        writeError(w, r, err)
+        return
+    }
+
+    count, err := db.CountShareLinks(r.Context(), portfolio.ID)
+    if err != nil {
+        writeError(w, r, fmt.Errorf("counting share links: %w", err))
+        return
+    }
+    if count >= maxShareLinksPerPortfolio {
+        writeError(w, r, apierr.Conflict("too_many_share_links",
+            fmt.Sprintf("At most %d share links are allowed per portfolio; revoke one first", maxShareLinksPerPortfolio)))
+        return
+    }
+
+    token, _, err := auth.NewToken()
+    if err != nil {
+        writeError(w, r, fmt.Errorf("generating share token: %w", err))
+        return
+    }
+    token = shareTokenPrefix + token
+    l.PortfolioID = portfolio.ID
+    l.CreatedBy = currentUserID(r)
+    l.Prefix = token[:len(shareTokenPrefix)+6]
+
+    if err := db.CreateShareLink(r.Context(), &l, auth.HashToken(token)); err != nil {
+        writeError(w, r, fmt.Errorf("storing share link: %w", err))
+        return
+    }
+    logActivity(r, portfolio.ID, models.ActivityShareLinkCreated, l)
+
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(http.StatusCreated)
+    json.NewEncoder(w).Encode(models.NewShareLink{
+        ShareLink: l,
+        Token:     token,
+        URL:       settings.Mail.AppURL + "/shared/" + token,
+    })
+}
+
+func HandleDeleteShareLink(w http.ResponseWriter, r *http.Request) {
+    portfolio, err := resolvePortfolioFor(r, models.AccessOwner)
+    if err != nil {
+        writeError(w, r, err)
+        return
+    }
+    id, err := strconv.Atoi(r.PathValue("linkID"))
+    if err != nil {
+        writeError(w, r, apierr.BadRequest("invalid_id", "Share link id must be a number"))
+        return
+    }
+
+    if err := db.DeleteShareLink(r.Context(), portfolio.ID, id); err != nil {
+        writeError(w, r, err)
+        return
+    }
+    logActivity(r, portfolio.ID, models.ActivityShareLinkRevoked, map[string]interface{}{"id": id})
+    w.WriteHeader(http.StatusNoContent)
+}
+
+// Public. The token is the only credential, so every way it can fail looks
+// the same from outside, and it is kept out of the access log.
+func HandleViewSharedPortfolio(w http.ResponseWriter, r *http.Request) {
+    if info := reqctx.RequestInfo(r.Context()); info != nil {
+        info.Secret = true
+    }
+    w.Header().Set("Cache-Control", "no-store")
+    w.Header().Set("Referrer-Policy", "no-referrer")
+    w.Header().Set("X-Robots-Tag", "noindex")
+
+    if !allow(w, r, "share:ip:"+clientIP(r), sharePerIP) {
+        return
+    }
+
+    link, portfolio, err := loadShareLink(r, r.PathValue("token"))
+    if errors.Is(err, db.ErrShareLinkNotFound) {
+        writeError(w, r, err)
+        return
+    }
+    if err != nil {
+        writeError(w, r, fmt.Errorf("loading share link: %w", err))
+        return
+    }
+
+    valuation, err := valuePortfolio(r, portfolio)
+    if err != nil {
+        writeError(w, r, err)
+        return
+    }
+    if err := db.TouchShareLink(r.Context(), link.ID); err != nil {
+        reqctx.Logger(r.Context()).Warn("Failed to record share link use", "share_link_id", link.ID, "error", err)
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(redactValuation(portfolio, valuation, link))
+}
+
+// Links stop working once expired or when the owner's account is disabled
+func loadShareLink(r *http.Request, token string) (*models.ShareLink, *models.Portfolio, error) {
+    if len(token) <= len(shareTokenPrefix) || token[:len(shareTokenPrefix)] != shareTokenPrefix {
+        return nil, nil, db.ErrShareLinkNotFound
+    }
+
+    link, err := db.GetShareLinkByHash(r.Context(), auth.HashToken(token))
+    if err != nil {
+        return nil, nil, err
+    }
+    if link.Expired(time.Now()) {
+        return nil, nil, db.ErrShareLinkNotFound
+    }
+
+    portfolio, err := db.GetPortfolio(r.Context(), link.PortfolioID)
+    if errors.Is(err, db.ErrPortfolioNotFound) {
+        return nil, nil, db.ErrShareLinkNotFound
+    }
+    if err != nil {
+        return nil, nil, err
+    }
+
+    owner, err := db.GetUserByID(r.Context(), portfolio.UserID)
+    if errors.Is(err, db.ErrUserNotFound) || (err == nil && owner.DisabledAt != nil) {
+        return nil, nil, db.ErrShareLinkNotFound
+    }
+    if err != nil {
+        return nil, nil, err
+    }
+    return link, portfolio, nil
+}
+
+func redactValuation(portfolio *models.Portfolio, v *models.PortfolioValuation, link *models.ShareLink) models.SharedPortfolio {
+    out := models.SharedPortfolio{
+        Name:          portfolio.Name,
+        Currency:      portfolio.Currency,
+        HideAmounts:   link.HideAmounts,
+        HideCostBasis: link.HideCostBasis,
+        Positions:     make([]models.SharedPosition, 0, len(v.Positions)),
+        AsOf:          time.Now().UTC(),
+    }
+    if v.TotalValue > 0 {
+        out.CashWeight = utils.RoundToTwo(v.CashValue / v.TotalValue * 100)
+    }
+    if !link.HideAmounts {
+        out.TotalValue = &v.TotalValue
+        out.Cash = v.Cash
+    }
+
+    for _, p := range v.Positions {
+        sp := models.SharedPosition{Symbol: p.Symbol, Currency: p.Currency, Error: p.Error}
+        if p.Error == "" {
+            if v.TotalValue > 0 {
+                sp.Weight = roundedPtr(p.ValueBase / v.TotalValue * 100)
+            }
+            if !link.HideCostBasis && p.AveragePrice > 0 {
+                sp.GainPercent = roundedPtr((p.CurrentPrice/p.AveragePrice - 1) * 100)
+            }
+        }
+        if !link.HideAmounts {
+            sp.Shares = &p.Shares
+            if p.Error == "" {
+                sp.CurrentPrice = roundedPtr(p.CurrentPrice)
+                sp.Value = roundedPtr(p.Value)
+            }
+            if !link.HideCostBasis {
+                sp.AveragePrice = roundedPtr(p.AveragePrice)
+            }
+        }
+        out.Positions = append(out.Positions, sp)
+    }
+    return out
+}
+
+func roundedPtr(f float64) *float64 {
+    f = utils.RoundToTwo(f)
+    return &f
+}
\ No newline at end of file
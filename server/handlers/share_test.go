@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"server/models"
+)
+
+func TestRedactValuation(t *testing.T) {
+    portfolio := &models.Portfolio{ID: 1, Name: "Main", Currency: "PLN"}
+    valuation := &models.PortfolioValuation{
+        Currency: "PLN",
+        Positions: []models.PositionValue{
+            {Symbol: "AAPL.US", Shares: 10, AveragePrice: 100, CurrentPrice: 150, Currency: "USD", Value: 1500, ValueBase: 6000},
+            {Symbol: "CDR.PL", Shares: 5, AveragePrice: 120, Currency: "PLN", Error: "price unavailable"},
+        },
+        Cash:           []models.CashBalance{{Currency: "PLN", Balance: 4000}},
+        PositionsValue: 6000,
+        CashValue:      4000,
+        TotalValue:     10000,
+    }
+
+    tests := []struct {
+        hideAmounts, hideCostBasis bool
+    }{
+        {false, false},
+        {false, true},
+        {true, false},
+        {true, true},
+    }
+    for _, tt := range tests {
+        link := &models.ShareLink{HideAmounts: tt.hideAmounts, HideCostBasis: tt.hideCostBasis}
+        out := redactValuation(portfolio, valuation, link)
+        name := func(field string) string {
+            return fmt.Sprintf("%s with hideAmounts=%v hideCostBasis=%v", field, tt.hideAmounts, tt.hideCostBasis)
+        }
+        check := func(field string, present, want bool) {
+            t.Helper()
+            if present != want {
+                t.Errorf("%s: present = %v, want %v", name(field), present, want)
+            }
+        }
+
+        showAmounts := !tt.hideAmounts
+        showCost := !tt.hideCostBasis
+        check("totalValue", out.TotalValue != nil, showAmounts)
+        check("cash", len(out.Cash) > 0, showAmounts)
+        if out.CashWeight != 40 {
+            t.Errorf("%s = %v, want 40", name("cashWeight"), out.CashWeight)
+        }
+
+        priced, failed := out.Positions[0], out.Positions[1]
+        check("shares", priced.Shares != nil, showAmounts)
+        check("currentPrice", priced.CurrentPrice != nil, showAmounts)
+        check("value", priced.Value != nil, showAmounts)
+        check("averagePrice", priced.AveragePrice != nil, showAmounts && showCost)
+        check("gainPercent", priced.GainPercent != nil, showCost)
+        check("weight", priced.Weight != nil, true)
+        if priced.GainPercent != nil && *priced.GainPercent != 50 {
+            t.Errorf("%s = %v, want 50", name("gainPercent"), *priced.GainPercent)
+        }
+
+        check("unpriced shares", failed.Shares != nil, showAmounts)
+        check("unpriced currentPrice", failed.CurrentPrice != nil, false)
+        check("unpriced value", failed.Value != nil, false)
+        check("unpriced gainPercent", failed.GainPercent != nil, false)
+        check("unpriced weight", failed.Weight != nil, false)
+
+        // Whatever is hidden must not reach the response either
+        data, err := json.Marshal(out)
+        if err != nil {
+            t.Fatal(err)
+        }
+        for _, key := range []string{`"totalValue"`, `"cash"`, `"shares"`, `"value"`, `"currentPrice"`} {
+            check("JSON "+key, strings.Contains(string(data), key), showAmounts)
+        }
+        check(`JSON "averagePrice"`, strings.Contains(string(data), `"averagePrice"`), showAmounts && showCost)
+        check(`JSON "gainPercent"`, strings.Contains(string(data), `"gainPercent"`), showCost)
+    }
+}
@@ -1,74 +1,95 @@
 package handlers
 
 import (
+	"context"
 	"encoding/csv"
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"server/apierr"
+	"server/db"
+	"server/metrics"
 	"server/models"
+	"server/reqctx"
 	"server/utils"
-	"strconv"
 )
 
 func HandleCurrentPrice(w http.ResponseWriter, r *http.Request) {
-    symbol := r.URL.Query().Get("symbol")
+    symbol := requestParam(r, "symbol", "symbol")
     if symbol == "" {
-        http.Error(w, "Symbol is required", http.StatusBadRequest)
+        writeError(w, r, apierr.Validation(apierr.FieldError{Field: "symbol", Message: "symbol is required"}))
         return
     }
 
-    resp, err := http.Get(fmt.Sprintf("https://stooq.pl/q/l/?s=%s", symbol))
+    price, err := fetchQuote(r.Context(), symbol)
     if err != nil {
-        log.Printf("Failed to fetch current price: %v", err)
-        http.Error(w, "Failed to fetch current price", http.StatusInternalServerError)
+        writeError(w, r, apierr.Upstream("Failed to fetch current price", err))
         return
     }
-    defer resp.Body.Close()
 
-    reader := csv.NewReader(resp.Body)
+    quote := models.StockQuote{
+        Symbol: symbol,
+        Price:  utils.RoundToTwo(price),
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(quote)
+}
+
+// Sends a GET for path, with the symbol escaped into its query, to the
+// quote provider
+func getMarketData(ctx context.Context, path, symbol string) (*http.Response, error) {
+    req, err := http.NewRequestWithContext(ctx, "GET",
+        fmt.Sprintf(path, settings.Market.StooqURL, url.QueryEscape(symbol)), nil)
     if err != nil {
-        log.Printf("Failed to read header: %v", err)
-        http.Error(w, "Failed to read data", http.StatusInternalServerError)
-        return
+        return nil, err
     }
+    return marketClient.Do(req)
+}
+
+func fetchQuote(ctx context.Context, symbol string) (price float64, err error) {
+    start := time.Now()
+    defer func() { metrics.ObserveUpstream("stooq", start, err) }()
 
-    record, err := reader.Read() // Read single row
+    resp, err := getMarketData(ctx, "%s/q/l/?s=%s", symbol)
     if err != nil {
-        log.Printf("Failed to read price data: %v", err)
-        http.Error(w, "Failed to read price data", http.StatusInternalServerError)
-        return
+        return 0, err
     }
+    defer resp.Body.Close()
 
-    price, err := strconv.ParseFloat(record[6], 64) // Price is in 7th column
+    record, err := csv.NewReader(resp.Body).Read() // Read single row
     if err != nil {
-        log.Printf("Failed to parse price: %v", err)
-        http.Error(w, "Failed to parse price", http.StatusInternalServerError)
-        return
+        return 0, fmt.Errorf("failed to read price data: %v", err)
+    }
+    if len(record) < 7 {
+        return 0, fmt.Errorf("unexpected quote format for %s", symbol)
     }
 
-    quote := models.StockQuote{
-        Symbol: symbol,
-        Price:  utils.RoundToTwo(price),
+    price, err = strconv.ParseFloat(record[6], 64) // Price is in 7th column
+    if err != nil {
+        return 0, fmt.Errorf("failed to parse price: %v", err)
     }
 
-    w.Header().Set("Content-Type", "application/json")
-    w.Header().Set("Access-Control-Allow-Origin", "*")
-    json.NewEncoder(w).Encode(quote)
+    return price, nil
 }
 
 func HandleStockPrice(w http.ResponseWriter, r *http.Request) {
-    symbol := r.URL.Query().Get("symbol")
+    symbol := requestParam(r, "symbol", "symbol")
     if symbol == "" {
-        http.Error(w, "Symbol is required", http.StatusBadRequest)
+        writeError(w, r, apierr.Validation(apierr.FieldError{Field: "symbol", Message: "symbol is required"}))
         return
     }
 
-    resp, err := http.Get(fmt.Sprintf("https://stooq.pl/q/d/l/?s=%s&i=d", symbol))
+    start := time.Now()
+    resp, err := getMarketData(r.Context(), "%s/q/d/l/?s=%s&i=d", symbol)
+    metrics.ObserveUpstream("stooq", start, err)
     if err != nil {
-        log.Printf("Failed to fetch stock data: %v", err)
-        http.Error(w, "Failed to fetch stock data", http.StatusInternalServerError)
+        writeError(w, r, apierr.Upstream("Failed to fetch stock data", err))
         return
     }
     defer resp.Body.Close()
@@ -78,8 +99,7 @@ func HandleStockPrice(w http.ResponseWriter, r *http.Request) {
 
     _, err = reader.Read() // Skip header
     if err != nil {
-        log.Printf("Failed to parse CSV: %v", err)
-        http.Error(w, "Failed to parse CSV", http.StatusInternalServerError)
+        writeError(w, r, apierr.Upstream("Unexpected response from market data provider", err))
         return
     }
 
@@ -99,16 +119,32 @@ func HandleStockPrice(w http.ResponseWriter, r *http.Request) {
         records = records[len(records)-180:]
     }
 
+    // Splits are user-recorded, so only the caller's own portfolio is trusted
+    var splits []models.CorporateAction
+    if r.URL.Query().Get("adjusted") == "true" {
+        portfolio, err := resolvePortfolio(r)
+        if err != nil {
+            writeError(w, r, err)
+            return
+        }
+        splits, err = db.GetSplits(r.Context(), portfolio.ID, symbol)
+        if err != nil {
+            writeError(w, r, fmt.Errorf("loading splits for %s: %w", symbol, err))
+            return
+        }
+    }
+
     for i := len(records) - 1; i >= 0; i-- {
         price, err := strconv.ParseFloat(records[i][4], 64)
         if err != nil {
             continue
         }
+        price /= splitFactor(records[i][0], splits)
         volume, err := strconv.ParseFloat(records[i][5], 64)
     if err != nil {
         // Set default volume or log error
         volume = 0
-        log.Printf("Failed to parse volume for date %s: %v", records[i][0], err)
+        reqctx.Logger(r.Context()).Debug("Failed to parse volume", "date", records[i][0], "error", err)
     }
 
     stockData := models.StockData{
@@ -123,6 +159,7 @@ func HandleStockPrice(w http.ResponseWriter, r *http.Request) {
         if i > 0 {
             nextPrice, err := strconv.ParseFloat(records[i-1][4], 64)
             if err == nil {
+                nextPrice /= splitFactor(records[i-1][0], splits)
                 // Calculate change from previous day to current day
                 change := price - nextPrice
                 // Calculate percentage change
@@ -138,6 +175,16 @@ func HandleStockPrice(w http.ResponseWriter, r *http.Request) {
     
 
     w.Header().Set("Content-Type", "application/json")
-    w.Header().Set("Access-Control-Allow-Origin", "*")
     json.NewEncoder(w).Encode(data)
+}
+
+// Prices quoted before a split are divided by the ratios of every later split
+func splitFactor(date string, splits []models.CorporateAction) float64 {
+    factor := 1.0
+    for _, s := range splits {
+        if date < s.ExDate {
+            factor *= s.Ratio
+        }
+    }
+    return factor
 }
\ No newline at end of file
@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"server/apierr"
+	"server/db"
+	"server/reqctx"
+	"server/tax"
+
+	"github.com/xuri/excelize/v2"
+)
+
+func HandlePIT38(w http.ResponseWriter, r *http.Request) {
+    year := time.Now().Year() - 1
+    if y := r.URL.Query().Get("year"); y != "" {
+        parsed, err := strconv.Atoi(y)
+        if err != nil {
+            writeError(w, r, apierr.Validation(apierr.FieldError{Field: "year", Message: "year must be a number"}))
+            return
+        }
+        year = parsed
+    }
+
+    format := r.URL.Query().Get("format")
+    if format == "" {
+        format = "json"
+    }
+    if format != "json" && format != "csv" && format != "xlsx" {
+        writeError(w, r, apierr.Validation(apierr.FieldError{Field: "format", Message: "format must be json, csv or xlsx"}))
+        return
+    }
+
+    portfolio, err := resolvePortfolio(r)
+    if err != nil {
+        writeError(w, r, err)
+        return
+    }
+
+    txs, err := db.GetTransactions(r.Context(), portfolio.ID)
+    if err != nil {
+        writeError(w, r, fmt.Errorf("retrieving transactions: %w", err))
+        return
+    }
+
+    actions, err := db.GetCorporateActions(r.Context(), portfolio.ID, "")
+    if err != nil {
+        writeError(w, r, fmt.Errorf("retrieving corporate actions: %w", err))
+        return
+    }
+
+    cashOps, err := db.GetCashOperations(r.Context(), portfolio.ID)
+    if err != nil {
+        writeError(w, r, fmt.Errorf("retrieving cash operations: %w", err))
+        return
+    }
+
+    report, err := tax.BuildPIT38(r.Context(), year, txs, actions, cashOps)
+    if err != nil {
+        writeError(w, r, apierr.Upstream("Failed to build tax report", err))
+        return
+    }
+
+    filename := fmt.Sprintf("pit38-%d", year)
+
+    switch format {
+    case "csv":
+        w.Header().Set("Content-Type", "text/csv")
+        w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.csv"`, filename))
+        if err := writePIT38CSV(w, report); err != nil {
+            reqctx.Logger(r.Context()).Error("Failed to write PIT-38 CSV", "error", err)
+        }
+
+    case "xlsx":
+        f, err := buildPIT38Workbook(report)
+        if err != nil {
+            writeError(w, r, fmt.Errorf("building PIT-38 workbook: %w", err))
+            return
+        }
+        defer f.Close()
+
+        w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+        w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.xlsx"`, filename))
+        if err := f.Write(w); err != nil {
+            reqctx.Logger(r.Context()).Error("Failed to write PIT-38 workbook", "error", err)
+        }
+
+    default:
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(map[string]interface{}{
+            "form":   report.Form(),
+            "report": report,
+        })
+    }
+}
+
+var pit38LotHeader = []string{
+    "Symbol", "Currency", "Quantity", "Buy date", "Buy price", "Buy NBP rate", "Buy rate date",
+    "Sell date", "Sell price", "Sell NBP rate", "Sell rate date", "Cost PLN", "Proceeds PLN", "Gain PLN",
+}
+
+var pit38DividendHeader = []string{
+    "Symbol", "Date", "Currency", "Gross", "NBP rate", "Rate date",
+    "Gross PLN", "Withheld PLN", "Tax due PLN", "To pay PLN",
+}
+
+func pit38LotRow(l tax.RealizedLot) []interface{} {
+    return []interface{}{
+        l.Symbol, l.Currency, l.Quantity, l.BuyDate, l.BuyPrice, l.BuyRate, l.BuyRateDate,
+        l.SellDate, l.SellPrice, l.SellRate, l.SellRateDate, l.CostPLN, l.ProceedsPLN, l.GainPLN,
+    }
+}
+
+func pit38DividendRow(d tax.DividendItem) []interface{} {
+    return []interface{}{
+        d.Symbol, d.Date, d.Currency, d.Gross, d.Rate, d.RateDate,
+        d.GrossPLN, d.WithheldPLN, d.TaxDuePLN, d.ToPayPLN,
+    }
+}
+
+func toStrings(values []interface{}) []string {
+    out := make([]string, len(values))
+    for i, v := range values {
+        out[i] = fmt.Sprint(v)
+    }
+    return out
+}
+
+func writePIT38CSV(w http.ResponseWriter, report *tax.Report) error {
+    cw := csv.NewWriter(w)
+
+    cw.Write(pit38LotHeader)
+    for _, l := range report.Lots {
+        cw.Write(toStrings(pit38LotRow(l)))
+    }
+
+    cw.Write(nil)
+    cw.Write(pit38DividendHeader)
+    for _, d := range report.Dividends {
+        cw.Write(toStrings(pit38DividendRow(d)))
+    }
+
+    cw.Write(nil)
+    for _, section := range report.Form().Sections {
+        for _, field := range section.Fields {
+            cw.Write([]string{section.ID, field.Label, fmt.Sprint(field.Value)})
+        }
+    }
+
+    cw.Flush()
+    return cw.Error()
+}
+
+func buildPIT38Workbook(report *tax.Report) (*excelize.File, error) {
+    f := excelize.NewFile()
+
+    summary := "Summary"
+    if err := f.SetSheetName("Sheet1", summary); err != nil {
+        return nil, err
+    }
+    row := 1
+    for _, section := range report.Form().Sections {
+        f.SetCellValue(summary, fmt.Sprintf("A%d", row), section.ID+". "+section.Title)
+        row++
+        for _, field := range section.Fields {
+            f.SetCellValue(summary, fmt.Sprintf("A%d", row), field.Label)
+            f.SetCellValue(summary, fmt.Sprintf("B%d", row), field.Value)
+            row++
+        }
+        row++
+    }
+
+    if err := writeSheet(f, "Lots", pit38LotHeader, len(report.Lots), func(i int) []interface{} {
+        return pit38LotRow(report.Lots[i])
+    }); err != nil {
+        return nil, err
+    }
+
+    if err := writeSheet(f, "Dividends", pit38DividendHeader, len(report.Dividends), func(i int) []interface{} {
+        return pit38DividendRow(report.Dividends[i])
+    }); err != nil {
+        return nil, err
+    }
+
+    return f, nil
+}
\ No newline at end of file
@@ -0,0 +1,272 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"server/apierr"
+	"server/auth"
+	"server/db"
+	"server/models"
+	"server/reqctx"
+)
+
+var errIncorrectCode = apierr.Validation(apierr.FieldError{Field: "code", Message: "is incorrect"})
+
+func HandleTwoFactorStatus(w http.ResponseWriter, r *http.Request) {
+    userID := currentUserID(r)
+
+    enabled := false
+    totp, err := db.GetTOTP(r.Context(), userID)
+    if err != nil && !errors.Is(err, db.ErrTOTPNotFound) {
+        writeError(w, r, fmt.Errorf("loading 2FA settings: %w", err))
+        return
+    }
+    if totp != nil {
+        enabled = totp.EnabledAt != nil
+    }
+
+    remaining := 0
+    if enabled {
+        if remaining, err = db.CountRecoveryCodes(r.Context(), userID); err != nil {
+            writeError(w, r, fmt.Errorf("counting recovery codes: %w", err))
+            return
+        }
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "enabled":           enabled,
+        "recoveryCodesLeft": remaining,
+    })
+}
+
+// Starts enrollment with a new secret; 2FA is only enabled once a code from
+// it is confirmed
+func HandleTOTPSetup(w http.ResponseWriter, r *http.Request) {
+    user, err := db.GetUserByID(r.Context(), currentUserID(r))
+    if err != nil {
+        writeError(w, r, fmt.Errorf("loading user: %w", err))
+        return
+    }
+
+    secret, uri, err := auth.NewTOTPKey(settings.Auth.TOTPIssuer, user.Email)
+    if err != nil {
+        writeError(w, r, fmt.Errorf("generating TOTP secret: %w", err))
+        return
+    }
+    if err := db.SaveTOTPSecret(r.Context(), user.ID, secret); err != nil {
+        writeError(w, r, err)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(http.StatusCreated)
+    json.NewEncoder(w).Encode(models.TOTPEnrollment{Secret: secret, URI: uri})
+}
+
+// Confirms enrollment with a code and returns the recovery codes, which are
+// shown this once
+func HandleTOTPEnable(w http.ResponseWriter, r *http.Request) {
+    userID := currentUserID(r)
+
+    var req models.TOTPCode
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        writeError(w, r, invalidBody())
+        return
+    }
+
+    totp, err := db.GetTOTP(r.Context(), userID)
+    if err != nil {
+        writeError(w, r, err)
+        return
+    }
+    if totp.EnabledAt != nil {
+        writeError(w, r, db.ErrTOTPAlreadyEnabled)
+        return
+    }
+    step, ok := auth.VerifyTOTP(totp.Secret, req.Code, time.Now())
+    if !ok {
+        writeError(w, r, errIncorrectCode)
+        return
+    }
+
+    codes, err := auth.NewRecoveryCodes()
+    if err != nil {
+        writeError(w, r, fmt.Errorf("generating recovery codes: %w", err))
+        return
+    }
+    release, ok := acquireHashSlot(w, r)
+    if !ok {
+        return
+    }
+    hashes := make(map[string]string, len(codes))
+    for _, c := range codes {
+        id, normalized, _ := auth.ParseRecoveryCode(c)
+        if hashes[id], err = auth.GenerateHash(normalized, auth.DefaultParams); err != nil {
+            break
+        }
+    }
+    release()
+    if err != nil {
+        writeError(w, r, fmt.Errorf("hashing recovery codes: %w", err))
+        return
+    }
+
+    if err := db.EnableTOTP(r.Context(), userID, step, hashes); err != nil {
+        writeError(w, r, err)
+        return
+    }
+    reqctx.Logger(r.Context()).Info("Two-factor authentication enabled")
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string][]string{
+        "recoveryCodes": codes,
+    })
+}
+
+// Turning 2FA off needs the password, so a stolen session cannot do it
+func HandleTOTPDisable(w http.ResponseWriter, r *http.Request) {
+    userID := currentUserID(r)
+    if !allow(w, r, "password:user:"+strconv.Itoa(userID), loginPerAccount) {
+        return
+    }
+
+    var req models.PasswordConfirmation
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        writeError(w, r, invalidBody())
+        return
+    }
+
+    user, err := db.GetUserByID(r.Context(), userID)
+    if err != nil {
+        writeError(w, r, fmt.Errorf("loading user %d: %w", userID, err))
+        return
+    }
+    release, ok := acquireHashSlot(w, r)
+    if !ok {
+        return
+    }
+    match, err := auth.ComparePasswordAndHash(req.Password, string(user.Password))
+    release()
+    if err != nil || !match {
+        writeError(w, r, apierr.Validation(apierr.FieldError{Field: "password", Message: "is incorrect"}))
+        return
+    }
+
+    if err := db.DisableTOTP(r.Context(), userID); err != nil {
+        writeError(w, r, fmt.Errorf("disabling 2FA: %w", err))
+        return
+    }
+    reqctx.Logger(r.Context()).Info("Two-factor authentication disabled")
+    w.WriteHeader(http.StatusNoContent)
+}
+
+// Second login step: exchanges the challenge from HandleLogin and a TOTP or
+// recovery code for a session token. Wrong codes count towards the same
+// lockout as wrong passwords.
+func HandleLoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+    if !allow(w, r, "login:ip:"+clientIP(r), loginPerIP) {
+        return
+    }
+
+    var req models.TwoFactorLogin
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        writeError(w, r, invalidBody())
+        return
+    }
+
+    claims, err := auth.ValidateChallengeToken(req.Challenge)
+    if err != nil {
+        writeError(w, r, apierr.Unauthorized("invalid_challenge", "Login challenge is invalid or has expired"))
+        return
+    }
+    user, err := db.GetUserByID(r.Context(), claims.UserID)
+    if err != nil {
+        writeError(w, r, apierr.Unauthorized("invalid_challenge", "Login challenge is invalid or has expired"))
+        return
+    }
+
+    account := "login:account:" + strings.ToLower(user.Email)
+    if !allow(w, r, account, loginPerAccount) {
+        return
+    }
+    if lockedFor, err := limiter.LockedFor(r.Context(), account); err == nil && lockedFor > 0 {
+        writeTooManyRequests(w, r, lockedFor, "account_locked", "Too many failed logins, try again later")
+        return
+    }
+
+    ok, err := verifySecondFactor(w, r, user.ID, req.Code)
+    if err != nil {
+        writeError(w, r, err)
+        return
+    }
+    if !ok {
+        loginFailed(w, r, account)
+        return
+    }
+
+    if err := limiter.Reset(r.Context(), account); err != nil {
+        reqctx.Logger(r.Context()).Warn("Failed to reset login failures", "error", err)
+    }
+    if !accountUsable(w, r, user) {
+        return
+    }
+    issueSession(w, r, user)
+}
+
+// Returns an error only for failures unrelated to the code itself
+func verifySecondFactor(w http.ResponseWriter, r *http.Request, userID int, code string) (bool, error) {
+    totp, err := db.GetTOTP(r.Context(), userID)
+    if errors.Is(err, db.ErrTOTPNotFound) || (err == nil && totp.EnabledAt == nil) {
+        return false, apierr.Unauthorized("invalid_challenge", "Login challenge is invalid or has expired")
+    }
+    if err != nil {
+        return false, fmt.Errorf("loading 2FA settings: %w", err)
+    }
+
+    if step, ok := auth.VerifyTOTP(totp.Secret, code, time.Now()); ok {
+        err := db.UseTOTPStep(r.Context(), userID, step)
+        if errors.Is(err, db.ErrTOTPCodeReused) {
+            return false, nil
+        }
+        return err == nil, err
+    }
+
+    id, normalized, ok := auth.ParseRecoveryCode(code)
+    if !ok {
+        return false, nil
+    }
+    rowID, hash, err := db.GetRecoveryCode(r.Context(), userID, id)
+    if errors.Is(err, db.ErrRecoveryCodeInvalid) {
+        return false, nil
+    }
+    if err != nil {
+        return false, err
+    }
+
+    release, err := hashSlot(r)
+    if err != nil {
+        w.Header().Set("Retry-After", "1")
+        return false, err
+    }
+    match, err := auth.ComparePasswordAndHash(normalized, hash)
+    release()
+    if err != nil || !match {
+        return false, nil
+    }
+
+    if err := db.UseRecoveryCode(r.Context(), rowID); err != nil {
+        if errors.Is(err, db.ErrRecoveryCodeInvalid) {
+            return false, nil
+        }
+        return false, err
+    }
+    reqctx.Logger(r.Context()).Warn("Recovery code used to log in", "user_id", userID)
+    return true, nil
+}
\ No newline at end of file
@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"server/apierr"
+	"server/db"
+	"server/models"
+	"server/reqctx"
+	"server/utils"
+)
+
+func HandleListTransactions(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "application/json")
+
+    portfolio, err := resolvePortfolio(r)
+    if err != nil {
+        writeError(w, r, err)
+        return
+    }
+
+    txs, err := db.GetTransactions(r.Context(), portfolio.ID)
+    if err != nil {
+        writeError(w, r, fmt.Errorf("retrieving transactions: %w", err))
+        return
+    }
+
+    if symbol := strings.ToUpper(r.URL.Query().Get("symbol")); symbol != "" {
+        filtered := make([]models.Transaction, 0)
+        for _, t := range txs {
+            if t.Symbol == symbol {
+                filtered = append(filtered, t)
+            }
+        }
+        txs = filtered
+    }
+    json.NewEncoder(w).Encode(txs)
+}
+
+func HandleCreateTransaction(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "application/json")
+
+    portfolio, err := resolvePortfolio(r)
+    if err != nil {
+        writeError(w, r, err)
+        return
+    }
+
+    var t models.Transaction
+    if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+        writeError(w, r, invalidBody())
+        return
+    }
+    t.PortfolioID = portfolio.ID
+    t.Source = models.SourceManual
+    if !prepareTransaction(w, r, portfolio, &t) {
+        return
+    }
+
+    if err := db.CreateTransaction(r.Context(), currentUserID(r), &t); err != nil {
+        writeError(w, r, err)
+        return
+    }
+
+    w.WriteHeader(http.StatusCreated)
+    json.NewEncoder(w).Encode(t)
+}
+
+func HandleUpdateTransaction(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "application/json")
+
+    before, portfolio, ok := loadEditableTransaction(w, r)
+    if !ok {
+        return
+    }
+
+    var t models.Transaction
+    if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+        writeError(w, r, invalidBody())
+        return
+    }
+    t.ID = before.ID
+    t.PortfolioID = before.PortfolioID
+    t.Source = before.Source
+    t.ExternalID = before.ExternalID
+    if !prepareTransaction(w, r, portfolio, &t) {
+        return
+    }
+
+    if err := db.UpdateTransaction(r.Context(), currentUserID(r), before, &t); err != nil {
+        writeError(w, r, err)
+        return
+    }
+    json.NewEncoder(w).Encode(t)
+}
+
+func HandleDeleteTransaction(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "application/json")
+
+    t, _, ok := loadEditableTransaction(w, r)
+    if !ok {
+        return
+    }
+
+    if err := db.DeleteTransaction(r.Context(), currentUserID(r), t); err != nil {
+        writeError(w, r, err)
+        return
+    }
+    w.WriteHeader(http.StatusNoContent)
+}
+
+func HandleTransactionAudit(w http.ResponseWriter, r *http.Request) {
+    portfolio, err := resolvePortfolio(r)
+    if err != nil {
+        writeError(w, r, err)
+        return
+    }
+
+    entries, err := db.GetTransactionAudit(r.Context(), portfolio.ID)
+    if err != nil {
+        writeError(w, r, fmt.Errorf("retrieving transaction audit: %w", err))
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(entries)
+}
+
+func loadEditableTransaction(w http.ResponseWriter, r *http.Request) (*models.Transaction, *models.Portfolio, bool) {
+    id, err := strconv.Atoi(requestParam(r, "transactionID", "id"))
+    if err != nil {
+        writeError(w, r, apierr.BadRequest("invalid_id", "Transaction id is required"))
+        return nil, nil, false
+    }
+
+    t, err := db.GetTransaction(r.Context(), id)
+    if err != nil {
+        writeError(w, r, err)
+        return nil, nil, false
+    }
+
+    portfolio, err := db.GetPortfolioAccess(r.Context(), t.PortfolioID, currentUserID(r))
+    if err != nil {
+        writeError(w, r, db.ErrTransactionNotFound)
+        return nil, nil, false
+    }
+    if err := checkAccess(portfolio, models.AccessEditor); err != nil {
+        writeError(w, r, err)
+        return nil, nil, false
+    }
+
+    return t, portfolio, true
+}
+
+// Validates t and fills in currency defaults; writes the error response itself
+func prepareTransaction(w http.ResponseWriter, r *http.Request, portfolio *models.Portfolio, t *models.Transaction) bool {
+    if err := t.Validate(time.Now()); err != nil {
+        writeError(w, r, err)
+        return false
+    }
+
+    if !knownSymbol(r, portfolio.ID, t.Symbol) {
+        writeError(w, r, apierr.Validation(apierr.FieldError{Field: "symbol", Message: "unknown symbol"}))
+        return false
+    }
+
+    if t.Currency == "" {
+        t.Currency = utils.CurrencyForSymbol(t.Symbol)
+    }
+    if t.CommissionCurrency == "" {
+        t.CommissionCurrency = portfolio.Currency
+    }
+    return true
+}
+
+// A symbol is known if the portfolio already holds it or Stooq can quote it
+func knownSymbol(r *http.Request, portfolioID int, symbol string) bool {
+    stocks, err := db.GetStocks(r.Context(), portfolioID)
+    if err == nil {
+        for _, s := range stocks {
+            if s.Symbol == symbol {
+                return true
+            }
+        }
+    }
+
+    if _, err := fetchQuote(r.Context(), utils.StooqSymbol(symbol)); err != nil {
+        reqctx.Logger(r.Context()).Warn("Symbol lookup failed", "symbol", symbol, "error", err)
+        return false
+    }
+    return true
+}
\ No newline at end of file
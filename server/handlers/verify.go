@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"server/apierr"
+	"server/auth"
+	"server/db"
+	"server/mail"
+	"server/models"
+	"server/reqctx"
+)
+
+var errEmailUnverified = apierr.New(http.StatusForbidden, "email_unverified", "Verify your email address to continue")
+
+// RequireVerifiedEmail applies auth.unverified_access to authenticated
+// routes. AuthMiddleware loads verification with the user on each request,
+// so it takes effect without logging in again.
+func RequireVerifiedEmail(next http.HandlerFunc) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        p := reqctx.CurrentPrincipal(r.Context())
+        switch {
+        case p == nil || p.EmailVerified || settings.Auth.UnverifiedAccess == "allow":
+        case settings.Auth.UnverifiedAccess == "read_only" && (r.Method == http.MethodGet || r.Method == http.MethodHead):
+        default:
+            writeError(w, r, errEmailUnverified)
+            return
+        }
+        next(w, r)
+    }
+}
+
+// Failures are logged rather than returned, since the user can ask for
+// another link
+func sendVerificationEmail(r *http.Request, user *models.User) {
+    token, hash, err := auth.NewToken()
+    if err == nil {
+        ttl := settings.Auth.VerificationTokenTTL
+        err = db.CreateEmailVerification(r.Context(), user.ID, hash, time.Now().Add(ttl))
+        if err == nil {
+            err = mailer.Send(r.Context(), mail.Message{
+                To:      user.Email,
+                Subject: "Verify your email address",
+                Body: fmt.Sprintf("Welcome! Open this link within %s to verify your email address:\n"+
+                    "%s/verify-email?token=%s\n\n"+
+                    "If you did not create an account, ignore this email.\n",
+                    ttl, settings.Mail.AppURL, token),
+            })
+        }
+    }
+    if err != nil {
+        reqctx.Logger(r.Context()).Error("Failed to send verification email", "user_id", user.ID, "error", err)
+    }
+}
+
+func HandleVerifyEmail(w http.ResponseWriter, r *http.Request) {
+    var req models.EmailVerification
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        writeError(w, r, invalidBody())
+        return
+    }
+    if req.Token == "" {
+        writeError(w, r, db.ErrVerificationTokenInvalid)
+        return
+    }
+
+    userID, err := db.VerifyEmail(r.Context(), auth.HashToken(req.Token))
+    if err != nil {
+        writeError(w, r, err)
+        return
+    }
+    reqctx.Logger(r.Context()).Info("Email verified", "user_id", userID)
+    w.WriteHeader(http.StatusNoContent)
+}
+
+// Public, as with auth.unverified_access=deny the user cannot log in yet.
+// Always answers 202 so it does not reveal which emails are registered.
+func HandleResendVerification(w http.ResponseWriter, r *http.Request) {
+    // Shares the reset limit per IP, as both send mail to arbitrary addresses
+    if !allow(w, r, "verify:ip:"+clientIP(r), resetPerIP) {
+        return
+    }
+
+    var req models.EmailRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        writeError(w, r, invalidBody())
+        return
+    }
+    email, err := models.NormalizeEmail(req.Email)
+    if err != nil {
+        writeError(w, r, err)
+        return
+    }
+
+    accepted := func() {
+        w.Header().Set("Content-Type", "application/json")
+        w.WriteHeader(http.StatusAccepted)
+        json.NewEncoder(w).Encode(map[string]string{
+            "message": "If the account exists and is unverified, a new link has been sent",
+        })
+    }
+
+    retryAfter, err := limiter.Allow(r.Context(), "verify:account:"+email, verifyPerAccount)
+    if err == nil && retryAfter > 0 {
+        accepted()
+        return
+    }
+
+    user, err := db.GetUserByEmail(r.Context(), email)
+    if err == nil && user.EmailVerifiedAt == nil {
+        sendVerificationEmail(r, user)
+    }
+    accepted()
+}
\ No newline at end of file
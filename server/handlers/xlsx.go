@@ -1,167 +1,360 @@
 package handlers
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
-	"log"
+	"io"
 	"net/http"
+	"regexp"
 	"strconv"
 	"strings"
 
+	"server/apierr"
 	"server/db"
 	"server/models"
+	"server/reqctx"
 	"server/utils"
 
 	"github.com/xuri/excelize/v2"
 )
 
-func HandleStocksXLSX(w http.ResponseWriter, r *http.Request) {
+// Queues a statement for the import workers and returns the job, which can
+// be followed at /imports/{id}. With dryRun=true nothing is saved; the
+// response previews the changes and the import waits to be confirmed.
+func HandleUploadStocks(w http.ResponseWriter, r *http.Request) {
     w.Header().Set("Content-Type", "application/json")
-    w.Header().Set("Access-Control-Allow-Origin", "*")
-    w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-    w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
 
-    if r.Method == "OPTIONS" {
+    portfolio, err := resolvePortfolio(r)
+    if err != nil {
+        writeError(w, r, err)
         return
     }
 
-    switch r.Method {
-    case "POST":
-        // Parse multipart form
-        err := r.ParseMultipartForm(10 << 20) // 10 MB max
-        if err != nil {
-            log.Printf("Error parsing form: %v", err)
-            http.Error(w, "Failed to parse form", http.StatusBadRequest)
+    // Parse multipart form
+    r.Body = http.MaxBytesReader(w, r.Body, settings.Import.MaxUploadBytes)
+    err = r.ParseMultipartForm(settings.Import.MaxUploadBytes)
+    if err != nil {
+        writeError(w, r, apierr.BadRequest("invalid_form",
+            fmt.Sprintf("Request must be a multipart form of at most %d bytes", settings.Import.MaxUploadBytes)))
+        return
+    }
+
+    // Get file from form
+    file, header, err := r.FormFile("file")
+    if err != nil {
+        writeError(w, r, apierr.Validation(apierr.FieldError{Field: "file", Message: "file is required"}))
+        return
+    }
+    defer file.Close()
+
+    data, err := io.ReadAll(file)
+    if err != nil {
+        writeError(w, r, fmt.Errorf("reading upload: %w", err))
+        return
+    }
+    // Catch the obvious mistakes now rather than in a failed job
+    if !bytes.HasPrefix(data, []byte("PK\x03\x04")) {
+        writeError(w, r, invalidStatement(fmt.Errorf("not an XLSX file")))
+        return
+    }
+
+    dryRun := false
+    if v := r.FormValue("dryRun"); v != "" {
+        if dryRun, err = strconv.ParseBool(v); err != nil {
+            writeError(w, r, apierr.Validation(apierr.FieldError{Field: "dryRun", Message: "dryRun must be true or false"}))
             return
         }
+    }
+    if dryRun {
+        previewImport(w, r, portfolio, header.Filename, data)
+        return
+    }
 
-        // Get file from form
-        file, _, err := r.FormFile("file")
-        if err != nil {
-            log.Printf("Error getting file: %v", err)
-            http.Error(w, "Failed to get file", http.StatusBadRequest)
-            return
+    imp := &models.Import{
+        UserID:      currentUserID(r),
+        PortfolioID: portfolio.ID,
+        Filename:    header.Filename,
+    }
+    if err := db.CreateImport(r.Context(), imp, data); err != nil {
+        writeError(w, r, fmt.Errorf("queueing import: %w", err))
+        return
+    }
+    wakeImportWorkers()
+    reqctx.Logger(r.Context()).Info("Import queued", "import_id", imp.ID, "bytes", len(data))
+
+    w.WriteHeader(http.StatusAccepted)
+    json.NewEncoder(w).Encode(imp)
+}
+
+func HandleListStocks(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "application/json")
+
+    portfolio, err := resolvePortfolio(r)
+    if err != nil {
+        writeError(w, r, err)
+        return
+    }
+
+    // Get stocks from database
+    stocks, err := db.GetStocks(r.Context(), portfolio.ID)
+    if err != nil {
+        writeError(w, r, fmt.Errorf("retrieving stocks: %w", err))
+        return
+    }
+
+    if err := json.NewEncoder(w).Encode(stocks); err != nil {
+        writeError(w, r, fmt.Errorf("encoding response: %w", err))
+    }
+}
+
+var dividendPerShare = regexp.MustCompile(`([0-9]+(?:\.[0-9]+)?)\s*/\s*SHR`)
+
+// A row without the statement's ID could not be recognised when the
+// statement is imported again, so it is left out rather than added twice
+const missingIDIssue = "no ID in the statement, row skipped"
+
+func ParseDividendsXLSX(f *excelize.File, issues *importIssues) ([]models.CorporateAction, error) {
+    sheetName := f.GetSheetName(3)
+    if sheetName == "" {
+        return nil, fmt.Errorf("no sheet found at index 3")
+    }
+
+    rows, err := f.GetRows(sheetName)
+    if err != nil {
+        return nil, fmt.Errorf("failed to read rows: %v", err)
+    }
+
+    if len(rows) < 12 {
+        return nil, nil
+    }
+
+    currency := statementCurrency(rows[:11])
+    dividends := make([]models.CorporateAction, 0)
+    for i, row := range rows[11:] {
+        if len(row) < 7 {
+            continue
+        }
+
+        opType := strings.ToUpper(row[2])
+        if !strings.HasPrefix(opType, "DIVIDEN") {
+            continue
+        }
+        id := strings.TrimSpace(row[1])
+        if id == "" {
+            issues.add(i+12, models.IssueError, missingIDIssue)
+            continue
         }
-        defer file.Close()
 
-        // Open Excel file
-        f, err := excelize.OpenReader(file)
+        parsedTime, err := models.ParseTime(row[3])
         if err != nil {
-            log.Printf("Error opening excel file: %v", err)
-            http.Error(w, "Failed to process file", http.StatusInternalServerError)
-            return
+            issues.add(i+12, models.IssueError, "invalid date or time")
+            continue
         }
-        defer f.Close()
 
-        // Parse XLSX
-        stocks, err := ParseXLSXFile(f)
+        total, err := strconv.ParseFloat(strings.TrimSpace(row[6]), 64)
         if err != nil {
-            log.Printf("Error parsing XLSX: %v", err)
-            http.Error(w, fmt.Sprintf("Failed to parse XLSX file: %v", err), http.StatusInternalServerError)
-            return
+            issues.add(i+12, models.IssueError, "invalid amount")
+            continue
         }
 
-        // Save to database
-        if len(stocks) > 0 {
-            if err := db.SaveStocks(r.Context(), stocks); err != nil {
-                log.Printf("Error saving stocks: %v", err)
-                http.Error(w, "Failed to save stocks", http.StatusInternalServerError)
-                return
-            }
+        // Comment looks like "AAPL.US USD 0.2400/ SHR"
+        var perShare float64
+        if m := dividendPerShare.FindStringSubmatch(row[4]); m != nil {
+            perShare, _ = strconv.ParseFloat(m[1], 64)
+        } else {
+            issues.add(i+12, models.IssueWarning, "dividend per share not found in the comment")
         }
 
-        w.WriteHeader(http.StatusCreated)
-        json.NewEncoder(w).Encode(map[string]interface{}{
-            "message": "File uploaded successfully",
-            "stocks": stocks,
+        dividends = append(dividends, models.CorporateAction{
+            Symbol:     strings.ToUpper(row[5]),
+            Type:       models.ActionDividend,
+            ExDate:     parsedTime.Format("2006-01-02"),
+            Amount:     perShare,
+            Total:      utils.RoundToTwo(total),
+            Currency:   currency,
+            Source:     models.SourceImport,
+            ExternalID: id,
         })
+    }
+
+    return dividends, nil
+}
+
+func ParseCashOperationsXLSX(f *excelize.File, issues *importIssues) ([]models.CashOperation, error) {
+    sheetName := f.GetSheetName(3)
+    if sheetName == "" {
+        return nil, fmt.Errorf("no sheet found at index 3")
+    }
 
-    case "GET":
-        // Get stocks from database
-        stocks, err := db.GetAllStocks(r.Context())
+    rows, err := f.GetRows(sheetName)
+    if err != nil {
+        return nil, fmt.Errorf("failed to read rows: %v", err)
+    }
+
+    if len(rows) < 12 {
+        return nil, nil
+    }
+
+    currency := statementCurrency(rows[:11])
+    ops := make([]models.CashOperation, 0)
+    for i, row := range rows[11:] {
+        if len(row) < 7 || strings.TrimSpace(row[2]) == "" {
+            continue
+        }
+        id := strings.TrimSpace(row[1])
+        if id == "" {
+            issues.add(i+12, models.IssueError, missingIDIssue)
+            continue
+        }
+
+        parsedTime, err := models.ParseTime(row[3])
         if err != nil {
-            log.Printf("Error retrieving stocks: %v", err)
-            http.Error(w, "Failed to retrieve stocks", http.StatusInternalServerError)
-            return
+            issues.add(i+12, models.IssueError, "invalid date or time")
+            continue
         }
 
-        if err := json.NewEncoder(w).Encode(stocks); err != nil {
-            log.Printf("Error encoding response: %v", err)
-            http.Error(w, "Internal server error", http.StatusInternalServerError)
+        amount, err := strconv.ParseFloat(strings.TrimSpace(row[6]), 64)
+        if err != nil {
+            issues.add(i+12, models.IssueError, "invalid amount")
+            continue
         }
 
-    default:
-        http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+        ops = append(ops, models.CashOperation{
+            Type:       models.CashOperationType(row[2]),
+            Currency:   currency,
+            Amount:     amount,
+            Time:       parsedTime.Format("2006-01-02 15:04:05"),
+            Symbol:     strings.ToUpper(row[5]),
+            Comment:    row[4],
+            Source:     models.SourceImport,
+            ExternalID: id,
+        })
     }
+
+    return ops, nil
 }
 
-func ParseXLSXFile(f *excelize.File) ([]models.Stock, error) {
+// The statement header has a "Currency" label with the account currency below it
+func statementCurrency(header [][]string) string {
+    for i, row := range header[:len(header)-1] {
+        for j, cell := range row {
+            if !strings.EqualFold(strings.TrimSpace(cell), "currency") {
+                continue
+            }
+            if j < len(header[i+1]) {
+                if c := strings.TrimSpace(header[i+1][j]); len(c) == 3 {
+                    return strings.ToUpper(c)
+                }
+            }
+        }
+    }
+    return "PLN"
+}
+
+var (
+    tradeComment = regexp.MustCompile(`(?i)^(OPEN|CLOSE) BUY ([0-9]+(?:\.[0-9]+)?)(?:/[0-9]+(?:\.[0-9]+)?)? @ ([0-9]+(?:\.[0-9]+)?)`)
+    tradePrefix  = regexp.MustCompile(`(?i)^(OPEN|CLOSE) BUY\b`)
+)
+
+func ParseTransactionsXLSX(f *excelize.File, issues *importIssues) ([]models.Transaction, error) {
     sheetName := f.GetSheetName(3)
     if sheetName == "" {
         return nil, fmt.Errorf("no sheet found at index 3")
     }
-    
+
     rows, err := f.GetRows(sheetName)
     if err != nil {
         return nil, fmt.Errorf("failed to read rows: %v", err)
     }
-    
+
     if len(rows) < 12 {
-        return nil, fmt.Errorf("file contains no data rows")
+        return nil, nil
     }
 
-    stockMap := make(map[string]*models.Stock)
-    
+    currency := statementCurrency(rows[:11])
+    txs := make([]models.Transaction, 0)
+    commissions := make(map[string]float64)
+    commissionRows := make(map[string]int)
     for i, row := range rows[11:] {
         if len(row) < 6 {
             continue
         }
 
-        symbol := row[5]
-        time := row[3]
-        priceStr := row[4]
-
-        if !strings.Contains(strings.ToUpper(priceStr), "OPEN BUY") {
+        // Rows with a bad time are reported by the cash operations parser
+        parsedTime, err := models.ParseTime(row[3])
+        if err != nil {
             continue
         }
+        symbol := strings.ToUpper(row[5])
+        timestamp := parsedTime.Format("2006-01-02 15:04:05")
 
-        parts := strings.Split(strings.TrimPrefix(priceStr, "OPEN BUY "), " @ ")
-        if len(parts) != 2 {
-            log.Printf("Warning: invalid price format in row %d", i+12)
+        if models.CashOperationType(row[2]) == models.CashCommission && len(row) > 6 {
+            if amount, err := strconv.ParseFloat(strings.TrimSpace(row[6]), 64); err == nil {
+                commissions[symbol+"|"+timestamp] += -amount
+                commissionRows[symbol+"|"+timestamp] = i + 12
+            }
             continue
         }
 
-        shares, err := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
-        if err != nil {
-            log.Printf("Warning: invalid shares number in row %d: %v", i+12, err)
+        comment := strings.TrimSpace(row[4])
+        m := tradeComment.FindStringSubmatch(comment)
+        if m == nil {
+            if tradePrefix.MatchString(comment) {
+                issues.add(i+12, models.IssueError, "trade comment not recognised: "+comment)
+            }
             continue
         }
 
-        price, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
-        if err != nil {
-            log.Printf("Warning: invalid price in row %d: %v", i+12, err)
+        id := strings.TrimSpace(row[1])
+        if id == "" {
+            issues.add(i+12, models.IssueError, missingIDIssue)
             continue
         }
 
-        if existing, exists := stockMap[symbol]; exists {
-            totalShares := existing.Shares + shares
-            existing.Price = ((existing.Price * existing.Shares) + 
-                            (price * shares)) / totalShares
-            existing.Shares = totalShares
-        } else {
-            stockMap[symbol] = &models.Stock{
-                Symbol: symbol,
-                Time:   time,
-                Price:  utils.RoundToTwo(price),
-                Shares: shares,
-            }
+        quantity, _ := strconv.ParseFloat(m[2], 64)
+        price, _ := strconv.ParseFloat(m[3], 64)
+        side := models.SideBuy
+        if strings.EqualFold(m[1], "CLOSE") {
+            side = models.SideSell
         }
+
+        txs = append(txs, models.Transaction{
+            Symbol:             symbol,
+            Side:               side,
+            Quantity:           quantity,
+            Price:              price,
+            Currency:           utils.CurrencyForSymbol(symbol),
+            CommissionCurrency: currency,
+            Time:               timestamp,
+            Source:             models.SourceImport,
+            ExternalID:         id,
+        })
     }
 
-    stocks := make([]models.Stock, 0, len(stockMap))
-    for _, stock := range stockMap {
-        stocks = append(stocks, *stock)
+    // Commissions are separate statement rows booked at the same time as the trade
+    for i := range txs {
+        key := txs[i].Symbol + "|" + txs[i].Time
+        if c, ok := commissions[key]; ok {
+            txs[i].Commission = utils.RoundToTwo(c)
+            delete(commissions, key)
+        }
+    }
+    for key := range commissions {
+        issues.add(commissionRows[key], models.IssueWarning, "commission does not match any trade")
+    }
+
+    return txs, nil
+}
+
+func transactionSymbols(txs []models.Transaction) []string {
+    seen := make(map[string]bool)
+    symbols := make([]string, 0)
+    for _, t := range txs {
+        if !seen[t.Symbol] {
+            seen[t.Symbol] = true
+            symbols = append(symbols, t.Symbol)
+        }
     }
-    
-    return stocks, nil
+    return symbols
 }
\ No newline at end of file
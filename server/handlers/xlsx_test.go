@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"testing"
+
+	"server/models"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// A statement whose cash operations sheet holds rows, after the 11 header rows
+func statement(t *testing.T, rows [][]interface{}) *excelize.File {
+    t.Helper()
+    f := excelize.NewFile()
+    for _, name := range []string{"Closed", "Open", "Cash"} {
+        if _, err := f.NewSheet(name); err != nil {
+            t.Fatal(err)
+        }
+    }
+    sheet := f.GetSheetName(3)
+    for i, row := range rows {
+        cell, _ := excelize.CoordinatesToCellName(1, i+12)
+        if err := f.SetSheetRow(sheet, cell, &row); err != nil {
+            t.Fatal(err)
+        }
+    }
+    return f
+}
+
+func TestParseSkipsRowsWithoutID(t *testing.T) {
+    f := statement(t, [][]interface{}{
+        {"", "101", "Stocks/ETF purchase", "2024-03-01 10:00:00", "OPEN BUY 10 @ 150.00", "AAPL.US", "-1500"},
+        {"", "", "Stocks/ETF purchase", "2024-03-02 10:00:00", "OPEN BUY 5 @ 151.00", "AAPL.US", "-755"},
+        {"", " ", "DIVIDENT", "2024-03-03 10:00:00", "AAPL.US USD 0.2400/ SHR", "AAPL.US", "2.40"},
+        {"", "104", "DIVIDENT", "2024-03-04 10:00:00", "AAPL.US USD 0.2400/ SHR", "AAPL.US", "2.40"},
+    })
+    defer f.Close()
+
+    issues := newImportIssues()
+    dividends, err := ParseDividendsXLSX(f, issues)
+    if err != nil {
+        t.Fatal(err)
+    }
+    cashOps, err := ParseCashOperationsXLSX(f, issues)
+    if err != nil {
+        t.Fatal(err)
+    }
+    txs, err := ParseTransactionsXLSX(f, issues)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if len(txs) != 1 || txs[0].ExternalID != "101" {
+        t.Errorf("transactions = %+v, want only 101", txs)
+    }
+    if len(dividends) != 1 || dividends[0].ExternalID != "104" {
+        t.Errorf("dividends = %+v, want only 104", dividends)
+    }
+    if len(cashOps) != 2 {
+        t.Errorf("got %d cash operations, want 2", len(cashOps))
+    }
+    for _, op := range cashOps {
+        if op.ExternalID == "" {
+            t.Errorf("cash operation without ID: %+v", op)
+        }
+    }
+
+    want := map[int]bool{13: true, 14: true}
+    for _, issue := range issues.list() {
+        if issue.Message != missingIDIssue {
+            continue
+        }
+        if !want[issue.Row] || issue.Severity != models.IssueError {
+            t.Errorf("unexpected issue %+v", issue)
+        }
+        delete(want, issue.Row)
+    }
+    if len(want) > 0 {
+        t.Errorf("no issue reported for rows %v", want)
+    }
+}
\ No newline at end of file
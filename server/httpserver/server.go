@@ -0,0 +1,108 @@
+package httpserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+
+	"server/config"
+)
+
+// Server wraps http.Server with graceful shutdown and tracks background
+// workers so they can finish before the process exits.
+type Server struct {
+    cfg     config.HTTPConfig
+    srv     *http.Server
+    workers sync.WaitGroup
+
+    // Cancelled when shutdown starts; background workers should stop then
+    ctx    context.Context
+    cancel context.CancelFunc
+}
+
+func New(cfg config.HTTPConfig, handler http.Handler) *Server {
+    ctx, cancel := context.WithCancel(context.Background())
+    return &Server{
+        cfg: cfg,
+        srv: &http.Server{
+            Addr:              cfg.Addr,
+            Handler:           handler,
+            ReadTimeout:       cfg.ReadTimeout,
+            ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+            WriteTimeout:      cfg.WriteTimeout,
+            IdleTimeout:       cfg.IdleTimeout,
+            MaxHeaderBytes:    cfg.MaxHeaderBytes,
+            ErrorLog:          slog.NewLogLogger(slog.Default().Handler(), slog.LevelWarn),
+        },
+        ctx:    ctx,
+        cancel: cancel,
+    }
+}
+
+// Go runs fn in the background. Its context is cancelled on shutdown and
+// Run waits for it to return.
+func (s *Server) Go(fn func(ctx context.Context)) {
+    s.workers.Add(1)
+    go func() {
+        defer s.workers.Done()
+        fn(s.ctx)
+    }()
+}
+
+// Run serves until ctx is cancelled, then stops accepting connections and
+// waits up to ShutdownTimeout for in-flight requests and workers to finish.
+func (s *Server) Run(ctx context.Context) error {
+    errc := make(chan error, 1)
+
+    if s.cfg.TLSCertFile != "" {
+        certs, err := newCertReloader(s.cfg.TLSCertFile, s.cfg.TLSKeyFile)
+        if err != nil {
+            return err
+        }
+        s.srv.TLSConfig = certs.tlsConfig()
+        s.Go(certs.watch)
+
+        slog.Info("Server running", "addr", s.cfg.Addr, "tls", true)
+        go func() { errc <- s.srv.ListenAndServeTLS("", "") }()
+    } else {
+        slog.Info("Server running", "addr", s.cfg.Addr, "tls", false)
+        go func() { errc <- s.srv.ListenAndServe() }()
+    }
+
+    select {
+    case err := <-errc:
+        s.cancel()
+        s.workers.Wait()
+        return err
+    case <-ctx.Done():
+    }
+
+    slog.Info("Shutting down, draining in-flight requests", "timeout", s.cfg.ShutdownTimeout)
+    shutdownCtx, cancel := context.WithTimeout(context.Background(), s.cfg.ShutdownTimeout)
+    defer cancel()
+
+    err := s.srv.Shutdown(shutdownCtx)
+    s.cancel()
+
+    done := make(chan struct{})
+    go func() {
+        s.workers.Wait()
+        close(done)
+    }()
+    select {
+    case <-done:
+    case <-shutdownCtx.Done():
+        return fmt.Errorf("background workers did not stop in time")
+    }
+
+    if err != nil {
+        return fmt.Errorf("graceful shutdown failed: %w", err)
+    }
+    if err := <-errc; !errors.Is(err, http.ErrServerClosed) {
+        return err
+    }
+    return nil
+}
\ No newline at end of file
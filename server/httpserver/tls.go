@@ -0,0 +1,108 @@
+package httpserver
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const certCheckInterval = time.Minute
+
+// Serves the current certificate and swaps it when the files change on disk
+// (e.g. after a certbot renewal) or the process receives SIGHUP.
+type certReloader struct {
+    certFile string
+    keyFile  string
+
+    mu      sync.RWMutex
+    cert    *tls.Certificate
+    modTime time.Time
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+    c := &certReloader{certFile: certFile, keyFile: keyFile}
+    if err := c.load(); err != nil {
+        return nil, err
+    }
+    return c, nil
+}
+
+func (c *certReloader) load() error {
+    cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+    if err != nil {
+        return fmt.Errorf("failed to load TLS certificate: %w", err)
+    }
+    modTime, err := c.lastModified()
+    if err != nil {
+        return err
+    }
+
+    c.mu.Lock()
+    c.cert = &cert
+    c.modTime = modTime
+    c.mu.Unlock()
+    return nil
+}
+
+func (c *certReloader) lastModified() (time.Time, error) {
+    var latest time.Time
+    for _, name := range []string{c.certFile, c.keyFile} {
+        info, err := os.Stat(name)
+        if err != nil {
+            return latest, err
+        }
+        if info.ModTime().After(latest) {
+            latest = info.ModTime()
+        }
+    }
+    return latest, nil
+}
+
+func (c *certReloader) tlsConfig() *tls.Config {
+    return &tls.Config{
+        MinVersion: tls.VersionTLS12,
+        GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+            c.mu.RLock()
+            defer c.mu.RUnlock()
+            return c.cert, nil
+        },
+    }
+}
+
+func (c *certReloader) watch(ctx context.Context) {
+    hup := make(chan os.Signal, 1)
+    signal.Notify(hup, syscall.SIGHUP)
+    defer signal.Stop(hup)
+
+    ticker := time.NewTicker(certCheckInterval)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-hup:
+        case <-ticker.C:
+            modTime, err := c.lastModified()
+            c.mu.RLock()
+            unchanged := err == nil && !modTime.After(c.modTime)
+            c.mu.RUnlock()
+            if unchanged {
+                continue
+            }
+        }
+
+        // A failed reload keeps serving the previous certificate
+        if err := c.load(); err != nil {
+            slog.Error("TLS certificate reload failed", "error", err)
+            continue
+        }
+        slog.Info("Reloaded TLS certificate", "file", c.certFile)
+    }
+}
\ No newline at end of file
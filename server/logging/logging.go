@@ -0,0 +1,39 @@
+package logging
+
+import (
+	"io"
+	"log/slog"
+	"strings"
+
+	"server/config"
+)
+
+// New builds the process logger from cfg and installs it as the slog
+// default, which also routes the standard log package through it.
+func New(cfg config.LogConfig, w io.Writer) *slog.Logger {
+    opts := &slog.HandlerOptions{Level: Level(cfg.Level)}
+
+    var handler slog.Handler
+    if cfg.Format == "json" {
+        handler = slog.NewJSONHandler(w, opts)
+    } else {
+        handler = slog.NewTextHandler(w, opts)
+    }
+
+    logger := slog.New(handler)
+    slog.SetDefault(logger)
+    return logger
+}
+
+func Level(name string) slog.Level {
+    switch strings.ToLower(name) {
+    case "debug":
+        return slog.LevelDebug
+    case "warn", "warning":
+        return slog.LevelWarn
+    case "error":
+        return slog.LevelError
+    default:
+        return slog.LevelInfo
+    }
+}
\ No newline at end of file
@@ -0,0 +1,25 @@
+package mail
+
+import (
+	"context"
+	"log/slog"
+)
+
+type Message struct {
+    To      string
+    Subject string
+    Body    string // Plain text
+}
+
+type Sender interface {
+    Send(ctx context.Context, msg Message) error
+}
+
+// LogSender writes messages to the log instead of delivering them. It is used
+// in development, when no SMTP server is configured.
+type LogSender struct{}
+
+func (LogSender) Send(ctx context.Context, msg Message) error {
+    slog.Info("Mail not sent, SMTP is not configured", "to", msg.To, "subject", msg.Subject, "body", msg.Body)
+    return nil
+}
\ No newline at end of file
@@ -0,0 +1,134 @@
+package mailtest
+
+import (
+	"io"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Message struct {
+    From string
+    To   []string
+    Data string // Headers and body, with line endings normalised to \n
+}
+
+// Server is a local SMTP server that keeps whatever it receives, so tests can
+// send real mail through mail.SMTPSender and read it back.
+type Server struct {
+    ln       net.Listener
+    mu       sync.Mutex
+    messages []Message
+    received chan struct{}
+}
+
+// NewServer starts listening on a random local port
+func NewServer() (*Server, error) {
+    ln, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        return nil, err
+    }
+    s := &Server{ln: ln, received: make(chan struct{}, 100)}
+    go s.serve()
+    return s, nil
+}
+
+func (s *Server) Host() string {
+    return s.ln.Addr().(*net.TCPAddr).IP.String()
+}
+
+func (s *Server) Port() int {
+    return s.ln.Addr().(*net.TCPAddr).Port
+}
+
+func (s *Server) Close() error {
+    return s.ln.Close()
+}
+
+// Messages returns a copy of everything received so far
+func (s *Server) Messages() []Message {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    return append([]Message(nil), s.messages...)
+}
+
+// Wait blocks until a message arrives or the timeout passes
+func (s *Server) Wait(timeout time.Duration) bool {
+    select {
+    case <-s.received:
+        return true
+    case <-time.After(timeout):
+        return false
+    }
+}
+
+func (s *Server) serve() {
+    for {
+        conn, err := s.ln.Accept()
+        if err != nil {
+            return
+        }
+        go s.handle(conn)
+    }
+}
+
+// handle speaks just enough SMTP for net/smtp: no extensions, so clients
+// neither start TLS nor authenticate
+func (s *Server) handle(conn net.Conn) {
+    defer conn.Close()
+    conn.SetDeadline(time.Now().Add(30 * time.Second))
+    tp := textproto.NewConn(conn)
+    tp.PrintfLine("220 mailtest ready")
+
+    var msg Message
+    for {
+        line, err := tp.ReadLine()
+        if err != nil {
+            return
+        }
+        verb, arg, _ := strings.Cut(line, " ")
+        switch strings.ToUpper(verb) {
+        case "EHLO", "HELO":
+            tp.PrintfLine("250 mailtest")
+        case "MAIL":
+            msg = Message{From: address(arg)}
+            tp.PrintfLine("250 OK")
+        case "RCPT":
+            msg.To = append(msg.To, address(arg))
+            tp.PrintfLine("250 OK")
+        case "DATA":
+            tp.PrintfLine("354 End data with <CR><LF>.<CR><LF>")
+            data, err := io.ReadAll(tp.DotReader())
+            if err != nil {
+                return
+            }
+            msg.Data = string(data)
+            s.mu.Lock()
+            s.messages = append(s.messages, msg)
+            s.mu.Unlock()
+            select {
+            case s.received <- struct{}{}:
+            default:
+            }
+            tp.PrintfLine("250 OK")
+        case "RSET":
+            msg = Message{}
+            tp.PrintfLine("250 OK")
+        case "NOOP":
+            tp.PrintfLine("250 OK")
+        case "QUIT":
+            tp.PrintfLine("221 Bye")
+            return
+        default:
+            tp.PrintfLine("502 Command not implemented")
+        }
+    }
+}
+
+// address extracts the mailbox from "FROM:<a@b>" or "TO:<a@b>"
+func address(arg string) string {
+    _, addr, _ := strings.Cut(arg, ":")
+    return strings.Trim(strings.TrimSpace(addr), "<>")
+}
\ No newline at end of file
@@ -0,0 +1,81 @@
+package mail
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"server/metrics"
+)
+
+var ErrQueueFull = errors.New("mail queue is full")
+
+const (
+    sendTimeout  = 30 * time.Second
+    drainTimeout = 10 * time.Second
+)
+
+// Queue delivers messages in the background, so handlers neither wait on the
+// SMTP server nor take longer depending on whether an email was sent.
+type Queue struct {
+    sender Sender
+    ch     chan Message
+}
+
+func NewQueue(sender Sender, size int) *Queue {
+    return &Queue{sender: sender, ch: make(chan Message, size)}
+}
+
+// Send enqueues msg without blocking
+func (q *Queue) Send(ctx context.Context, msg Message) error {
+    select {
+    case q.ch <- msg:
+        return nil
+    default:
+        return ErrQueueFull
+    }
+}
+
+// Run delivers queued messages until ctx is cancelled, then spends a short
+// while sending whatever is left.
+func (q *Queue) Run(ctx context.Context) {
+    for {
+        select {
+        case msg := <-q.ch:
+            q.deliver(context.Background(), msg)
+        case <-ctx.Done():
+            q.drain()
+            return
+        }
+    }
+}
+
+func (q *Queue) drain() {
+    ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+    defer cancel()
+    for {
+        select {
+        case msg := <-q.ch:
+            q.deliver(ctx, msg)
+        default:
+            return
+        }
+        if ctx.Err() != nil {
+            slog.Warn("Dropping queued mail on shutdown", "count", len(q.ch))
+            return
+        }
+    }
+}
+
+func (q *Queue) deliver(ctx context.Context, msg Message) {
+    ctx, cancel := context.WithTimeout(ctx, sendTimeout)
+    defer cancel()
+
+    start := time.Now()
+    err := q.sender.Send(ctx, msg)
+    metrics.ObserveUpstream("smtp", start, err)
+    if err != nil {
+        slog.Error("Failed to send mail", "subject", msg.Subject, "error", err)
+    }
+}
\ No newline at end of file
@@ -0,0 +1,102 @@
+package mail
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"server/config"
+)
+
+type SMTPSender struct {
+    host     string
+    addr     string
+    username string
+    password string
+    from     string
+}
+
+func NewSMTPSender(cfg config.MailConfig) *SMTPSender {
+    return &SMTPSender{
+        host:     cfg.SMTPHost,
+        addr:     net.JoinHostPort(cfg.SMTPHost, strconv.Itoa(cfg.SMTPPort)),
+        username: cfg.SMTPUsername,
+        password: cfg.SMTPPassword,
+        from:     cfg.From,
+    }
+}
+
+// Send upgrades to TLS whenever the server offers STARTTLS, and only
+// authenticates when a username is configured. smtp.PlainAuth refuses to
+// send credentials over an unencrypted connection to a remote host.
+func (s *SMTPSender) Send(ctx context.Context, msg Message) error {
+    var d net.Dialer
+    conn, err := d.DialContext(ctx, "tcp", s.addr)
+    if err != nil {
+        return fmt.Errorf("connecting to %s: %w", s.addr, err)
+    }
+    if deadline, ok := ctx.Deadline(); ok {
+        conn.SetDeadline(deadline)
+    }
+
+    c, err := smtp.NewClient(conn, s.host)
+    if err != nil {
+        conn.Close()
+        return err
+    }
+    defer c.Close()
+
+    if ok, _ := c.Extension("STARTTLS"); ok {
+        if err := c.StartTLS(&tls.Config{ServerName: s.host, MinVersion: tls.VersionTLS12}); err != nil {
+            return fmt.Errorf("starting TLS: %w", err)
+        }
+    }
+    if s.username != "" {
+        if err := c.Auth(smtp.PlainAuth("", s.username, s.password, s.host)); err != nil {
+            return fmt.Errorf("authenticating: %w", err)
+        }
+    }
+
+    if err := c.Mail(s.from); err != nil {
+        return err
+    }
+    if err := c.Rcpt(msg.To); err != nil {
+        return err
+    }
+    wc, err := c.Data()
+    if err != nil {
+        return err
+    }
+    if _, err := wc.Write(s.format(msg)); err != nil {
+        wc.Close()
+        return err
+    }
+    if err := wc.Close(); err != nil {
+        return err
+    }
+    return c.Quit()
+}
+
+func (s *SMTPSender) format(msg Message) []byte {
+    var b strings.Builder
+    header := func(name, value string) {
+        // Header values come from our own templates, but never let a stray
+        // newline start a new header
+        value = strings.NewReplacer("\r", "", "\n", "").Replace(value)
+        b.WriteString(name + ": " + value + "\r\n")
+    }
+    header("From", s.from)
+    header("To", msg.To)
+    header("Subject", msg.Subject)
+    header("Date", time.Now().Format(time.RFC1123Z))
+    header("MIME-Version", "1.0")
+    header("Content-Type", "text/plain; charset=UTF-8")
+    b.WriteString("\r\n")
+    b.WriteString(strings.ReplaceAll(strings.ReplaceAll(msg.Body, "\r\n", "\n"), "\n", "\r\n"))
+    return []byte(b.String())
+}
\ No newline at end of file
@@ -0,0 +1,47 @@
+package mail
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"server/config"
+	"server/mail/mailtest"
+)
+
+func TestSMTPSenderDelivers(t *testing.T) {
+    srv, err := mailtest.NewServer()
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer srv.Close()
+
+    sender := NewSMTPSender(config.MailConfig{
+        SMTPHost: srv.Host(),
+        SMTPPort: srv.Port(),
+        From:     "noreply@example.com",
+    })
+    err = sender.Send(context.Background(), Message{
+        To:      "user@example.com",
+        Subject: "Hello\r\nBcc: other@example.com",
+        Body:    "First line\n.starts with a dot\n",
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    msgs := srv.Messages()
+    if len(msgs) != 1 {
+        t.Fatalf("got %d messages, want 1", len(msgs))
+    }
+    msg := msgs[0]
+    if msg.From != "noreply@example.com" || len(msg.To) != 1 || msg.To[0] != "user@example.com" {
+        t.Errorf("envelope = %s -> %v", msg.From, msg.To)
+    }
+    if strings.Contains(msg.Data, "\nBcc:") {
+        t.Errorf("subject injected a header:\n%s", msg.Data)
+    }
+    if !strings.HasSuffix(msg.Data, "\n\nFirst line\n.starts with a dot\n") {
+        t.Errorf("unexpected body:\n%q", msg.Data)
+    }
+}
\ No newline at end of file
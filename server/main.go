@@ -1,49 +1,116 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
-	"log"
-	"net/http"
+	"log/slog"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"server/auth"
+	"server/config"
+	"server/db"
 	"server/handlers"
+	"server/httpserver"
+	"server/logging"
+	"server/mail"
+	"server/metrics"
 	"server/middleware"
-
-	"server/db"
-
-	"github.com/joho/godotenv"
+	"server/nbp"
+	"server/ratelimit"
+	"server/router"
 )
 
 func main() {
-    if err := godotenv.Load(); err != nil {
-        log.Printf("Warning: .env file not found: %v", err)
+    configPath := flag.String("config", os.Getenv("CONFIG_FILE"), "path to a YAML or TOML config file")
+    flag.Usage = func() {
+        fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [-config file] [config print]\n", os.Args[0])
+        flag.PrintDefaults()
     }
+    flag.Parse()
 
-    databaseURL := os.Getenv("DATABASE_URL")
-    if databaseURL == "" {
-        log.Fatal("DATABASE_URL environment variable is not set")
+    cfg, err := config.Load(*configPath)
+    if err != nil {
+        fmt.Fprintln(os.Stderr, err)
+        os.Exit(1)
     }
 
-    if err := db.InitDB(databaseURL); err != nil {
-        log.Fatalf("Failed to initialize database: %v", err)
+    switch args := flag.Args(); {
+    case len(args) == 0:
+        logging.New(cfg.Log, os.Stderr)
+        err = run(cfg)
+    case len(args) == 2 && args[0] == "config" && args[1] == "print":
+        err = cfg.Print(os.Stdout)
+    default:
+        flag.Usage()
+        os.Exit(2)
     }
+    if err != nil {
+        slog.Error("Server failed", "error", err)
+        os.Exit(1)
+    }
+}
+
+func run(cfg *config.Config) error {
+    if err := db.InitDB(cfg.Database.URL); err != nil {
+        return fmt.Errorf("failed to initialize database: %w", err)
+    }
+    // Closed after the server has drained, so in-flight queries can finish
     defer db.Pool.Close()
+    metrics.Register(metrics.NewPoolCollector(db.Pool))
 
-    if err := auth.InitJWT(); err != nil {
-        log.Fatalf("Failed to initialize JWT: %v", err)
+    if n, err := db.PromoteAdmins(context.Background(), cfg.Auth.AdminEmails); err != nil {
+        return fmt.Errorf("promoting admins: %w", err)
+    } else if n > 0 {
+        slog.Info("Promoted configured admins", "count", n)
     }
 
-    // Public endpoints
-    http.HandleFunc("/api/register", handlers.HandleRegister)
-    http.HandleFunc("/api/login", handlers.HandleLogin)
+    auth.TokenExpiry = cfg.Auth.TokenExpiry
+    auth.DefaultParams = &auth.Argon2Params{
+        Memory:      cfg.Auth.Argon2Memory,
+        Iterations:  cfg.Auth.Argon2Iterations,
+        Parallelism: cfg.Auth.Argon2Parallelism,
+        SaltLength:  cfg.Auth.Argon2SaltLength,
+        KeyLength:   cfg.Auth.Argon2KeyLength,
+    }
+    auth.SetPepper([]byte(cfg.Auth.Pepper))
+    auth.LimitConcurrentHashes(cfg.Auth.MaxConcurrentHashes)
+    if err := auth.InitJWT([]byte(cfg.Auth.TokenSigningKey)); err != nil {
+        return fmt.Errorf("failed to initialize JWT: %w", err)
+    }
+
+    handlers.Configure(cfg)
+    nbp.Configure(cfg.Market.NBPURL)
 
-    // Protected endpoints
-    http.HandleFunc("/api/stock", middleware.AuthMiddleware(handlers.HandleStockPrice))
-    http.HandleFunc("/api/stocks", middleware.AuthMiddleware(handlers.HandleStocksXLSX))
-    http.HandleFunc("/api/quote", middleware.AuthMiddleware(handlers.HandleCurrentPrice))
+    var store ratelimit.Store = ratelimit.NewMemoryStore()
+    if cfg.RateLimit.Store == "postgres" {
+        store = ratelimit.NewPostgresStore(db.Pool)
+    }
+    limiter := ratelimit.New(store)
+    handlers.SetLimiter(limiter)
 
+    var sender mail.Sender = mail.LogSender{}
+    if cfg.Mail.SMTPHost != "" {
+        sender = mail.NewSMTPSender(cfg.Mail)
+    }
+    mailQueue := mail.NewQueue(sender, 100)
+    handlers.SetMailer(mailQueue)
 
-    fmt.Println("Server running on :8080")
-    log.Fatal(http.ListenAndServe(":8080", nil))
+    handler := middleware.RequestID(middleware.RequestLogger(middleware.Metrics(
+        middleware.CORS(cfg.CORS, router.New()))))
+
+    ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+    defer stop()
+
+    srv := httpserver.New(cfg.HTTP, handler)
+    srv.Go(limiter.Run)
+    srv.Go(mailQueue.Run)
+    srv.Go(handlers.RunImportWorkers)
+    if err := srv.Run(ctx); err != nil {
+        return err
+    }
+    slog.Info("Server stopped")
+    return nil
 }
\ No newline at end of file
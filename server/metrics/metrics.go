@@ -0,0 +1,95 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "stocktracker"
+
+var registry = prometheus.NewRegistry()
+
+var (
+    HTTPRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+        Namespace: namespace,
+        Name:      "http_request_duration_seconds",
+        Help:      "Duration of HTTP requests by route pattern.",
+        Buckets:   prometheus.DefBuckets,
+    }, []string{"method", "route", "status"})
+
+    UpstreamRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+        Namespace: namespace,
+        Name:      "upstream_requests_total",
+        Help:      "Requests to market data and exchange rate providers.",
+    }, []string{"provider", "outcome"})
+
+    UpstreamDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+        Namespace: namespace,
+        Name:      "upstream_request_duration_seconds",
+        Help:      "Latency of requests to market data and exchange rate providers.",
+        Buckets:   []float64{.05, .1, .25, .5, 1, 2.5, 5, 10},
+    }, []string{"provider", "outcome"})
+
+    ImportRows = prometheus.NewCounterVec(prometheus.CounterOpts{
+        Namespace: namespace,
+        Name:      "import_rows_total",
+        Help:      "Rows imported from broker statements by kind.",
+    }, []string{"kind"})
+
+    LoginAttempts = prometheus.NewCounterVec(prometheus.CounterOpts{
+        Namespace: namespace,
+        Name:      "login_attempts_total",
+        Help:      "Login attempts by outcome.",
+    }, []string{"outcome"})
+
+    JobDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+        Namespace: namespace,
+        Name:      "job_duration_seconds",
+        Help:      "Duration of background jobs.",
+        Buckets:   []float64{.1, .5, 1, 5, 15, 30, 60, 300},
+    }, []string{"job", "outcome"})
+)
+
+func init() {
+    registry.MustRegister(
+        collectors.NewGoCollector(),
+        collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+        HTTPRequestDuration,
+        UpstreamRequests,
+        UpstreamDuration,
+        ImportRows,
+        LoginAttempts,
+        JobDuration,
+    )
+}
+
+// Register adds collectors that depend on runtime state, such as the pool
+func Register(c prometheus.Collector) {
+    registry.MustRegister(c)
+}
+
+func Handler() http.Handler {
+    return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+func outcome(err error) string {
+    if err != nil {
+        return "error"
+    }
+    return "success"
+}
+
+// ObserveUpstream records one call to provider that started at start
+func ObserveUpstream(provider string, start time.Time, err error) {
+    o := outcome(err)
+    UpstreamRequests.WithLabelValues(provider, o).Inc()
+    UpstreamDuration.WithLabelValues(provider, o).Observe(time.Since(start).Seconds())
+}
+
+func ObserveJob(job string, start time.Time, err error) {
+    JobDuration.WithLabelValues(job, outcome(err)).Observe(time.Since(start).Seconds())
+}
\ No newline at end of file
@@ -0,0 +1,68 @@
+package metrics
+
+import (
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// PoolCollector exports pgxpool.Stat at scrape time
+type PoolCollector struct {
+    pool *pgxpool.Pool
+
+    acquired      *prometheus.Desc
+    idle          *prometheus.Desc
+    constructing  *prometheus.Desc
+    total         *prometheus.Desc
+    max           *prometheus.Desc
+    acquires      *prometheus.Desc
+    acquireTime   *prometheus.Desc
+    emptyAcquires *prometheus.Desc
+    canceled      *prometheus.Desc
+}
+
+func NewPoolCollector(pool *pgxpool.Pool) *PoolCollector {
+    desc := func(name, help string) *prometheus.Desc {
+        return prometheus.NewDesc(prometheus.BuildFQName(namespace, "db_pool", name), help, nil, nil)
+    }
+    return &PoolCollector{
+        pool:          pool,
+        acquired:      desc("acquired_connections", "Connections currently in use."),
+        idle:          desc("idle_connections", "Idle connections in the pool."),
+        constructing:  desc("constructing_connections", "Connections being established."),
+        total:         desc("total_connections", "Total connections in the pool."),
+        max:           desc("max_connections", "Maximum size of the pool."),
+        acquires:      desc("acquires_total", "Successful connection acquisitions."),
+        acquireTime:   desc("acquire_duration_seconds_total", "Total time spent waiting for connections."),
+        emptyAcquires: desc("empty_acquires_total", "Acquisitions that had to wait for a connection."),
+        canceled:      desc("canceled_acquires_total", "Acquisitions canceled by their context."),
+    }
+}
+
+func (c *PoolCollector) Describe(ch chan<- *prometheus.Desc) {
+    for _, d := range []*prometheus.Desc{
+        c.acquired, c.idle, c.constructing, c.total, c.max,
+        c.acquires, c.acquireTime, c.emptyAcquires, c.canceled,
+    } {
+        ch <- d
+    }
+}
+
+func (c *PoolCollector) Collect(ch chan<- prometheus.Metric) {
+    s := c.pool.Stat()
+    gauge := func(d *prometheus.Desc, v float64) {
+        ch <- prometheus.MustNewConstMetric(d, prometheus.GaugeValue, v)
+    }
+    counter := func(d *prometheus.Desc, v float64) {
+        ch <- prometheus.MustNewConstMetric(d, prometheus.CounterValue, v)
+    }
+
+    gauge(c.acquired, float64(s.AcquiredConns()))
+    gauge(c.idle, float64(s.IdleConns()))
+    gauge(c.constructing, float64(s.ConstructingConns()))
+    gauge(c.total, float64(s.TotalConns()))
+    gauge(c.max, float64(s.MaxConns()))
+    counter(c.acquires, float64(s.AcquireCount()))
+    counter(c.acquireTime, s.AcquireDuration().Seconds())
+    counter(c.emptyAcquires, float64(s.EmptyAcquireCount()))
+    counter(c.canceled, float64(s.CanceledAcquireCount()))
+}
\ No newline at end of file
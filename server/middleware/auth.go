@@ -1,34 +1,105 @@
 package middleware
 
 import (
-	"context"
+	"errors"
 	"net/http"
 	"strings"
+	"time"
 
+	"server/apierr"
 	"server/auth"
+	"server/db"
+	"server/models"
+	"server/reqctx"
 )
 
+// AuthMiddleware accepts a session as "Bearer <token>" or a personal API key
+// as "ApiKey <key>", and stores the caller as a reqctx.Principal. Authorize
+// then decides what the caller may reach.
 func AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
     return func(w http.ResponseWriter, r *http.Request) {
         authHeader := r.Header.Get("Authorization")
         if authHeader == "" {
-            http.Error(w, "Authorization header required", http.StatusUnauthorized)
+            apierr.Write(w, r, apierr.Unauthorized("missing_credentials", "Authorization header required"))
             return
         }
 
         parts := strings.Split(authHeader, " ")
-        if len(parts) != 2 || parts[0] != "Bearer" {
-            http.Error(w, "Invalid authorization header", http.StatusUnauthorized)
+        if len(parts) != 2 || (parts[0] != "Bearer" && parts[0] != "ApiKey") {
+            apierr.Write(w, r, apierr.Unauthorized("invalid_authorization", "Invalid authorization header"))
             return
         }
 
-        claims, err := auth.ValidateToken(parts[1])
+        ctx := r.Context()
+        var key *models.APIKey
+        var claims *auth.Claims
+        var userID int
+        if parts[0] == "ApiKey" {
+            var err error
+            key, err = db.GetAPIKeyByHash(ctx, auth.HashToken(parts[1]))
+            if errors.Is(err, db.ErrAPIKeyNotFound) {
+                apierr.Write(w, r, apierr.Unauthorized("invalid_api_key", "Invalid API key"))
+                return
+            }
+            if err != nil {
+                reqctx.Logger(ctx).Error("API key lookup failed", "error", err)
+                apierr.Write(w, r, apierr.Internal(err))
+                return
+            }
+            if key.Expired(time.Now()) {
+                apierr.Write(w, r, apierr.Unauthorized("expired_api_key", "API key has expired"))
+                return
+            }
+            userID = key.UserID
+        } else {
+            var err error
+            claims, err = auth.ValidateToken(parts[1])
+            if err != nil {
+                apierr.Write(w, r, apierr.Unauthorized("invalid_token", "Invalid or expired token"))
+                return
+            }
+            userID = claims.UserID
+        }
+
+        // Looked up on every request so that role changes, disabling and
+        // session revocation apply immediately
+        user, err := db.GetUserByID(ctx, userID)
+        if errors.Is(err, db.ErrUserNotFound) {
+            apierr.Write(w, r, apierr.Unauthorized("invalid_token", "Invalid or expired token"))
+            return
+        }
         if err != nil {
-            http.Error(w, err.Error(), http.StatusUnauthorized)
+            reqctx.Logger(ctx).Error("User lookup failed", "error", err)
+            apierr.Write(w, r, apierr.Internal(err))
+            return
+        }
+        if user.DisabledAt != nil {
+            apierr.Write(w, r, apierr.New(http.StatusForbidden, "account_disabled", "This account has been disabled"))
+            return
+        }
+        if claims != nil && user.SessionsRevokedAt != nil && claims.IssuedAt.Before(*user.SessionsRevokedAt) {
+            apierr.Write(w, r, apierr.Unauthorized("session_revoked", "Session has been revoked, log in again"))
             return
         }
 
-        ctx := context.WithValue(r.Context(), "user_id", claims.UserID)
+        logger := reqctx.Logger(ctx).With("user_id", userID)
+        if key != nil {
+            if err := db.TouchAPIKey(ctx, key.ID); err != nil {
+                logger.Warn("Failed to record API key use", "api_key_id", key.ID, "error", err)
+            }
+            logger = logger.With("api_key_id", key.ID)
+        }
+
+        ctx = reqctx.WithPrincipal(ctx, &reqctx.Principal{
+            UserID:        userID,
+            Role:          user.Role,
+            EmailVerified: user.EmailVerifiedAt != nil,
+            APIKey:        key,
+        })
+        ctx = reqctx.WithLogger(ctx, logger)
+        if info := reqctx.RequestInfo(ctx); info != nil {
+            info.UserID = userID
+        }
         next.ServeHTTP(w, r.WithContext(ctx))
     }
 }
\ No newline at end of file
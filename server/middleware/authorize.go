@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"net/http"
+
+	"server/apierr"
+	"server/models"
+	"server/reqctx"
+)
+
+// Policy is what a route demands of an authenticated caller
+type Policy struct {
+    Role  string // Minimum role, see models.RoleAtLeast
+    Scope string // API key scope needed; sessions are not limited by scope
+}
+
+// Authorize rejects callers that do not satisfy p. It must run inside
+// AuthMiddleware.
+func Authorize(p Policy, next http.HandlerFunc) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        principal := reqctx.CurrentPrincipal(r.Context())
+        if principal == nil {
+            apierr.Write(w, r, apierr.Unauthorized("missing_credentials", "Authorization header required"))
+            return
+        }
+
+        if !models.RoleAtLeast(principal.Role, p.Role) {
+            detail := "Your role does not allow this"
+            if principal.Role == models.RoleViewer {
+                detail = "Viewers have read-only access"
+            }
+            apierr.Write(w, r, apierr.New(http.StatusForbidden, "insufficient_role", detail))
+            return
+        }
+
+        if key := principal.APIKey; key != nil && !key.Allows(p.Scope) {
+            detail := "API key lacks the " + p.Scope + " scope"
+            if p.Scope == models.ScopeSession {
+                detail = "API keys cannot be used here; log in instead"
+            }
+            apierr.Write(w, r, apierr.New(http.StatusForbidden, "insufficient_scope", detail))
+            return
+        }
+        next(w, r)
+    }
+}
\ No newline at end of file
@@ -0,0 +1,62 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"server/config"
+)
+
+const (
+    corsAllowedMethods = "GET, POST, PUT, DELETE, OPTIONS"
+    corsAllowedHeaders = "Authorization, Content-Type, X-Request-ID"
+    corsExposedHeaders = "Content-Disposition, X-Request-ID"
+)
+
+func originAllowed(cfg config.CORSConfig, origin string) bool {
+    for _, o := range cfg.AllowedOrigins {
+        if o == "*" || strings.EqualFold(o, origin) {
+            return true
+        }
+    }
+    return false
+}
+
+// CORS wraps the whole mux so preflight requests are answered before
+// AuthMiddleware or method routing can reject them.
+func CORS(cfg config.CORSConfig, next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Header().Add("Vary", "Origin")
+
+        origin := r.Header.Get("Origin")
+        preflight := r.Method == "OPTIONS" && r.Header.Get("Access-Control-Request-Method") != ""
+
+        if origin == "" || !originAllowed(cfg, origin) {
+            if preflight {
+                w.WriteHeader(http.StatusForbidden)
+                return
+            }
+            next.ServeHTTP(w, r)
+            return
+        }
+
+        w.Header().Set("Access-Control-Allow-Origin", origin)
+        if cfg.AllowCredentials {
+            w.Header().Set("Access-Control-Allow-Credentials", "true")
+        }
+
+        if preflight {
+            w.Header().Add("Vary", "Access-Control-Request-Method")
+            w.Header().Add("Vary", "Access-Control-Request-Headers")
+            w.Header().Set("Access-Control-Allow-Methods", corsAllowedMethods)
+            w.Header().Set("Access-Control-Allow-Headers", corsAllowedHeaders)
+            w.Header().Set("Access-Control-Max-Age", strconv.Itoa(cfg.MaxAge))
+            w.WriteHeader(http.StatusNoContent)
+            return
+        }
+
+        w.Header().Set("Access-Control-Expose-Headers", corsExposedHeaders)
+        next.ServeHTTP(w, r)
+    })
+}
\ No newline at end of file
@@ -0,0 +1,76 @@
+package middleware
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"server/reqctx"
+)
+
+type statusRecorder struct {
+    http.ResponseWriter
+    status int
+    bytes  int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+    if r.status == 0 {
+        r.status = status
+    }
+    r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+    if r.status == 0 {
+        r.status = http.StatusOK
+    }
+    n, err := r.ResponseWriter.Write(b)
+    r.bytes += n
+    return n, err
+}
+
+// Lets http.ResponseController reach the underlying writer
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+    return r.ResponseWriter
+}
+
+// RequestLogger attaches a logger carrying the request ID to the context and
+// writes one access log line per request. It must run inside RequestID.
+func RequestLogger(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        start := time.Now()
+
+        logger := slog.Default().With("request_id", reqctx.RequestID(r.Context()))
+        ctx, info := reqctx.WithInfo(reqctx.WithLogger(r.Context(), logger))
+        rec := &statusRecorder{ResponseWriter: w}
+
+        next.ServeHTTP(rec, r.WithContext(ctx))
+
+        if rec.status == 0 {
+            rec.status = http.StatusOK
+        }
+        level := slog.LevelInfo
+        if rec.status >= http.StatusInternalServerError {
+            level = slog.LevelError
+        }
+
+        path := r.URL.Path
+        if info.Secret && info.Route != "" {
+            // Drop the method the pattern starts with
+            path = info.Route[strings.IndexByte(info.Route, ' ')+1:]
+        }
+        attrs := []slog.Attr{
+            slog.String("method", r.Method),
+            slog.String("path", path),
+            slog.Int("status", rec.status),
+            slog.Int("bytes", rec.bytes),
+            slog.Float64("latency_ms", float64(time.Since(start).Microseconds())/1000),
+        }
+        if info.UserID != 0 {
+            attrs = append(attrs, slog.Int("user_id", info.UserID))
+        }
+        logger.LogAttrs(r.Context(), level, "request", attrs...)
+    })
+}
\ No newline at end of file
@@ -0,0 +1,33 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"server/metrics"
+	"server/reqctx"
+)
+
+// Metrics records request durations labelled by the matched route pattern
+// rather than the raw path, which keeps label cardinality bounded. It must
+// run inside RequestLogger, which sets up the shared request info.
+func Metrics(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        start := time.Now()
+        rec := &statusRecorder{ResponseWriter: w}
+
+        next.ServeHTTP(rec, r)
+
+        route := "unmatched"
+        if info := reqctx.RequestInfo(r.Context()); info != nil && info.Route != "" {
+            route = info.Route
+        }
+        if rec.status == 0 {
+            rec.status = http.StatusOK
+        }
+        metrics.HTTPRequestDuration.
+            WithLabelValues(r.Method, route, strconv.Itoa(rec.status)).
+            Observe(time.Since(start).Seconds())
+    })
+}
\ No newline at end of file
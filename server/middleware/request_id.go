@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+
+	"server/reqctx"
+)
+
+const RequestIDHeader = "X-Request-ID"
+
+func RequestID(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        id := r.Header.Get(RequestIDHeader)
+        if !validRequestID(id) {
+            id = newRequestID()
+        }
+
+        w.Header().Set(RequestIDHeader, id)
+        next.ServeHTTP(w, r.WithContext(reqctx.WithRequestID(r.Context(), id)))
+    })
+}
+
+// Accept IDs from upstream proxies only if they are short and printable
+func validRequestID(id string) bool {
+    if id == "" || len(id) > 64 {
+        return false
+    }
+    for _, c := range id {
+        if c < '!' || c > '~' {
+            return false
+        }
+    }
+    return true
+}
+
+func newRequestID() string {
+    b := make([]byte, 12)
+    rand.Read(b)
+    return hex.EncodeToString(b)
+}
\ No newline at end of file
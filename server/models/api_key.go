@@ -0,0 +1,74 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// API key scopes. Each includes the ones before it.
+const (
+    ScopeRead   = "read"   // Safe methods only
+    ScopeImport = "import" // Also uploading statements
+    ScopeAdmin  = "admin"  // Everything the owner can do with a session
+
+    // Required by routes that API keys may never use, such as key management
+    ScopeSession = "session"
+)
+
+var scopeRank = map[string]int{ScopeRead: 1, ScopeImport: 2, ScopeAdmin: 3}
+
+type APIKey struct {
+    ID         int        `json:"id"`
+    UserID     int        `json:"-"`
+    Name       string     `json:"name"`
+    Prefix     string     `json:"prefix"` // Start of the key, to tell keys apart
+    Scopes     []string   `json:"scopes"`
+    ExpiresAt  *time.Time `json:"expiresAt,omitempty"`
+    LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+    CreatedAt  time.Time  `json:"createdAt"`
+}
+
+// Returned once, when the key is created
+type NewAPIKey struct {
+    APIKey
+    Key string `json:"key"`
+}
+
+func (k *APIKey) Allows(scope string) bool {
+    need, ok := scopeRank[scope]
+    if !ok {
+        return false
+    }
+    for _, s := range k.Scopes {
+        if scopeRank[s] >= need {
+            return true
+        }
+    }
+    return false
+}
+
+func (k *APIKey) Expired(now time.Time) bool {
+    return k.ExpiresAt != nil && !now.Before(*k.ExpiresAt)
+}
+
+func (k *APIKey) Validate(now time.Time) error {
+    k.Name = strings.TrimSpace(k.Name)
+    if k.Name == "" {
+        return fieldError("name", "name is required")
+    }
+    if len(k.Name) > 100 {
+        return fieldError("name", "name must be at most 100 characters")
+    }
+    if len(k.Scopes) == 0 {
+        return fieldError("scopes", "at least one scope is required")
+    }
+    for _, s := range k.Scopes {
+        if _, ok := scopeRank[s]; !ok {
+            return fieldError("scopes", "unknown scope "+s+"; use read, import or admin")
+        }
+    }
+    if k.ExpiresAt != nil && !k.ExpiresAt.After(now) {
+        return fieldError("expiresAt", "expiry must be in the future")
+    }
+    return nil
+}
\ No newline at end of file
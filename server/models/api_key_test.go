@@ -0,0 +1,30 @@
+package models
+
+import "testing"
+
+func TestAPIKeyAllows(t *testing.T) {
+    tests := []struct {
+        scopes []string
+        need   string
+        want   bool
+    }{
+        {[]string{ScopeRead}, ScopeRead, true},
+        {[]string{ScopeRead}, ScopeImport, false},
+        {[]string{ScopeRead}, ScopeAdmin, false},
+        {[]string{ScopeImport}, ScopeRead, true},
+        {[]string{ScopeImport}, ScopeImport, true},
+        {[]string{ScopeImport}, ScopeAdmin, false},
+        {[]string{ScopeAdmin}, ScopeImport, true},
+        {[]string{ScopeRead, ScopeAdmin}, ScopeAdmin, true},
+        {[]string{ScopeAdmin}, ScopeSession, false},
+        {[]string{ScopeAdmin}, "", false},
+        {[]string{"owner"}, ScopeRead, false},
+        {nil, ScopeRead, false},
+    }
+    for _, tt := range tests {
+        k := &APIKey{Scopes: tt.scopes}
+        if got := k.Allows(tt.need); got != tt.want {
+            t.Errorf("key with %v allows %q = %v, want %v", tt.scopes, tt.need, got, tt.want)
+        }
+    }
+}
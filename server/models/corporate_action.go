@@ -0,0 +1,85 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+const (
+    ActionDividend = "dividend"
+    ActionSplit    = "split"
+    ActionRename   = "rename"
+    ActionSpinOff  = "spinoff"
+
+    SourceImport          = "import"
+    SourceManual          = "manual"
+    SourceCorporateAction = "corporate_action"
+    SourceOpeningBalance  = "opening_balance" // Position held before the ledger existed
+)
+
+type CorporateAction struct {
+    ID           int     `json:"id"`
+    PortfolioID  int     `json:"portfolioId"`
+    Symbol       string  `json:"symbol"`
+    Type         string  `json:"type"`
+    ExDate       string  `json:"exDate"`
+    Amount       float64 `json:"amount,omitempty"`       // Dividend per share
+    Total        float64 `json:"total,omitempty"`        // Cash received for dividends
+    Ratio        float64 `json:"ratio,omitempty"`        // New shares per old share (split, spin-off)
+    NewSymbol    string  `json:"newSymbol,omitempty"`    // Rename target or spun-off company
+    CostFraction float64 `json:"costFraction,omitempty"` // Part of cost basis moved to the spin-off
+    Currency     string  `json:"currency"`
+    Source       string  `json:"source"`
+    ExternalID   string  `json:"externalId,omitempty"`
+}
+
+type DividendIncome struct {
+    Symbol   string  `json:"symbol"`
+    Currency string  `json:"currency"`
+    Total    float64 `json:"total"`
+    Payments int     `json:"payments"`
+}
+
+func (a *CorporateAction) Validate() error {
+    a.Symbol = strings.ToUpper(strings.TrimSpace(a.Symbol))
+    a.NewSymbol = strings.ToUpper(strings.TrimSpace(a.NewSymbol))
+    if a.Currency == "" {
+        a.Currency = "PLN"
+    }
+
+    if a.Symbol == "" {
+        return fieldError("symbol", "symbol is required")
+    }
+    if _, err := time.Parse("2006-01-02", a.ExDate); err != nil {
+        return fieldError("exDate", "exDate must be in YYYY-MM-DD format")
+    }
+
+    switch a.Type {
+    case ActionDividend:
+        if a.Amount <= 0 && a.Total <= 0 {
+            return fieldError("amount", "dividend requires amount per share or total")
+        }
+    case ActionSplit:
+        if a.Ratio <= 0 || a.Ratio == 1 {
+            return fieldError("ratio", "split requires a positive ratio other than 1")
+        }
+    case ActionRename:
+        if a.NewSymbol == "" || a.NewSymbol == a.Symbol {
+            return fieldError("newSymbol", "rename requires a different newSymbol")
+        }
+    case ActionSpinOff:
+        if a.NewSymbol == "" || a.NewSymbol == a.Symbol {
+            return fieldError("newSymbol", "spin-off requires a different newSymbol")
+        }
+        if a.Ratio <= 0 {
+            return fieldError("ratio", "spin-off requires a positive ratio")
+        }
+        if a.CostFraction < 0 || a.CostFraction >= 1 {
+            return fieldError("costFraction", "spin-off costFraction must be between 0 and 1")
+        }
+    default:
+        return fieldError("type", "unknown corporate action type")
+    }
+
+    return nil
+}
\ No newline at end of file
@@ -0,0 +1,15 @@
+package models
+
+// FieldError reports which request field failed validation
+type FieldError struct {
+    Field   string
+    Message string
+}
+
+func (e *FieldError) Error() string {
+    return e.Message
+}
+
+func fieldError(field, message string) error {
+    return &FieldError{Field: field, Message: message}
+}
\ No newline at end of file
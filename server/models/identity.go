@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// Identity links an account at an OpenID Connect issuer to a user
+type Identity struct {
+    ID          int        `json:"id"`
+    UserID      int        `json:"-"`
+    Issuer      string     `json:"issuer"`
+    Subject     string     `json:"subject"`
+    Email       string     `json:"email"`
+    LastLoginAt *time.Time `json:"lastLoginAt,omitempty"`
+    CreatedAt   time.Time  `json:"createdAt"`
+}
+
+type OIDCCallback struct {
+    Code  string `json:"code"`
+    State string `json:"state"`
+}
\ No newline at end of file
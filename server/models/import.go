@@ -0,0 +1,86 @@
+package models
+
+import "time"
+
+const (
+    ImportPreviewed  = "previewed" // Dry run, waiting to be confirmed
+    ImportQueued     = "queued"
+    ImportProcessing = "processing"
+    ImportCompleted  = "completed"
+    ImportFailed     = "failed"
+    ImportExpired    = "expired" // Preview not confirmed in time
+)
+
+// Import is a statement upload, processed in the background. Transactions,
+// Dividends and CashOperations count the records it added, leaving out ones
+// the portfolio already had.
+type Import struct {
+    ID             int           `json:"id"`
+    UserID         int           `json:"userId"`
+    PortfolioID    int           `json:"portfolioId"`
+    Filename       string        `json:"filename"`
+    Status         string        `json:"status"`
+    TotalRows      int           `json:"totalRows"`     // Records found in the statement
+    ProcessedRows  int           `json:"processedRows"` // Records written so far, committed at the end
+    Progress       float64       `json:"progress"`      // Percent
+    Transactions   int           `json:"transactions"`
+    Dividends      int           `json:"dividends"`
+    CashOperations int           `json:"cashOperations"`
+    Error          string        `json:"error,omitempty"`
+    Issues         []ImportIssue `json:"issues,omitempty"`
+    Attempts       int           `json:"-"`
+    CreatedAt      time.Time     `json:"createdAt"`
+    StartedAt      *time.Time    `json:"startedAt,omitempty"`
+    FinishedAt     *time.Time    `json:"finishedAt,omitempty"`
+}
+
+func (i *Import) Done() bool {
+    return i.Status == ImportCompleted || i.Status == ImportFailed || i.Status == ImportExpired
+}
+
+// Severities of an ImportIssue
+const (
+    IssueError   = "error"   // The row was skipped
+    IssueWarning = "warning" // The row was imported but may need checking
+)
+
+// ImportIssue is a problem with one row of a statement
+type ImportIssue struct {
+    Row      int    `json:"row"` // Spreadsheet row number, starting at 1
+    Severity string `json:"severity"`
+    Message  string `json:"message"`
+}
+
+// ImportPreview is what a dry run found; nothing has been saved yet
+type ImportPreview struct {
+    Import         *Import           `json:"import"`
+    Transactions   []Transaction     `json:"transactions"`
+    Dividends      int               `json:"dividends"`
+    CashOperations int               `json:"cashOperations"`
+    Positions      []PositionDelta   `json:"positions"`
+    Duplicates     []ImportDuplicate `json:"duplicates"` // Skipped when the import is applied
+}
+
+// PositionDelta is how the new trades would change the shares held
+type PositionDelta struct {
+    Symbol          string  `json:"symbol"`
+    CurrentShares   float64 `json:"currentShares"`
+    Change          float64 `json:"change"`
+    ResultingShares float64 `json:"resultingShares"`
+}
+
+// Kinds of statement record
+const (
+    RecordTransaction = "transaction"
+    RecordDividend    = "dividend"
+    RecordCash        = "cash"
+)
+
+// ImportDuplicate is a statement record that was already imported, or that
+// appears more than once in the file
+type ImportDuplicate struct {
+    Kind       string `json:"kind"` // transaction, dividend or cash
+    ExternalID string `json:"externalId"`
+    Symbol     string `json:"symbol,omitempty"`
+    Time       string `json:"time"`
+}
\ No newline at end of file
@@ -0,0 +1,251 @@
+package models
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+const (
+    CashDeposit    = "deposit"
+    CashWithdrawal = "withdrawal"
+    CashCommission = "commission"
+    CashSwap       = "swap"
+    CashTax        = "tax"
+    CashDividend   = "dividend"
+    CashInterest   = "interest"
+    CashPurchase   = "purchase"
+    CashSale       = "sale"
+    CashOther      = "other"
+)
+
+// Access levels on a portfolio, from least to most privileged
+const (
+    AccessViewer = "viewer"
+    AccessEditor = "editor"
+    AccessOwner  = "owner"
+)
+
+var accessRank = map[string]int{AccessViewer: 1, AccessEditor: 2, AccessOwner: 3}
+
+// AccessAtLeast reports whether access grants everything min does
+func AccessAtLeast(access, min string) bool {
+    return accessRank[access] >= accessRank[min]
+}
+
+type Portfolio struct {
+    ID        int    `json:"id"`
+    UserID    int    `json:"userId"`
+    Name      string `json:"name"`
+    Currency  string `json:"currency"`
+    CreatedAt string `json:"createdAt"`
+    Access    string `json:"access"` // The caller's access level
+}
+
+// PortfolioMember is a user the owner has shared a portfolio with
+type PortfolioMember struct {
+    PortfolioID int    `json:"portfolioId"`
+    UserID      int    `json:"userId"`
+    Email       string `json:"email"`
+    Access      string `json:"access"`
+    InvitedBy   int    `json:"invitedBy"`
+    CreatedAt   string `json:"createdAt"`
+}
+
+// PortfolioInvitation is access offered to an email address, until someone
+// signed in with that address verified accepts it
+type PortfolioInvitation struct {
+    ID            int    `json:"id"`
+    PortfolioID   int    `json:"portfolioId"`
+    PortfolioName string `json:"portfolioName,omitempty"`
+    Email         string `json:"email"`
+    Access        string `json:"access"`
+    InvitedBy     int    `json:"invitedBy"`
+    CreatedAt     string `json:"createdAt"`
+}
+
+type PortfolioInvite struct {
+    Email  string `json:"email"`
+    Access string `json:"access"`
+}
+
+// ValidMemberAccess reports whether access can be granted to a member
+func ValidMemberAccess(access string) bool {
+    return access == AccessViewer || access == AccessEditor
+}
+
+// Validate normalises the email in place
+func (i *PortfolioInvite) Validate() error {
+    email, err := NormalizeEmail(i.Email)
+    if err != nil {
+        return err
+    }
+    i.Email = email
+    if !ValidMemberAccess(i.Access) {
+        return fieldError("access", "access must be viewer or editor")
+    }
+    return nil
+}
+
+type MemberChange struct {
+    Access string `json:"access"`
+}
+
+// PortfolioActivity records a change made to a shared portfolio
+type PortfolioActivity struct {
+    ID          int             `json:"id"`
+    PortfolioID int             `json:"portfolioId"`
+    UserID      int             `json:"userId"`
+    UserEmail   string          `json:"userEmail"`
+    Action      string          `json:"action"`
+    Details     json.RawMessage `json:"details,omitempty"`
+    CreatedAt   string          `json:"createdAt"`
+}
+
+// Portfolio activity actions; transaction edits are in the transaction audit
+const (
+    ActivityCashCreated            = "cash.created"
+    ActivityCorporateActionCreated = "corporate_action.created"
+    ActivityStatementImported      = "statement.imported"
+    ActivityMemberInvited          = "member.invited"
+    ActivityInviteRevoked          = "member.invite_revoked"
+    ActivityMemberAdded            = "member.added"
+    ActivityMemberUpdated          = "member.updated"
+    ActivityMemberRemoved          = "member.removed"
+    ActivityShareLinkCreated       = "share_link.created"
+    ActivityShareLinkRevoked       = "share_link.revoked"
+)
+
+type CashOperation struct {
+    ID          int     `json:"id"`
+    PortfolioID int     `json:"portfolioId"`
+    Type        string  `json:"type"`
+    Currency    string  `json:"currency"`
+    Amount      float64 `json:"amount"`
+    Time        string  `json:"time"`
+    Symbol      string  `json:"symbol,omitempty"`
+    Comment     string  `json:"comment,omitempty"`
+    Source      string  `json:"source"`
+    ExternalID  string  `json:"externalId,omitempty"`
+}
+
+type CashBalance struct {
+    Currency string  `json:"currency"`
+    Balance  float64 `json:"balance"`
+    Error    string  `json:"error,omitempty"` // Set when it is left out of a valuation
+}
+
+type PositionValue struct {
+    Symbol       string  `json:"symbol"`
+    Shares       float64 `json:"shares"`
+    AveragePrice float64 `json:"averagePrice"`
+    CurrentPrice float64 `json:"currentPrice"`
+    Currency     string  `json:"currency"`
+    Value        float64 `json:"value"`
+    ValueBase    float64 `json:"valueBase"`
+    Error        string  `json:"error,omitempty"`
+}
+
+type PortfolioValuation struct {
+    PortfolioID    int             `json:"portfolioId"`
+    Currency       string          `json:"currency"`
+    Positions      []PositionValue `json:"positions"`
+    Cash           []CashBalance   `json:"cash"`
+    PositionsValue float64         `json:"positionsValue"`
+    CashValue      float64         `json:"cashValue"`
+    TotalValue     float64         `json:"totalValue"`
+}
+
+// Maps the operation type column of a broker statement to our cash operation types
+func CashOperationType(brokerType string) string {
+    t := strings.ToLower(brokerType)
+    switch {
+    case strings.Contains(t, "divid"):
+        return CashDividend
+    case strings.Contains(t, "tax"):
+        return CashTax
+    case strings.Contains(t, "interest"):
+        return CashInterest
+    case strings.Contains(t, "deposit"):
+        return CashDeposit
+    case strings.Contains(t, "withdraw"):
+        return CashWithdrawal
+    case strings.Contains(t, "commission"), strings.Contains(t, "fee"):
+        return CashCommission
+    case strings.Contains(t, "swap"), strings.Contains(t, "rollover"):
+        return CashSwap
+    case strings.Contains(t, "purchase"):
+        return CashPurchase
+    case strings.Contains(t, "sale"):
+        return CashSale
+    default:
+        return CashOther
+    }
+}
+
+func IsCashOperationType(t string) bool {
+    switch t {
+    case CashDeposit, CashWithdrawal, CashCommission, CashSwap, CashTax,
+        CashDividend, CashInterest, CashPurchase, CashSale, CashOther:
+        return true
+    }
+    return false
+}
+
+// CashOperationSign returns the sign the amount of a manual entry of type t
+// must have: 1 for money coming in, -1 for money going out and 0 when
+// either is allowed
+func CashOperationSign(t string) int {
+    switch t {
+    case CashDeposit:
+        return 1
+    case CashWithdrawal, CashCommission, CashTax:
+        return -1
+    }
+    return 0
+}
+
+// Validate checks a manually entered operation. A missing time means now and
+// a missing currency is left for the caller to default.
+func (op *CashOperation) Validate(now time.Time) error {
+    op.Currency = strings.ToUpper(strings.TrimSpace(op.Currency))
+
+    if !IsCashOperationType(op.Type) {
+        return fieldError("type", "unknown cash operation type")
+    }
+    if op.Amount == 0 {
+        return fieldError("amount", "amount is required")
+    }
+    switch sign := CashOperationSign(op.Type); {
+    case sign > 0 && op.Amount < 0:
+        return fieldError("amount", op.Type+" amount must be positive")
+    case sign < 0 && op.Amount > 0:
+        return fieldError("amount", op.Type+" amount must be negative")
+    }
+    if op.Currency != "" && !isCurrencyCode(op.Currency) {
+        return fieldError("currency", "currency must be a 3-letter code")
+    }
+
+    if op.Time == "" {
+        op.Time = now.Format("2006-01-02 15:04:05")
+        return nil
+    }
+    entryTime, err := parseEntryTime(op.Time, now)
+    if err != nil {
+        return err
+    }
+    op.Time = entryTime
+    return nil
+}
+
+func isCurrencyCode(code string) bool {
+    if len(code) != 3 {
+        return false
+    }
+    for _, c := range code {
+        if c < 'A' || c > 'Z' {
+            return false
+        }
+    }
+    return true
+}
@@ -0,0 +1,61 @@
+package models
+
+import "time"
+
+// ShareLink grants read-only access to a portfolio without an account
+type ShareLink struct {
+    ID            int        `json:"id"`
+    PortfolioID   int        `json:"portfolioId"`
+    CreatedBy     int        `json:"createdBy"`
+    Prefix        string     `json:"prefix"`        // Start of the token, to tell links apart
+    HideAmounts   bool       `json:"hideAmounts"`   // Only percentages are shown
+    HideCostBasis bool       `json:"hideCostBasis"` // No purchase prices or gains
+    ExpiresAt     *time.Time `json:"expiresAt,omitempty"`
+    LastUsedAt    *time.Time `json:"lastUsedAt,omitempty"`
+    CreatedAt     time.Time  `json:"createdAt"`
+}
+
+// Returned once, when the link is created
+type NewShareLink struct {
+    ShareLink
+    Token string `json:"token"`
+    URL   string `json:"url"`
+}
+
+func (l *ShareLink) Expired(now time.Time) bool {
+    return l.ExpiresAt != nil && !now.Before(*l.ExpiresAt)
+}
+
+func (l *ShareLink) Validate(now time.Time) error {
+    if l.ExpiresAt != nil && !l.ExpiresAt.After(now) {
+        return fieldError("expiresAt", "expiry must be in the future")
+    }
+    return nil
+}
+
+// SharedPortfolio is what a share link shows. Weights are percentages of
+// the total value; the other figures are left out as the link's redaction
+// settings require.
+type SharedPortfolio struct {
+    Name          string           `json:"name"`
+    Currency      string           `json:"currency"`
+    HideAmounts   bool             `json:"hideAmounts"`
+    HideCostBasis bool             `json:"hideCostBasis"`
+    Positions     []SharedPosition `json:"positions"`
+    CashWeight    float64          `json:"cashWeight"`
+    TotalValue    *float64         `json:"totalValue,omitempty"`
+    Cash          []CashBalance    `json:"cash,omitempty"`
+    AsOf          time.Time        `json:"asOf"`
+}
+
+type SharedPosition struct {
+    Symbol       string   `json:"symbol"`
+    Currency     string   `json:"currency"`
+    Weight       *float64 `json:"weight,omitempty"`
+    Shares       *float64 `json:"shares,omitempty"`
+    CurrentPrice *float64 `json:"currentPrice,omitempty"`
+    Value        *float64 `json:"value,omitempty"`
+    AveragePrice *float64 `json:"averagePrice,omitempty"`
+    GainPercent  *float64 `json:"gainPercent,omitempty"`
+    Error        string   `json:"error,omitempty"`
+}
\ No newline at end of file
@@ -12,11 +12,12 @@ type StockData struct {
 }
 
 type Stock struct {
-    ID     int     `json:"id"`
-    Symbol string  `json:"symbol"`
-    Time   string  `json:"time"`
-    Price  float64 `json:"price"`
-    Shares float64 `json:"shares"`
+    ID       int     `json:"id"`
+    Symbol   string  `json:"symbol"`
+    Time     string  `json:"time"`
+    Price    float64 `json:"price"`
+    Shares   float64 `json:"shares"`
+    Currency string  `json:"currency,omitempty"`
 }
 
 type StockQuote struct {
@@ -0,0 +1,29 @@
+package models
+
+import "time"
+
+type TOTP struct {
+    UserID    int
+    Secret    string
+    EnabledAt *time.Time // Nil until the first code is confirmed
+    LastStep  int64      // Most recent time step used, to reject replays
+}
+
+type TOTPEnrollment struct {
+    Secret string `json:"secret"`
+    URI    string `json:"uri"` // otpauth:// URI for a QR code
+}
+
+type TOTPCode struct {
+    Code string `json:"code"`
+}
+
+// Second login step; Code is a TOTP code or a recovery code
+type TwoFactorLogin struct {
+    Challenge string `json:"challenge"`
+    Code      string `json:"code"`
+}
+
+type PasswordConfirmation struct {
+    Password string `json:"password"`
+}
\ No newline at end of file
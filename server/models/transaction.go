@@ -0,0 +1,90 @@
+package models
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+const (
+    SideBuy  = "buy"
+    SideSell = "sell"
+)
+
+type Transaction struct {
+    ID                 int     `json:"id"`
+    PortfolioID        int     `json:"portfolioId"`
+    Symbol             string  `json:"symbol"`
+    Side               string  `json:"side"`
+    Quantity           float64 `json:"quantity"`
+    Price              float64 `json:"price"`
+    Currency           string  `json:"currency"`
+    Commission         float64 `json:"commission"`
+    CommissionCurrency string  `json:"commissionCurrency"`
+    Time               string  `json:"time"`
+    Source             string  `json:"source"`
+    ExternalID         string  `json:"externalId,omitempty"`
+}
+
+func (t *Transaction) Validate(now time.Time) error {
+    t.Symbol = strings.ToUpper(strings.TrimSpace(t.Symbol))
+    t.Side = strings.ToLower(strings.TrimSpace(t.Side))
+    t.Currency = strings.ToUpper(strings.TrimSpace(t.Currency))
+    t.CommissionCurrency = strings.ToUpper(strings.TrimSpace(t.CommissionCurrency))
+
+    if t.Symbol == "" {
+        return fieldError("symbol", "symbol is required")
+    }
+    if t.Side != SideBuy && t.Side != SideSell {
+        return fieldError("side", "side must be buy or sell")
+    }
+    if t.Quantity <= 0 {
+        return fieldError("quantity", "quantity must be positive")
+    }
+    if t.Price <= 0 {
+        return fieldError("price", "price must be positive")
+    }
+    if t.Commission < 0 {
+        return fieldError("commission", "commission must not be negative")
+    }
+    if t.Currency != "" && len(t.Currency) != 3 {
+        return fieldError("currency", "currency must be a 3-letter code")
+    }
+    if t.CommissionCurrency != "" && len(t.CommissionCurrency) != 3 {
+        return fieldError("commissionCurrency", "commissionCurrency must be a 3-letter code")
+    }
+
+    entryTime, err := parseEntryTime(t.Time, now)
+    if err != nil {
+        return err
+    }
+    t.Time = entryTime
+
+    return nil
+}
+
+// Parses the time of a manual entry, which may leave out the time of day,
+// and formats it for storage
+func parseEntryTime(value string, now time.Time) (string, error) {
+    parsed, err := ParseTime(value)
+    if err != nil {
+        if parsed, err = time.Parse("2006-01-02", value); err != nil {
+            return "", fieldError("time", "time must be YYYY-MM-DD or YYYY-MM-DD HH:MM:SS")
+        }
+    }
+    if parsed.After(now) {
+        return "", fieldError("time", "time must not be in the future")
+    }
+    return parsed.Format("2006-01-02 15:04:05"), nil
+}
+
+type TransactionAudit struct {
+    ID            int             `json:"id"`
+    TransactionID int             `json:"transactionId"`
+    UserID        int             `json:"userId"`
+    UserEmail     string          `json:"userEmail"`
+    Action        string          `json:"action"`
+    Before        json.RawMessage `json:"before,omitempty"`
+    After         json.RawMessage `json:"after,omitempty"`
+    CreatedAt     string          `json:"createdAt"`
+}
\ No newline at end of file
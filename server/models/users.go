@@ -1,16 +1,86 @@
 package models
 
-import "time"
+import (
+	"net/mail"
+	"strings"
+	"time"
+)
+
+// Roles, from least to most privileged
+const (
+    RoleViewer = "viewer" // Read-only
+    RoleUser   = "user"
+    RoleAdmin  = "admin"
+)
+
+var roleRank = map[string]int{RoleViewer: 1, RoleUser: 2, RoleAdmin: 3}
+
+// RoleAtLeast reports whether role grants everything min does
+func RoleAtLeast(role, min string) bool {
+    have, ok := roleRank[role]
+    return ok && have >= roleRank[min]
+}
+
+func ValidRole(role string) bool {
+    _, ok := roleRank[role]
+    return ok
+}
 
 type User struct {
-    ID        int       `json:"id"`
-    Email     string    `json:"email"`
-    Password  []byte    `json:"-"` // Never send to client
-    CreatedAt time.Time `json:"created_at"`
-    UpdatedAt time.Time `json:"updated_at"`
+    ID                int        `json:"id"`
+    Email             string     `json:"email"`
+    Password          []byte     `json:"-"` // Never send to client
+    Role              string     `json:"role"`
+    EmailVerifiedAt   *time.Time `json:"email_verified_at,omitempty"`
+    DisabledAt        *time.Time `json:"disabled_at,omitempty"`
+    SessionsRevokedAt *time.Time `json:"-"`
+    CreatedAt         time.Time  `json:"created_at"`
+    UpdatedAt         time.Time  `json:"updated_at"`
+}
+
+// NormalizeEmail trims and case-folds email and checks that it is a bare
+// address, so that one mailbox maps to exactly one account.
+func NormalizeEmail(email string) (string, error) {
+    email = strings.ToLower(strings.TrimSpace(email))
+    if email == "" {
+        return "", fieldError("email", "email is required")
+    }
+
+    addr, err := mail.ParseAddress(email)
+    if err != nil || addr.Address != email || len(email) > 254 {
+        return "", fieldError("email", "must be a valid email address")
+    }
+    domain := email[strings.LastIndex(email, "@")+1:]
+    if !strings.Contains(domain, ".") || strings.HasPrefix(domain, ".") || strings.HasSuffix(domain, ".") {
+        return "", fieldError("email", "must be a valid email address")
+    }
+    return email, nil
 }
 
 type Credentials struct {
     Email    string `json:"email"`
     Password string `json:"password"`
+}
+
+type PasswordChange struct {
+    CurrentPassword string `json:"currentPassword"`
+    NewPassword     string `json:"newPassword"`
+}
+
+type RoleChange struct {
+    Role string `json:"role"`
+}
+
+type EmailVerification struct {
+    Token string `json:"token"`
+}
+
+// Body of requests that act on an account by its email
+type EmailRequest struct {
+    Email string `json:"email"`
+}
+
+type PasswordReset struct {
+    Token       string `json:"token"`
+    NewPassword string `json:"newPassword"`
 }
\ No newline at end of file
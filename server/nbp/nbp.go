@@ -0,0 +1,127 @@
+package nbp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"server/metrics"
+)
+
+const (
+    // Long enough to cover Easter and the Christmas/New Year break
+    lookback = 10 * 24 * time.Hour
+)
+
+var ErrNoRate = errors.New("no NBP rate published in lookback window")
+
+type Rate struct {
+    Currency string  `json:"currency"`
+    Date     string  `json:"date"`
+    Table    string  `json:"table"`
+    Mid      float64 `json:"mid"`
+}
+
+type tableResponse struct {
+    Rates []struct {
+        No            string  `json:"no"`
+        EffectiveDate string  `json:"effectiveDate"`
+        Mid           float64 `json:"mid"`
+    } `json:"rates"`
+}
+
+var (
+    baseURL = "https://api.nbp.pl"
+    client  = &http.Client{Timeout: 10 * time.Second}
+    mu      sync.Mutex
+    cache   = make(map[string]Rate)
+)
+
+// Points the client at another NBP API host, e.g. a mirror or a stub
+func Configure(url string) {
+    baseURL = strings.TrimRight(url, "/")
+}
+
+// Returns the table A mid rate published on the last business day before day,
+// as required for converting foreign income and costs in PIT-38.
+func MidRateBefore(ctx context.Context, currency string, day time.Time) (Rate, error) {
+    currency = strings.ToUpper(currency)
+    dayStr := day.Format("2006-01-02")
+    if currency == "PLN" {
+        return Rate{Currency: currency, Date: dayStr, Mid: 1}, nil
+    }
+
+    key := currency + "|" + dayStr
+    mu.Lock()
+    rate, ok := cache[key]
+    mu.Unlock()
+    if ok {
+        return rate, nil
+    }
+
+    end := day.AddDate(0, 0, -1)
+    start := day.Add(-lookback)
+    url := fmt.Sprintf("%s/api/exchangerates/rates/a/%s/%s/%s/?format=json", baseURL, strings.ToLower(currency),
+        start.Format("2006-01-02"), end.Format("2006-01-02"))
+
+    requested := time.Now()
+    table, err := fetchTable(ctx, url)
+    if errors.Is(err, ErrNoRate) {
+        // NBP answers 404 for windows without any rates; the call itself worked
+        metrics.ObserveUpstream("nbp", requested, nil)
+        return Rate{}, err
+    }
+    metrics.ObserveUpstream("nbp", requested, err)
+    if err != nil {
+        return Rate{}, err
+    }
+
+    last := table.Rates[len(table.Rates)-1]
+    rate = Rate{
+        Currency: currency,
+        Date:     last.EffectiveDate,
+        Table:    last.No,
+        Mid:      last.Mid,
+    }
+
+    mu.Lock()
+    cache[key] = rate
+    mu.Unlock()
+
+    return rate, nil
+}
+
+func fetchTable(ctx context.Context, url string) (*tableResponse, error) {
+    req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+    if err != nil {
+        return nil, err
+    }
+    req.Header.Set("Accept", "application/json")
+
+    resp, err := client.Do(req)
+    if err != nil {
+        return nil, fmt.Errorf("failed to fetch NBP rate: %v", err)
+    }
+    defer resp.Body.Close()
+
+    if resp.StatusCode == http.StatusNotFound {
+        return nil, ErrNoRate
+    }
+    if resp.StatusCode != http.StatusOK {
+        return nil, fmt.Errorf("NBP API returned %s", resp.Status)
+    }
+
+    var table tableResponse
+    if err := json.NewDecoder(resp.Body).Decode(&table); err != nil {
+        return nil, fmt.Errorf("failed to decode NBP response: %v", err)
+    }
+    if len(table.Rates) == 0 {
+        return nil, ErrNoRate
+    }
+    return &table, nil
+}
\ No newline at end of file
@@ -0,0 +1,67 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps state in process; use PostgresStore with several instances
+type MemoryStore struct {
+    mu       sync.Mutex
+    buckets  map[string]*Bucket
+    lockouts map[string]*Lockout
+}
+
+func NewMemoryStore() *MemoryStore {
+    return &MemoryStore{
+        buckets:  make(map[string]*Bucket),
+        lockouts: make(map[string]*Lockout),
+    }
+}
+
+func (s *MemoryStore) UpdateBucket(_ context.Context, key string, fn func(*Bucket)) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    b, ok := s.buckets[key]
+    if !ok {
+        b = &Bucket{}
+        s.buckets[key] = b
+    }
+    fn(b)
+    return nil
+}
+
+func (s *MemoryStore) UpdateLockout(_ context.Context, key string, fn func(*Lockout)) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    lo, ok := s.lockouts[key]
+    if !ok {
+        lo = &Lockout{}
+    }
+    fn(lo)
+    // Lookups of unknown keys should not grow the map
+    if ok || lo.Failures > 0 {
+        s.lockouts[key] = lo
+    }
+    return nil
+}
+
+func (s *MemoryStore) Prune(_ context.Context, idleSince time.Time) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    for key, b := range s.buckets {
+        if b.Updated.Before(idleSince) {
+            delete(s.buckets, key)
+        }
+    }
+    for key, lo := range s.lockouts {
+        if lo.Updated.Before(idleSince) && lo.LockedUntil.Before(time.Now()) {
+            delete(s.lockouts, key)
+        }
+    }
+    return nil
+}
\ No newline at end of file
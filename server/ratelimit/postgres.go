@@ -0,0 +1,75 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// PostgresStore shares limiter state between instances through the
+// rate_limits and login_lockouts tables. Rows are locked for the duration of
+// each update.
+type PostgresStore struct {
+    pool *pgxpool.Pool
+}
+
+func NewPostgresStore(pool *pgxpool.Pool) *PostgresStore {
+    return &PostgresStore{pool: pool}
+}
+
+func (s *PostgresStore) UpdateBucket(ctx context.Context, key string, fn func(*Bucket)) error {
+    return pgx.BeginFunc(ctx, s.pool, func(tx pgx.Tx) error {
+        var b Bucket
+        err := tx.QueryRow(ctx, `
+            SELECT tokens, updated_at FROM rate_limits WHERE key = $1 FOR UPDATE`, key).
+            Scan(&b.Tokens, &b.Updated)
+        if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+            return err
+        }
+
+        fn(&b)
+
+        _, err = tx.Exec(ctx, `
+            INSERT INTO rate_limits (key, tokens, updated_at) VALUES ($1, $2, $3)
+            ON CONFLICT (key) DO UPDATE SET tokens = $2, updated_at = $3`,
+            key, b.Tokens, b.Updated)
+        return err
+    })
+}
+
+func (s *PostgresStore) UpdateLockout(ctx context.Context, key string, fn func(*Lockout)) error {
+    return pgx.BeginFunc(ctx, s.pool, func(tx pgx.Tx) error {
+        var lo Lockout
+        err := tx.QueryRow(ctx, `
+            SELECT failures, locked_until, updated_at FROM login_lockouts WHERE key = $1 FOR UPDATE`, key).
+            Scan(&lo.Failures, &lo.LockedUntil, &lo.Updated)
+        found := err == nil
+        if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+            return err
+        }
+
+        before := lo
+        fn(&lo)
+        if lo == before || (!found && lo.Failures == 0) {
+            return nil
+        }
+
+        _, err = tx.Exec(ctx, `
+            INSERT INTO login_lockouts (key, failures, locked_until, updated_at) VALUES ($1, $2, $3, $4)
+            ON CONFLICT (key) DO UPDATE SET failures = $2, locked_until = $3, updated_at = $4`,
+            key, lo.Failures, lo.LockedUntil, lo.Updated)
+        return err
+    })
+}
+
+func (s *PostgresStore) Prune(ctx context.Context, idleSince time.Time) error {
+    if _, err := s.pool.Exec(ctx, `DELETE FROM rate_limits WHERE updated_at < $1`, idleSince); err != nil {
+        return err
+    }
+    _, err := s.pool.Exec(ctx, `
+        DELETE FROM login_lockouts WHERE updated_at < $1 AND locked_until < NOW()`, idleSince)
+    return err
+}
\ No newline at end of file
@@ -0,0 +1,166 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"server/metrics"
+)
+
+// Limit allows Count events per Per, refilled continuously (token bucket)
+type Limit struct {
+    Count int
+    Per   time.Duration
+}
+
+// ParseLimit reads limits written as "count/duration", e.g. "10/1m"
+func ParseLimit(s string) (Limit, error) {
+    count, per, ok := strings.Cut(s, "/")
+    if !ok {
+        return Limit{}, fmt.Errorf("limit %q must look like 10/1m", s)
+    }
+    n, err := strconv.Atoi(strings.TrimSpace(count))
+    if err != nil || n < 1 {
+        return Limit{}, fmt.Errorf("limit %q must have a positive count", s)
+    }
+    d, err := time.ParseDuration(strings.TrimSpace(per))
+    if err != nil || d <= 0 {
+        return Limit{}, fmt.Errorf("limit %q must have a positive duration", s)
+    }
+    return Limit{Count: n, Per: d}, nil
+}
+
+func (l Limit) ratePerSecond() float64 {
+    return float64(l.Count) / l.Per.Seconds()
+}
+
+// LockoutPolicy locks a key for Base after Threshold failures within Window,
+// doubling for each further failure up to Max.
+type LockoutPolicy struct {
+    Threshold int
+    Base      time.Duration
+    Max       time.Duration
+    Window    time.Duration
+}
+
+type Bucket struct {
+    Tokens  float64
+    Updated time.Time // Zero for a key that has not been seen
+}
+
+type Lockout struct {
+    Failures    int
+    LockedUntil time.Time
+    Updated     time.Time
+}
+
+// Store keeps limiter state; each update must be atomic per key so that
+// several server instances can share one store.
+type Store interface {
+    UpdateBucket(ctx context.Context, key string, fn func(*Bucket)) error
+    UpdateLockout(ctx context.Context, key string, fn func(*Lockout)) error
+    Prune(ctx context.Context, idleSince time.Time) error
+}
+
+type Limiter struct {
+    store Store
+    now   func() time.Time // Replaced in tests
+}
+
+func New(store Store) *Limiter {
+    return &Limiter{store: store, now: time.Now}
+}
+
+// Allow takes one token for key and returns how long to wait if none is left
+func (l *Limiter) Allow(ctx context.Context, key string, limit Limit) (time.Duration, error) {
+    var retryAfter time.Duration
+    err := l.store.UpdateBucket(ctx, key, func(b *Bucket) {
+        now := l.now()
+        if b.Updated.IsZero() {
+            b.Tokens = float64(limit.Count)
+        } else {
+            refill := now.Sub(b.Updated).Seconds() * limit.ratePerSecond()
+            b.Tokens = math.Min(float64(limit.Count), b.Tokens+refill)
+        }
+        b.Updated = now
+
+        if b.Tokens >= 1 {
+            b.Tokens--
+            return
+        }
+        wait := (1 - b.Tokens) / limit.ratePerSecond()
+        retryAfter = time.Duration(math.Ceil(wait)) * time.Second
+    })
+    return retryAfter, err
+}
+
+// LockedFor returns how much longer key stays locked out
+func (l *Limiter) LockedFor(ctx context.Context, key string) (time.Duration, error) {
+    var remaining time.Duration
+    err := l.store.UpdateLockout(ctx, key, func(lo *Lockout) {
+        remaining = lo.LockedUntil.Sub(l.now())
+    })
+    if remaining < 0 {
+        remaining = 0
+    }
+    return remaining, err
+}
+
+// Fail records a failed attempt and returns the lockout it triggered, if any
+func (l *Limiter) Fail(ctx context.Context, key string, policy LockoutPolicy) (time.Duration, error) {
+    var lockedFor time.Duration
+    err := l.store.UpdateLockout(ctx, key, func(lo *Lockout) {
+        now := l.now()
+        if now.Sub(lo.Updated) > policy.Window && now.After(lo.LockedUntil) {
+            lo.Failures = 0
+        }
+        lo.Failures++
+        lo.Updated = now
+
+        if over := lo.Failures - policy.Threshold; over >= 0 {
+            lockedFor = policy.Base << min(over, 30)
+            if lockedFor > policy.Max || lockedFor <= 0 {
+                lockedFor = policy.Max
+            }
+            lo.LockedUntil = now.Add(lockedFor)
+        }
+    })
+    return lockedFor, err
+}
+
+// Reset clears failures for key, e.g. after a successful login
+func (l *Limiter) Reset(ctx context.Context, key string) error {
+    return l.store.UpdateLockout(ctx, key, func(lo *Lockout) {
+        *lo = Lockout{Updated: l.now()}
+    })
+}
+
+const (
+    pruneInterval = 10 * time.Minute
+    pruneIdle     = 24 * time.Hour
+)
+
+// Run prunes idle state until ctx is cancelled; start it as a background worker
+func (l *Limiter) Run(ctx context.Context) {
+    ticker := time.NewTicker(pruneInterval)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+            start := time.Now()
+            err := l.store.Prune(ctx, start.Add(-pruneIdle))
+            metrics.ObserveJob("ratelimit_prune", start, err)
+            if err != nil && ctx.Err() == nil {
+                slog.Warn("Failed to prune rate limit state", "error", err)
+            }
+        }
+    }
+}
\ No newline at end of file
@@ -0,0 +1,143 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// clock is a fake time source that only moves when told to
+type clock struct {
+    t time.Time
+}
+
+func (c *clock) now() time.Time          { return c.t }
+func (c *clock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func newTestLimiter() (*Limiter, *clock) {
+    c := &clock{t: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)}
+    l := New(NewMemoryStore())
+    l.now = c.now
+    return l, c
+}
+
+func TestAllow(t *testing.T) {
+    ctx := context.Background()
+    l, c := newTestLimiter()
+    limit := Limit{Count: 3, Per: time.Minute} // One token every 20s
+
+    for i := 0; i < 3; i++ {
+        if wait, err := l.Allow(ctx, "k", limit); err != nil || wait != 0 {
+            t.Fatalf("request %d: wait %v, err %v", i+1, wait, err)
+        }
+    }
+    if wait, _ := l.Allow(ctx, "k", limit); wait != 20*time.Second {
+        t.Errorf("over the limit: wait %v, want 20s", wait)
+    }
+    if wait, _ := l.Allow(ctx, "other", limit); wait != 0 {
+        t.Errorf("another key: wait %v, want 0", wait)
+    }
+
+    c.advance(10 * time.Second)
+    if wait, _ := l.Allow(ctx, "k", limit); wait != 10*time.Second {
+        t.Errorf("half a token refilled: wait %v, want 10s", wait)
+    }
+    c.advance(10 * time.Second)
+    if wait, _ := l.Allow(ctx, "k", limit); wait != 0 {
+        t.Errorf("token refilled: wait %v, want 0", wait)
+    }
+
+    // An idle bucket refills to the limit and no further
+    c.advance(time.Hour)
+    for i := 0; i < 3; i++ {
+        if wait, _ := l.Allow(ctx, "k", limit); wait != 0 {
+            t.Fatalf("after idling, request %d: wait %v", i+1, wait)
+        }
+    }
+    if wait, _ := l.Allow(ctx, "k", limit); wait == 0 {
+        t.Error("idle bucket held more than the limit")
+    }
+}
+
+func TestLockout(t *testing.T) {
+    ctx := context.Background()
+    policy := LockoutPolicy{Threshold: 3, Base: time.Minute, Max: 10 * time.Minute, Window: 15 * time.Minute}
+
+    t.Run("doubles up to the cap", func(t *testing.T) {
+        l, c := newTestLimiter()
+        want := []time.Duration{0, 0, time.Minute, 2 * time.Minute, 4 * time.Minute, 8 * time.Minute,
+            10 * time.Minute, 10 * time.Minute}
+        for i, w := range want {
+            got, err := l.Fail(ctx, "k", policy)
+            if err != nil || got != w {
+                t.Fatalf("failure %d: locked for %v (err %v), want %v", i+1, got, err, w)
+            }
+            if remaining, _ := l.LockedFor(ctx, "k"); remaining != w {
+                t.Errorf("failure %d: LockedFor = %v, want %v", i+1, remaining, w)
+            }
+            c.advance(time.Second)
+        }
+    })
+
+    t.Run("lock expires", func(t *testing.T) {
+        l, c := newTestLimiter()
+        for i := 0; i < 3; i++ {
+            l.Fail(ctx, "k", policy)
+        }
+        c.advance(30 * time.Second)
+        if remaining, _ := l.LockedFor(ctx, "k"); remaining != 30*time.Second {
+            t.Errorf("LockedFor = %v, want 30s", remaining)
+        }
+        c.advance(time.Minute)
+        if remaining, _ := l.LockedFor(ctx, "k"); remaining != 0 {
+            t.Errorf("after expiry: LockedFor = %v, want 0", remaining)
+        }
+    })
+
+    t.Run("failures outside the window are forgotten", func(t *testing.T) {
+        l, c := newTestLimiter()
+        l.Fail(ctx, "k", policy)
+        l.Fail(ctx, "k", policy)
+        c.advance(policy.Window + time.Second)
+        if got, _ := l.Fail(ctx, "k", policy); got != 0 {
+            t.Errorf("first failure of a new window locked for %v", got)
+        }
+    })
+
+    t.Run("window does not reset while locked", func(t *testing.T) {
+        l, c := newTestLimiter()
+        long := policy
+        long.Base = time.Hour
+        long.Max = 2 * time.Hour
+        for i := 0; i < 3; i++ {
+            l.Fail(ctx, "k", long)
+        }
+        c.advance(long.Window + time.Second)
+        if got, _ := l.Fail(ctx, "k", long); got != 2*time.Hour {
+            t.Errorf("failure while locked: locked for %v, want 2h", got)
+        }
+    })
+
+    t.Run("reset clears failures", func(t *testing.T) {
+        l, _ := newTestLimiter()
+        l.Fail(ctx, "k", policy)
+        l.Fail(ctx, "k", policy)
+        if err := l.Reset(ctx, "k"); err != nil {
+            t.Fatal(err)
+        }
+        if got, _ := l.Fail(ctx, "k", policy); got != 0 {
+            t.Errorf("failure after reset locked for %v", got)
+        }
+    })
+}
+
+func TestParseLimit(t *testing.T) {
+    if got, err := ParseLimit(" 10 / 1m "); err != nil || got != (Limit{Count: 10, Per: time.Minute}) {
+        t.Errorf("ParseLimit = %+v, %v", got, err)
+    }
+    for _, s := range []string{"", "10", "0/1m", "-1/1m", "x/1m", "10/0s", "10/soon"} {
+        if _, err := ParseLimit(s); err == nil {
+            t.Errorf("ParseLimit(%q) accepted", s)
+        }
+    }
+}
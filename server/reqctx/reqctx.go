@@ -0,0 +1,75 @@
+package reqctx
+
+import (
+	"context"
+	"log/slog"
+
+	"server/models"
+)
+
+type contextKey int
+
+const (
+    requestIDKey contextKey = iota
+    loggerKey
+    infoKey
+    principalKey
+)
+
+func WithRequestID(ctx context.Context, id string) context.Context {
+    return context.WithValue(ctx, requestIDKey, id)
+}
+
+func RequestID(ctx context.Context) string {
+    id, _ := ctx.Value(requestIDKey).(string)
+    return id
+}
+
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+    return context.WithValue(ctx, loggerKey, logger)
+}
+
+// Logger returns the request-scoped logger, or the default one outside a request
+func Logger(ctx context.Context) *slog.Logger {
+    if logger, ok := ctx.Value(loggerKey).(*slog.Logger); ok {
+        return logger
+    }
+    return slog.Default()
+}
+
+// Info is shared by every handler in a request's middleware chain, so outer
+// middleware such as the access log can see what inner ones learned.
+type Info struct {
+    UserID int
+    Route  string // Matched mux pattern, empty if no route matched
+    Secret bool   // The path holds a credential, so the access log shows Route instead
+}
+
+func WithInfo(ctx context.Context) (context.Context, *Info) {
+    info := &Info{}
+    return context.WithValue(ctx, infoKey, info), info
+}
+
+// RequestInfo returns nil outside a request
+func RequestInfo(ctx context.Context) *Info {
+    info, _ := ctx.Value(infoKey).(*Info)
+    return info
+}
+
+// Principal is the authenticated caller of a request
+type Principal struct {
+    UserID        int
+    Role          string
+    EmailVerified bool
+    APIKey        *models.APIKey // Set when authenticated by key rather than a session
+}
+
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+    return context.WithValue(ctx, principalKey, p)
+}
+
+// CurrentPrincipal returns nil for unauthenticated requests
+func CurrentPrincipal(ctx context.Context) *Principal {
+    p, _ := ctx.Value(principalKey).(*Principal)
+    return p
+}
\ No newline at end of file
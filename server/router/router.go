@@ -0,0 +1,205 @@
+package router
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"server/apierr"
+	"server/handlers"
+	"server/metrics"
+	"server/middleware"
+	"server/models"
+	"server/reqctx"
+)
+
+const APIPrefix = "/api/v1"
+
+type route struct {
+    method     string
+    path       string
+    handler    http.HandlerFunc
+    public     bool
+    legacy     string // Unversioned path kept as an alias during the migration;
+                      // path parameters fall back to query parameters there
+    unverified bool   // Usable before the email is verified, whatever the policy
+    scope      string // API key scope needed; by default read for GET, admin otherwise
+    role       string // Minimum role; by default viewer for GET, user otherwise
+}
+
+func (rt route) policy() middleware.Policy {
+    p := middleware.Policy{Role: rt.role, Scope: rt.scope}
+    if p.Role == "" {
+        p.Role = models.RoleUser
+        if rt.method == "GET" {
+            p.Role = models.RoleViewer
+        }
+    }
+    if p.Scope == "" {
+        p.Scope = models.ScopeAdmin
+        if rt.method == "GET" {
+            p.Scope = models.ScopeRead
+        }
+    }
+    return p
+}
+
+// The handler wrapped in authentication and the route's policy
+func (rt route) chain() http.HandlerFunc {
+    h := rt.handler
+    if !rt.public {
+        h = middleware.Authorize(rt.policy(), h)
+        if !rt.unverified {
+            h = handlers.RequireVerifiedEmail(h)
+        }
+        h = middleware.AuthMiddleware(h)
+    }
+    return h
+}
+
+func routes() []route {
+    return []route{
+        // Public endpoints
+        {method: "POST", path: "/register", handler: handlers.HandleRegister, public: true, legacy: "/api/register"},
+        {method: "POST", path: "/login", handler: handlers.HandleLogin, public: true, legacy: "/api/login"},
+        {method: "POST", path: "/login/2fa", handler: handlers.HandleLoginTwoFactor, public: true, legacy: "/api/login/2fa"},
+        {method: "POST", path: "/password/forgot", handler: handlers.HandleForgotPassword, public: true},
+        {method: "POST", path: "/password/reset", handler: handlers.HandleResetPassword, public: true},
+        {method: "POST", path: "/verify", handler: handlers.HandleVerifyEmail, public: true, legacy: "/api/verify"},
+        {method: "POST", path: "/verify/resend", handler: handlers.HandleResendVerification, public: true, legacy: "/api/verify/resend"},
+        {method: "POST", path: "/oidc/login", handler: handlers.HandleOIDCLogin, public: true},
+        {method: "POST", path: "/oidc/callback", handler: handlers.HandleOIDCCallback, public: true},
+        {method: "GET", path: "/shared/{token}", handler: handlers.HandleViewSharedPortfolio, public: true},
+
+        // Account
+        {method: "PUT", path: "/password", handler: handlers.HandleChangePassword, unverified: true, scope: models.ScopeSession, role: models.RoleViewer},
+        {method: "GET", path: "/2fa", handler: handlers.HandleTwoFactorStatus, unverified: true, scope: models.ScopeSession, role: models.RoleViewer},
+        {method: "POST", path: "/2fa/totp", handler: handlers.HandleTOTPSetup, unverified: true, scope: models.ScopeSession, role: models.RoleViewer},
+        {method: "POST", path: "/2fa/totp/enable", handler: handlers.HandleTOTPEnable, unverified: true, scope: models.ScopeSession, role: models.RoleViewer},
+        {method: "DELETE", path: "/2fa/totp", handler: handlers.HandleTOTPDisable, unverified: true, scope: models.ScopeSession, role: models.RoleViewer},
+        {method: "GET", path: "/api-keys", handler: handlers.HandleListAPIKeys, scope: models.ScopeSession},
+        {method: "POST", path: "/api-keys", handler: handlers.HandleCreateAPIKey, scope: models.ScopeSession, role: models.RoleViewer},
+        {method: "DELETE", path: "/api-keys/{id}", handler: handlers.HandleDeleteAPIKey, scope: models.ScopeSession, role: models.RoleViewer},
+        {method: "GET", path: "/invites", handler: handlers.HandleListMyInvites, scope: models.ScopeSession},
+        {method: "POST", path: "/invites/{inviteID}/accept", handler: handlers.HandleAcceptInvite, scope: models.ScopeSession, role: models.RoleViewer},
+        {method: "GET", path: "/identities", handler: handlers.HandleListIdentities, unverified: true, scope: models.ScopeSession},
+        {method: "DELETE", path: "/identities/{id}", handler: handlers.HandleDeleteIdentity, unverified: true, scope: models.ScopeSession, role: models.RoleViewer},
+
+        // Market data
+        {method: "GET", path: "/quote", handler: handlers.HandleCurrentPrice, legacy: "/api/quote"},
+        {method: "GET", path: "/quotes/{symbol}", handler: handlers.HandleCurrentPrice},
+        {method: "GET", path: "/stock", handler: handlers.HandleStockPrice, legacy: "/api/stock"},
+        {method: "GET", path: "/history/{symbol}", handler: handlers.HandleStockPrice},
+
+        // Default portfolio, selected with ?portfolio= on the legacy paths
+        {method: "GET", path: "/stocks", handler: handlers.HandleListStocks, legacy: "/api/stocks"},
+        {method: "POST", path: "/stocks", handler: handlers.HandleUploadStocks, legacy: "/api/stocks", scope: models.ScopeImport},
+        {method: "GET", path: "/corporate-actions", handler: handlers.HandleListCorporateActions, legacy: "/api/corporate-actions"},
+        {method: "POST", path: "/corporate-actions", handler: handlers.HandleCreateCorporateAction, legacy: "/api/corporate-actions"},
+        {method: "GET", path: "/income", handler: handlers.HandleDividendIncome, legacy: "/api/income"},
+        {method: "GET", path: "/cash", handler: handlers.HandleListCash, legacy: "/api/cash"},
+        {method: "POST", path: "/cash", handler: handlers.HandleCreateCashOperation, legacy: "/api/cash"},
+        {method: "GET", path: "/portfolio/value", handler: handlers.HandlePortfolioValue, legacy: "/api/portfolio/value"},
+        {method: "GET", path: "/tax/pit38", handler: handlers.HandlePIT38, legacy: "/api/tax/pit38"},
+        {method: "GET", path: "/export", handler: handlers.HandleExport, legacy: "/api/export"},
+        {method: "GET", path: "/transactions", handler: handlers.HandleListTransactions, legacy: "/api/transactions"},
+        {method: "POST", path: "/transactions", handler: handlers.HandleCreateTransaction, legacy: "/api/transactions"},
+        {method: "PUT", path: "/transactions/{transactionID}", handler: handlers.HandleUpdateTransaction, legacy: "/api/transactions"},
+        {method: "DELETE", path: "/transactions/{transactionID}", handler: handlers.HandleDeleteTransaction, legacy: "/api/transactions"},
+        {method: "GET", path: "/transactions/audit", handler: handlers.HandleTransactionAudit, legacy: "/api/transactions/audit"},
+        {method: "GET", path: "/imports", handler: handlers.HandleListImports},
+        {method: "GET", path: "/imports/{id}", handler: handlers.HandleGetImport},
+        {method: "POST", path: "/imports/{id}/confirm", handler: handlers.HandleConfirmImport, scope: models.ScopeImport},
+
+        // Portfolio-scoped resources
+        {method: "GET", path: "/portfolios", handler: handlers.HandleListPortfolios, legacy: "/api/portfolios"},
+        {method: "POST", path: "/portfolios", handler: handlers.HandleCreatePortfolio, legacy: "/api/portfolios"},
+        {method: "GET", path: "/portfolios/{id}", handler: handlers.HandleGetPortfolio},
+        {method: "GET", path: "/portfolios/{id}/positions", handler: handlers.HandleListStocks},
+        {method: "POST", path: "/portfolios/{id}/positions", handler: handlers.HandleUploadStocks, scope: models.ScopeImport},
+        {method: "GET", path: "/portfolios/{id}/positions/{symbol}", handler: handlers.HandleGetPosition},
+        {method: "GET", path: "/portfolios/{id}/value", handler: handlers.HandlePortfolioValue},
+        {method: "GET", path: "/portfolios/{id}/cash", handler: handlers.HandleListCash},
+        {method: "POST", path: "/portfolios/{id}/cash", handler: handlers.HandleCreateCashOperation},
+        {method: "GET", path: "/portfolios/{id}/transactions", handler: handlers.HandleListTransactions},
+        {method: "POST", path: "/portfolios/{id}/transactions", handler: handlers.HandleCreateTransaction},
+        {method: "GET", path: "/portfolios/{id}/transactions/audit", handler: handlers.HandleTransactionAudit},
+        {method: "GET", path: "/portfolios/{id}/corporate-actions", handler: handlers.HandleListCorporateActions},
+        {method: "POST", path: "/portfolios/{id}/corporate-actions", handler: handlers.HandleCreateCorporateAction},
+        {method: "GET", path: "/portfolios/{id}/income", handler: handlers.HandleDividendIncome},
+        {method: "GET", path: "/portfolios/{id}/tax/pit38", handler: handlers.HandlePIT38},
+        {method: "GET", path: "/portfolios/{id}/export", handler: handlers.HandleExport},
+        {method: "GET", path: "/portfolios/{id}/activity", handler: handlers.HandlePortfolioActivity},
+        {method: "GET", path: "/portfolios/{id}/members", handler: handlers.HandleListMembers},
+        {method: "POST", path: "/portfolios/{id}/members", handler: handlers.HandleAddMember},
+        {method: "PUT", path: "/portfolios/{id}/members/{userID}", handler: handlers.HandleUpdateMember},
+        {method: "DELETE", path: "/portfolios/{id}/members/{userID}", handler: handlers.HandleRemoveMember},
+        {method: "GET", path: "/portfolios/{id}/invites", handler: handlers.HandleListInvites},
+        {method: "DELETE", path: "/portfolios/{id}/invites/{inviteID}", handler: handlers.HandleDeleteInvite},
+        {method: "GET", path: "/portfolios/{id}/share-links", handler: handlers.HandleListShareLinks},
+        {method: "POST", path: "/portfolios/{id}/share-links", handler: handlers.HandleCreateShareLink},
+        {method: "DELETE", path: "/portfolios/{id}/share-links/{linkID}", handler: handlers.HandleDeleteShareLink},
+
+        // Administration
+        {method: "GET", path: "/admin/users", handler: handlers.HandleAdminListUsers, role: models.RoleAdmin, scope: models.ScopeAdmin},
+        {method: "PUT", path: "/admin/users/{id}/role", handler: handlers.HandleAdminSetRole, role: models.RoleAdmin, scope: models.ScopeAdmin},
+        {method: "POST", path: "/admin/users/{id}/disable", handler: handlers.HandleAdminDisableUser, role: models.RoleAdmin, scope: models.ScopeAdmin},
+        {method: "POST", path: "/admin/users/{id}/enable", handler: handlers.HandleAdminEnableUser, role: models.RoleAdmin, scope: models.ScopeAdmin},
+        {method: "POST", path: "/admin/users/{id}/sessions/revoke", handler: handlers.HandleAdminRevokeSessions, role: models.RoleAdmin, scope: models.ScopeAdmin},
+        {method: "GET", path: "/admin/imports", handler: handlers.HandleAdminListImports, role: models.RoleAdmin, scope: models.ScopeAdmin},
+    }
+}
+
+type Router struct {
+    mux *http.ServeMux
+}
+
+func New() *Router {
+    mux := http.NewServeMux()
+    for _, rt := range routes() {
+        h := rt.chain()
+        mux.HandleFunc(rt.method+" "+APIPrefix+rt.path, h)
+        if rt.legacy != "" {
+            mux.HandleFunc(rt.method+" "+rt.legacy, h)
+        }
+    }
+    // Operational endpoints stay outside the versioned API
+    mux.HandleFunc("GET /healthz", handlers.HandleHealthz)
+    mux.HandleFunc("GET /readyz", handlers.HandleReadyz)
+    // Scraped with an admin API key
+    mux.HandleFunc("GET /metrics", route{method: "GET", handler: metrics.Handler().ServeHTTP,
+        role: models.RoleAdmin, scope: models.ScopeAdmin}.chain())
+    return &Router{mux: mux}
+}
+
+var methods = []string{"GET", "POST", "PUT", "PATCH", "DELETE"}
+
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    if _, pattern := rt.mux.Handler(r); pattern != "" {
+        if info := reqctx.RequestInfo(r.Context()); info != nil {
+            info.Route = pattern
+        }
+        rt.mux.ServeHTTP(w, r)
+        return
+    }
+
+    // No route for this method; find out whether the path exists at all
+    allowed := make([]string, 0)
+    for _, m := range methods {
+        probe := r.Clone(r.Context())
+        probe.Method = m
+        if _, pattern := rt.mux.Handler(probe); pattern != "" {
+            allowed = append(allowed, m)
+        }
+    }
+
+    if len(allowed) == 0 {
+        apierr.Write(w, r, apierr.NotFound(apierr.CodeNotFound, "No route matches "+r.URL.Path))
+        return
+    }
+
+    sort.Strings(allowed)
+    w.Header().Set("Allow", strings.Join(allowed, ", "))
+    apierr.Write(w, r, apierr.New(http.StatusMethodNotAllowed, apierr.CodeMethodNotAllowed,
+        r.Method+" is not allowed on "+r.URL.Path))
+}
\ No newline at end of file
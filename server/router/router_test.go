@@ -0,0 +1,83 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"server/middleware"
+	"server/models"
+	"server/reqctx"
+)
+
+// authorizeRoute runs the policy of the route registered for method and path
+// against principal and returns the response status; 204 means allowed
+func authorizeRoute(t *testing.T, method, path string, principal *reqctx.Principal) int {
+    t.Helper()
+    for _, rt := range routes() {
+        if rt.method != method || rt.path != path {
+            continue
+        }
+        h := middleware.Authorize(rt.policy(), func(w http.ResponseWriter, r *http.Request) {
+            w.WriteHeader(http.StatusNoContent)
+        })
+        req := httptest.NewRequest(method, APIPrefix+path, nil)
+        req = req.WithContext(reqctx.WithPrincipal(req.Context(), principal))
+        rec := httptest.NewRecorder()
+        h(rec, req)
+        return rec.Code
+    }
+    t.Fatalf("no route %s %s", method, path)
+    return 0
+}
+
+func keyPrincipal(role string, scopes ...string) *reqctx.Principal {
+    return &reqctx.Principal{UserID: 1, Role: role, EmailVerified: true,
+        APIKey: &models.APIKey{ID: 1, UserID: 1, Scopes: scopes}}
+}
+
+func TestAuthorize(t *testing.T) {
+    session := &reqctx.Principal{UserID: 1, Role: models.RoleUser, EmailVerified: true}
+    viewer := &reqctx.Principal{UserID: 1, Role: models.RoleViewer, EmailVerified: true}
+
+    tests := []struct {
+        name      string
+        method    string
+        path      string
+        principal *reqctx.Principal
+        want      int
+    }{
+        {"read key reads", "GET", "/transactions", keyPrincipal(models.RoleUser, models.ScopeRead), http.StatusNoContent},
+        {"read key posts", "POST", "/transactions", keyPrincipal(models.RoleUser, models.ScopeRead), http.StatusForbidden},
+        {"import key uploads", "POST", "/stocks", keyPrincipal(models.RoleUser, models.ScopeImport), http.StatusNoContent},
+        {"import key confirms an import", "POST", "/imports/{id}/confirm", keyPrincipal(models.RoleUser, models.ScopeImport), http.StatusNoContent},
+        {"import key posts a trade", "POST", "/transactions", keyPrincipal(models.RoleUser, models.ScopeImport), http.StatusForbidden},
+        {"import key reads", "GET", "/stocks", keyPrincipal(models.RoleUser, models.ScopeImport), http.StatusNoContent},
+        {"admin key posts a trade", "POST", "/transactions", keyPrincipal(models.RoleUser, models.ScopeAdmin), http.StatusNoContent},
+        {"admin key lists keys", "GET", "/api-keys", keyPrincipal(models.RoleUser, models.ScopeAdmin), http.StatusForbidden},
+        {"admin key creates a key", "POST", "/api-keys", keyPrincipal(models.RoleUser, models.ScopeAdmin), http.StatusForbidden},
+        {"read key deletes a key", "DELETE", "/api-keys/{id}", keyPrincipal(models.RoleUser, models.ScopeRead), http.StatusForbidden},
+        {"session creates a key", "POST", "/api-keys", session, http.StatusNoContent},
+        {"session posts a trade", "POST", "/transactions", session, http.StatusNoContent},
+        {"viewer reads", "GET", "/transactions", viewer, http.StatusNoContent},
+        {"viewer posts a trade", "POST", "/transactions", viewer, http.StatusForbidden},
+        {"viewer admin key posts a trade", "POST", "/transactions", keyPrincipal(models.RoleViewer, models.ScopeAdmin), http.StatusForbidden},
+        {"viewer import key uploads", "POST", "/stocks", keyPrincipal(models.RoleViewer, models.ScopeImport), http.StatusForbidden},
+        {"user on admin API", "GET", "/admin/users", session, http.StatusForbidden},
+        {"admin with admin key", "GET", "/admin/users", keyPrincipal(models.RoleAdmin, models.ScopeAdmin), http.StatusNoContent},
+        {"admin with read key", "GET", "/admin/users", keyPrincipal(models.RoleAdmin, models.ScopeRead), http.StatusForbidden},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if got := authorizeRoute(t, tt.method, tt.path, tt.principal); got != tt.want {
+                t.Errorf("%s %s: got %d, want %d", tt.method, tt.path, got, tt.want)
+            }
+        })
+    }
+}
+
+func TestUnauthenticated(t *testing.T) {
+    if got := authorizeRoute(t, "GET", "/transactions", nil); got != http.StatusUnauthorized {
+        t.Errorf("got %d, want 401", got)
+    }
+}
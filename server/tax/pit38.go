@@ -0,0 +1,450 @@
+package tax
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"server/models"
+	"server/nbp"
+	"server/utils"
+)
+
+const Rate = 0.19
+
+type RealizedLot struct {
+    Symbol       string  `json:"symbol"`
+    Currency     string  `json:"currency"`
+    Quantity     float64 `json:"quantity"`
+    BuyDate      string  `json:"buyDate"`
+    BuyPrice     float64 `json:"buyPrice"`
+    BuyRate      float64 `json:"buyRate"`
+    BuyRateDate  string  `json:"buyRateDate"`
+    SellDate     string  `json:"sellDate"`
+    SellPrice    float64 `json:"sellPrice"`
+    SellRate     float64 `json:"sellRate"`
+    SellRateDate string  `json:"sellRateDate"`
+    CostPLN      float64 `json:"costPln"`
+    ProceedsPLN  float64 `json:"proceedsPln"`
+    GainPLN      float64 `json:"gainPln"`
+}
+
+type DividendItem struct {
+    Symbol      string  `json:"symbol"`
+    Date        string  `json:"date"`
+    Currency    string  `json:"currency"`
+    Gross       float64 `json:"gross"`
+    Rate        float64 `json:"rate"`
+    RateDate    string  `json:"rateDate"`
+    GrossPLN    float64 `json:"grossPln"`
+    WithheldPLN float64 `json:"withheldPln"`
+    TaxDuePLN   float64 `json:"taxDuePln"`
+    ToPayPLN    float64 `json:"toPayPln"`
+}
+
+type Summary struct {
+    Revenue          float64 `json:"revenue"`
+    Costs            float64 `json:"costs"`
+    Income           float64 `json:"income"`
+    Loss             float64 `json:"loss"`
+    TaxBase          float64 `json:"taxBase"`
+    Tax              float64 `json:"tax"`
+    DividendGross    float64 `json:"dividendGross"`
+    DividendTaxDue   float64 `json:"dividendTaxDue"`
+    DividendWithheld float64 `json:"dividendWithheld"`
+    DividendToPay    float64 `json:"dividendToPay"`
+}
+
+type Report struct {
+    Year      int            `json:"year"`
+    Lots      []RealizedLot  `json:"lots"`
+    Dividends []DividendItem `json:"dividends"`
+    Summary   Summary        `json:"summary"`
+    Warnings  []string       `json:"warnings"`
+}
+
+type openLot struct {
+    time               time.Time
+    quantity           float64
+    price              float64
+    commission         float64 // Remaining buy commission, in commissionCurrency
+    commissionCurrency string
+}
+
+// Builds the realized gains (FIFO) and foreign dividend sections of PIT-38.
+// txs must contain the whole trade history so that lots bought in earlier
+// years can be matched against sales in year; actions supply the dividends
+// and the splits and spin-offs that adjust those lots, and cash the tax
+// withheld on the dividends.
+func BuildPIT38(ctx context.Context, year int, txs []models.Transaction,
+    actions []models.CorporateAction, cash []models.CashOperation) (*Report, error) {
+
+    report := &Report{
+        Year:      year,
+        Lots:      make([]RealizedLot, 0),
+        Dividends: make([]DividendItem, 0),
+        Warnings:  make([]string, 0),
+    }
+
+    events, err := ledgerEvents(txs, actions)
+    if err != nil {
+        return nil, err
+    }
+
+    lots := make(map[string][]*openLot)
+    for _, e := range events {
+        if a := e.action; a != nil {
+            adjustLots(lots[a.Symbol], a)
+            continue
+        }
+
+        t, when := *e.tx, e.when
+        if t.Side == models.SideBuy {
+            lots[t.Symbol] = append(lots[t.Symbol], &openLot{
+                time:               when,
+                quantity:           t.Quantity,
+                price:              t.Price,
+                commission:         t.Commission,
+                commissionCurrency: t.CommissionCurrency,
+            })
+            continue
+        }
+
+        if when.Year() > year {
+            break
+        }
+
+        realized, err := matchSell(ctx, t, when, lots, when.Year() == year)
+        if err != nil {
+            return nil, err
+        }
+        if when.Year() != year {
+            continue
+        }
+        if remaining := realized.unmatched; remaining > 0 {
+            report.Warnings = append(report.Warnings, fmt.Sprintf(
+                "%s: sold %.4f shares on %s without matching purchase history",
+                t.Symbol, remaining, when.Format("2006-01-02")))
+        }
+        report.Lots = append(report.Lots, realized.lots...)
+    }
+
+    for _, lot := range report.Lots {
+        report.Summary.Revenue += lot.ProceedsPLN
+        report.Summary.Costs += lot.CostPLN
+    }
+
+    if err := addDividends(ctx, report, actions, cash); err != nil {
+        return nil, err
+    }
+
+    s := &report.Summary
+    s.Revenue = utils.RoundToTwo(s.Revenue)
+    s.Costs = utils.RoundToTwo(s.Costs)
+    if s.Revenue >= s.Costs {
+        s.Income = utils.RoundToTwo(s.Revenue - s.Costs)
+    } else {
+        s.Loss = utils.RoundToTwo(s.Costs - s.Revenue)
+    }
+    // Tax base and tax are rounded to full złoty
+    s.TaxBase = math.Round(s.Income)
+    s.Tax = math.Round(s.TaxBase * Rate)
+
+    return report, nil
+}
+
+// A trade, or a split or spin-off that changes the lots bought before it
+type ledgerEvent struct {
+    when   time.Time
+    tx     *models.Transaction
+    action *models.CorporateAction
+}
+
+// Orders trades and corporate actions the way recomputing a position does:
+// by time, with trades before actions of the same instant.
+func ledgerEvents(txs []models.Transaction, actions []models.CorporateAction) ([]ledgerEvent, error) {
+    events := make([]ledgerEvent, 0, len(txs))
+    for i := range txs {
+        when, err := time.Parse("2006-01-02 15:04:05", txs[i].Time)
+        if err != nil {
+            return nil, fmt.Errorf("invalid time for transaction %d: %v", txs[i].ID, err)
+        }
+        events = append(events, ledgerEvent{when: when, tx: &txs[i]})
+    }
+    for i := range actions {
+        a := &actions[i]
+        if a.Type != models.ActionSplit && a.Type != models.ActionSpinOff {
+            continue
+        }
+        when, err := time.Parse("2006-01-02", a.ExDate)
+        if err != nil {
+            return nil, fmt.Errorf("invalid date for corporate action %d: %v", a.ID, err)
+        }
+        events = append(events, ledgerEvent{when: when, action: a})
+    }
+
+    sort.SliceStable(events, func(i, j int) bool {
+        if !events[i].when.Equal(events[j].when) {
+            return events[i].when.Before(events[j].when)
+        }
+        return events[i].tx != nil && events[j].tx == nil
+    })
+    return events, nil
+}
+
+// A split changes the share count of each lot but not its cost. A spin-off
+// moves part of the cost to the new shares, which enter the ledger as a
+// separate buy.
+func adjustLots(queue []*openLot, a *models.CorporateAction) {
+    for _, lot := range queue {
+        switch a.Type {
+        case models.ActionSplit:
+            if a.Ratio > 0 {
+                lot.quantity *= a.Ratio
+                lot.price /= a.Ratio
+            }
+        case models.ActionSpinOff:
+            lot.price *= 1 - a.CostFraction
+        }
+    }
+}
+
+type sellResult struct {
+    lots      []RealizedLot
+    unmatched float64
+}
+
+func matchSell(ctx context.Context, t models.Transaction, when time.Time,
+    lots map[string][]*openLot, report bool) (sellResult, error) {
+
+    var result sellResult
+    queue := lots[t.Symbol]
+    remaining := t.Quantity
+
+    var sellRate, sellCommissionRate nbp.Rate
+    if report {
+        var err error
+        if sellRate, err = nbp.MidRateBefore(ctx, t.Currency, when); err != nil {
+            return result, fmt.Errorf("%s rate for %s: %v", t.Currency, t.Symbol, err)
+        }
+        if sellCommissionRate, err = nbp.MidRateBefore(ctx, t.CommissionCurrency, when); err != nil {
+            return result, fmt.Errorf("%s rate for %s: %v", t.CommissionCurrency, t.Symbol, err)
+        }
+    }
+
+    for remaining > 1e-9 && len(queue) > 0 {
+        lot := queue[0]
+        qty := math.Min(remaining, lot.quantity)
+        lotCommission := lot.commission * qty / lot.quantity
+
+        if report {
+            buyRate, err := nbp.MidRateBefore(ctx, t.Currency, lot.time)
+            if err != nil {
+                return result, fmt.Errorf("%s rate for %s: %v", t.Currency, t.Symbol, err)
+            }
+            buyCommissionRate, err := nbp.MidRateBefore(ctx, lot.commissionCurrency, lot.time)
+            if err != nil {
+                return result, fmt.Errorf("%s rate for %s: %v", lot.commissionCurrency, t.Symbol, err)
+            }
+
+            sellCommission := t.Commission * qty / t.Quantity
+            cost := qty*lot.price*buyRate.Mid + lotCommission*buyCommissionRate.Mid +
+                sellCommission*sellCommissionRate.Mid
+            proceeds := qty * t.Price * sellRate.Mid
+
+            result.lots = append(result.lots, RealizedLot{
+                Symbol:       t.Symbol,
+                Currency:     strings.ToUpper(t.Currency),
+                Quantity:     qty,
+                BuyDate:      lot.time.Format("2006-01-02"),
+                BuyPrice:     lot.price,
+                BuyRate:      buyRate.Mid,
+                BuyRateDate:  buyRate.Date,
+                SellDate:     when.Format("2006-01-02"),
+                SellPrice:    t.Price,
+                SellRate:     sellRate.Mid,
+                SellRateDate: sellRate.Date,
+                CostPLN:      utils.RoundToTwo(cost),
+                ProceedsPLN:  utils.RoundToTwo(proceeds),
+                GainPLN:      utils.RoundToTwo(proceeds - cost),
+            })
+        }
+
+        lot.commission -= lotCommission
+        lot.quantity -= qty
+        remaining -= qty
+        if lot.quantity <= 1e-9 {
+            queue = queue[1:]
+        }
+    }
+
+    lots[t.Symbol] = queue
+    result.unmatched = remaining
+    if remaining <= 1e-9 {
+        result.unmatched = 0
+    }
+    return result, nil
+}
+
+func addDividends(ctx context.Context, report *Report, dividends []models.CorporateAction,
+    cash []models.CashOperation) error {
+
+    // An imported dividend's cash operation shares its ID and is booked on
+    // the day it was paid; manual ones only have the date entered
+    paidOn := make(map[string]string)
+    for _, op := range cash {
+        if op.Type == models.CashDividend && op.ExternalID != "" && len(op.Time) >= 10 {
+            paidOn[op.ExternalID] = op.Time[:10]
+        }
+    }
+
+    type payment struct {
+        dividend models.CorporateAction
+        date     string
+        withheld float64
+    }
+    payments := make([]*payment, 0)
+    for _, d := range dividends {
+        if d.Type != models.ActionDividend {
+            continue
+        }
+        p := &payment{dividend: d, date: d.ExDate}
+        if paid, ok := paidOn[d.ExternalID]; ok && d.ExternalID != "" {
+            p.date = paid
+        }
+        payments = append(payments, p)
+    }
+    sort.SliceStable(payments, func(i, j int) bool { return payments[i].date < payments[j].date })
+
+    // Withholding tax is booked as a separate cash operation on the payment
+    // day or soon after, so it goes to the last dividend paid by then
+    for _, op := range cash {
+        if op.Type != models.CashTax || op.Symbol == "" || len(op.Time) < 10 {
+            continue
+        }
+        booked := op.Time[:10]
+        var match *payment
+        for _, p := range payments {
+            if p.date > booked {
+                break
+            }
+            if p.dividend.Symbol == op.Symbol && p.dividend.Currency == op.Currency {
+                match = p
+            }
+        }
+        if match != nil {
+            match.withheld += -op.Amount
+        } else if strings.HasPrefix(booked, fmt.Sprint(report.Year)) {
+            report.Warnings = append(report.Warnings, fmt.Sprintf(
+                "%s: tax of %.2f %s booked on %s follows no dividend", op.Symbol, -op.Amount, op.Currency, booked))
+        }
+    }
+
+    s := &report.Summary
+    for _, p := range payments {
+        d := p.dividend
+        if !strings.HasPrefix(p.date, fmt.Sprint(report.Year)) {
+            continue
+        }
+
+        day, err := time.Parse("2006-01-02", p.date)
+        if err != nil {
+            return fmt.Errorf("invalid dividend date for %s: %v", d.Symbol, err)
+        }
+        rate, err := nbp.MidRateBefore(ctx, d.Currency, day)
+        if err != nil {
+            return fmt.Errorf("%s rate for %s dividend: %v", d.Currency, d.Symbol, err)
+        }
+
+        grossPLN := d.Total * rate.Mid
+        withheldPLN := p.withheld * rate.Mid
+        taxDue := grossPLN * Rate
+        // Only tax withheld up to the Polish rate can be credited
+        credit := math.Min(withheldPLN, taxDue)
+
+        item := DividendItem{
+            Symbol:      d.Symbol,
+            Date:        p.date,
+            Currency:    d.Currency,
+            Gross:       d.Total,
+            Rate:        rate.Mid,
+            RateDate:    rate.Date,
+            GrossPLN:    utils.RoundToTwo(grossPLN),
+            WithheldPLN: utils.RoundToTwo(withheldPLN),
+            TaxDuePLN:   utils.RoundToTwo(taxDue),
+            ToPayPLN:    utils.RoundToTwo(taxDue - credit),
+        }
+        report.Dividends = append(report.Dividends, item)
+
+        s.DividendGross += grossPLN
+        s.DividendTaxDue += taxDue
+        s.DividendWithheld += credit
+    }
+
+    s.DividendGross = utils.RoundToTwo(s.DividendGross)
+    s.DividendTaxDue = utils.RoundToTwo(s.DividendTaxDue)
+    s.DividendWithheld = utils.RoundToTwo(s.DividendWithheld)
+    s.DividendToPay = math.Round(math.Max(0, s.DividendTaxDue-s.DividendWithheld))
+
+    return nil
+}
+
+type FormField struct {
+    Label string  `json:"label"`
+    Value float64 `json:"value"`
+}
+
+type FormSection struct {
+    ID     string      `json:"id"`
+    Title  string      `json:"title"`
+    Fields []FormField `json:"fields"`
+}
+
+type Form struct {
+    Form     string        `json:"form"`
+    Year     int           `json:"year"`
+    Sections []FormSection `json:"sections"`
+}
+
+// Lays the summary out the way the PIT-38 form groups it, ready for rendering
+func (r *Report) Form() Form {
+    s := r.Summary
+    return Form{
+        Form: "PIT-38",
+        Year: r.Year,
+        Sections: []FormSection{
+            {
+                ID:    "C",
+                Title: "Dochody / straty - art. 30b ust. 1 ustawy",
+                Fields: []FormField{
+                    {Label: "Przychód", Value: s.Revenue},
+                    {Label: "Koszty uzyskania przychodów", Value: s.Costs},
+                    {Label: "Dochód", Value: s.Income},
+                    {Label: "Strata", Value: s.Loss},
+                },
+            },
+            {
+                ID:    "D",
+                Title: "Obliczenie zobowiązania podatkowego",
+                Fields: []FormField{
+                    {Label: "Podstawa obliczenia podatku", Value: s.TaxBase},
+                    {Label: "Stawka podatku (%)", Value: Rate * 100},
+                    {Label: "Podatek od dochodów z art. 30b ust. 1", Value: s.Tax},
+                },
+            },
+            {
+                ID:    "G",
+                Title: "Zryczałtowany podatek od dochodów zagranicznych - art. 30a ust. 1 pkt 1-5",
+                Fields: []FormField{
+                    {Label: "Przychód z dywidend (PLN)", Value: s.DividendGross},
+                    {Label: "Zryczałtowany podatek 19%", Value: s.DividendTaxDue},
+                    {Label: "Podatek zapłacony za granicą", Value: s.DividendWithheld},
+                    {Label: "Różnica do zapłaty", Value: s.DividendToPay},
+                },
+            },
+        },
+    }
+}
\ No newline at end of file
@@ -0,0 +1,205 @@
+package tax
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"server/models"
+	"server/nbp"
+)
+
+func trade(symbol, side, when string, quantity, price, commission float64) models.Transaction {
+    return models.Transaction{
+        Symbol:             symbol,
+        Side:               side,
+        Quantity:           quantity,
+        Price:              price,
+        Currency:           "PLN",
+        Commission:         commission,
+        CommissionCurrency: "PLN",
+        Time:               when,
+    }
+}
+
+func TestBuildPIT38Lots(t *testing.T) {
+    type lot struct {
+        symbol   string
+        quantity float64
+        cost     float64
+        proceeds float64
+    }
+
+    tests := []struct {
+        name     string
+        txs      []models.Transaction
+        actions  []models.CorporateAction
+        want     []lot
+        warnings int
+    }{
+        {
+            name: "partial lots with commissions",
+            txs: []models.Transaction{
+                trade("ABC", models.SideBuy, "2023-01-10 10:00:00", 10, 100, 10),
+                trade("ABC", models.SideBuy, "2023-02-10 10:00:00", 10, 120, 0),
+                trade("ABC", models.SideSell, "2024-03-01 10:00:00", 15, 130, 6),
+            },
+            want: []lot{
+                {"ABC", 10, 1014, 1300},
+                {"ABC", 5, 602, 650},
+            },
+        },
+        {
+            name: "split before sale",
+            txs: []models.Transaction{
+                trade("ABC", models.SideBuy, "2023-01-10 10:00:00", 10, 100, 0),
+                trade("ABC", models.SideSell, "2024-03-01 10:00:00", 40, 30, 0),
+            },
+            actions: []models.CorporateAction{
+                {Symbol: "ABC", Type: models.ActionSplit, ExDate: "2023-06-01", Ratio: 4},
+            },
+            want: []lot{
+                {"ABC", 40, 1000, 1200},
+            },
+        },
+        {
+            name: "split after a partial sale",
+            txs: []models.Transaction{
+                trade("ABC", models.SideBuy, "2023-01-10 10:00:00", 10, 100, 0),
+                trade("ABC", models.SideSell, "2023-03-01 10:00:00", 5, 110, 0),
+                trade("ABC", models.SideSell, "2024-03-01 10:00:00", 10, 60, 0),
+            },
+            actions: []models.CorporateAction{
+                {Symbol: "ABC", Type: models.ActionSplit, ExDate: "2023-06-01", Ratio: 2},
+            },
+            want: []lot{
+                {"ABC", 10, 500, 600},
+            },
+        },
+        {
+            name: "spin-off moves cost to the new shares",
+            txs: []models.Transaction{
+                trade("ABC", models.SideBuy, "2023-01-10 10:00:00", 10, 100, 0),
+                // Written by applySpinOff at the allocated cost
+                trade("NEW", models.SideBuy, "2023-06-01 00:00:00", 5, 40, 0),
+                trade("ABC", models.SideSell, "2024-03-01 10:00:00", 10, 90, 0),
+                trade("NEW", models.SideSell, "2024-03-02 10:00:00", 5, 50, 0),
+            },
+            actions: []models.CorporateAction{
+                {Symbol: "ABC", Type: models.ActionSpinOff, ExDate: "2023-06-01", Ratio: 0.5,
+                    NewSymbol: "NEW", CostFraction: 0.2},
+            },
+            want: []lot{
+                {"ABC", 10, 800, 900},
+                {"NEW", 5, 200, 250},
+            },
+        },
+        {
+            name: "sale without purchase history",
+            txs: []models.Transaction{
+                trade("ABC", models.SideBuy, "2023-01-10 10:00:00", 5, 100, 0),
+                trade("ABC", models.SideSell, "2024-03-01 10:00:00", 8, 100, 0),
+            },
+            want: []lot{
+                {"ABC", 5, 500, 500},
+            },
+            warnings: 1,
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            report, err := BuildPIT38(context.Background(), 2024, tt.txs, tt.actions, nil)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if len(report.Warnings) != tt.warnings {
+                t.Errorf("warnings = %v, want %d", report.Warnings, tt.warnings)
+            }
+            if len(report.Lots) != len(tt.want) {
+                t.Fatalf("got %d lots, want %d: %+v", len(report.Lots), len(tt.want), report.Lots)
+            }
+
+            var costs, revenue float64
+            for i, want := range tt.want {
+                got := report.Lots[i]
+                if got.Symbol != want.symbol || math.Abs(got.Quantity-want.quantity) > 1e-9 ||
+                    got.CostPLN != want.cost || got.ProceedsPLN != want.proceeds {
+                    t.Errorf("lot %d = %s %v cost %v proceeds %v, want %+v",
+                        i, got.Symbol, got.Quantity, got.CostPLN, got.ProceedsPLN, want)
+                }
+                costs += want.cost
+                revenue += want.proceeds
+            }
+            if report.Summary.Costs != costs || report.Summary.Revenue != revenue {
+                t.Errorf("summary costs %v revenue %v, want %v and %v",
+                    report.Summary.Costs, report.Summary.Revenue, costs, revenue)
+            }
+        })
+    }
+}
+func TestPIT38DividendWithholding(t *testing.T) {
+    // Every USD rate is 4 PLN; the path records which day was asked for
+    var paths []string
+    stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        paths = append(paths, r.URL.Path)
+        fmt.Fprint(w, `{"rates":[{"no":"001/A/NBP/2024","effectiveDate":"2024-01-02","mid":4}]}`)
+    }))
+    defer stub.Close()
+    nbp.Configure(stub.URL)
+    defer nbp.Configure("https://api.nbp.pl")
+
+    actions := []models.CorporateAction{
+        // Imported: the statement booked it on the 15th
+        {Symbol: "AAPL.US", Type: models.ActionDividend, ExDate: "2024-03-12", Total: 10, Currency: "USD", ExternalID: "501"},
+        // Manual, with its tax entered two days later
+        {Symbol: "MSFT.US", Type: models.ActionDividend, ExDate: "2024-06-13", Total: 20, Currency: "USD"},
+        // Paid the year before, with its tax booked in January
+        {Symbol: "KO.US", Type: models.ActionDividend, ExDate: "2023-12-29", Total: 5, Currency: "USD"},
+    }
+    cash := []models.CashOperation{
+        {Type: models.CashDividend, Symbol: "AAPL.US", Currency: "USD", Amount: 10, Time: "2024-03-15 10:00:00", ExternalID: "501"},
+        {Type: models.CashTax, Symbol: "AAPL.US", Currency: "USD", Amount: -1.5, Time: "2024-03-18 09:00:00", ExternalID: "502"},
+        {Type: models.CashTax, Symbol: "MSFT.US", Currency: "USD", Amount: -3, Time: "2024-06-15 00:00:00"},
+        {Type: models.CashTax, Symbol: "KO.US", Currency: "USD", Amount: -0.75, Time: "2024-01-03 09:00:00"},
+        // Nothing of this symbol was paid
+        {Type: models.CashTax, Symbol: "T.US", Currency: "USD", Amount: -1, Time: "2024-05-01 09:00:00"},
+    }
+
+    report, err := BuildPIT38(context.Background(), 2024, nil, actions, cash)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    want := []DividendItem{
+        {Symbol: "AAPL.US", Date: "2024-03-15", Currency: "USD", Gross: 10, Rate: 4, RateDate: "2024-01-02",
+            GrossPLN: 40, WithheldPLN: 6, TaxDuePLN: 7.6, ToPayPLN: 1.6},
+        {Symbol: "MSFT.US", Date: "2024-06-13", Currency: "USD", Gross: 20, Rate: 4, RateDate: "2024-01-02",
+            GrossPLN: 80, WithheldPLN: 12, TaxDuePLN: 15.2, ToPayPLN: 3.2},
+    }
+    if len(report.Dividends) != len(want) {
+        t.Fatalf("dividends = %+v, want %d", report.Dividends, len(want))
+    }
+    for i, w := range want {
+        if got := report.Dividends[i]; got != w {
+            t.Errorf("dividend %d = %+v, want %+v", i, got, w)
+        }
+    }
+
+    // The rate is from before the payment, not the ex-date
+    if len(paths) == 0 || !strings.Contains(paths[0], "/usd/2024-03-05/2024-03-14/") {
+        t.Errorf("rate requests = %v", paths)
+    }
+
+    s := report.Summary
+    if s.DividendGross != 120 || s.DividendTaxDue != 22.8 || s.DividendWithheld != 18 || s.DividendToPay != 5 {
+        t.Errorf("summary = %+v", s)
+    }
+    if len(report.Warnings) != 1 || !strings.Contains(report.Warnings[0], "T.US") {
+        t.Errorf("warnings = %v, want one for T.US", report.Warnings)
+    }
+}
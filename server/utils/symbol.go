@@ -0,0 +1,39 @@
+package utils
+
+import "strings"
+
+var currencyBySuffix = map[string]string{
+    "PL": "PLN",
+    "US": "USD",
+    "UK": "GBP",
+    "DE": "EUR",
+    "FR": "EUR",
+    "NL": "EUR",
+    "IT": "EUR",
+    "ES": "EUR",
+    "PT": "EUR",
+    "BE": "EUR",
+    "FI": "EUR",
+    "CH": "CHF",
+    "DK": "DKK",
+    "NO": "NOK",
+    "SE": "SEK",
+    "CZ": "CZK",
+}
+
+func CurrencyForSymbol(symbol string) string {
+    idx := strings.LastIndex(symbol, ".")
+    if idx < 0 {
+        return "PLN"
+    }
+    if currency, ok := currencyBySuffix[strings.ToUpper(symbol[idx+1:])]; ok {
+        return currency
+    }
+    return "PLN"
+}
+
+// Stooq lists Warsaw tickers without the ".pl" suffix
+func StooqSymbol(symbol string) string {
+    symbol = strings.ToLower(symbol)
+    return strings.TrimSuffix(symbol, ".pl")
+}
\ No newline at end of file